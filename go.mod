@@ -1,6 +1,6 @@
 module github.com/fdingiit/mpl
 
-go 1.15
+go 1.21
 
 require (
 	github.com/stretchr/testify v1.7.1
@@ -8,3 +8,13 @@ require (
 	mosn.io/mosn v0.27.0
 	mosn.io/pkg v0.0.0-20220331064139-949046a47fa2
 )
+
+require (
+	github.com/c2h5oh/datasize v0.0.0-20171227191756-4eba002a5eae // indirect
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/golang/protobuf v1.5.0 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	github.com/rcrowley/go-metrics v0.0.0-20200313005456-10cdbea86bc0 // indirect
+	google.golang.org/protobuf v1.27.1 // indirect
+	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b // indirect
+)
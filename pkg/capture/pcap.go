@@ -0,0 +1,323 @@
+package capture
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"sort"
+	"strconv"
+)
+
+// pcap magic numbers, microsecond and nanosecond resolution
+const (
+	pcapMagicMicro = 0xa1b2c3d4
+	pcapMagicNano  = 0xa1b23c4d
+
+	pcapHeaderLen       = 24
+	pcapRecordHeaderLen = 16
+)
+
+// link types supported by ReadPcap
+const (
+	LinkTypeNull     = 0
+	LinkTypeEthernet = 1
+	LinkTypeRaw      = 101
+	LinkTypeLinuxSLL = 113
+	LinkTypeIPv4     = 228
+	LinkTypeIPv6     = 229
+)
+
+var ErrNotPcap = errors.New("[capture] not a pcap file")
+
+// Stream is the reassembled payload of one direction of a tcp connection.
+type Stream struct {
+	Src, Dst string
+	Data     []byte
+
+	// Gaps counts the holes left by segments missing from the capture, the
+	// bytes after a hole are appended as if they were contiguous.
+	Gaps int
+}
+
+func (s *Stream) Flow() string {
+	return s.Src + " -> " + s.Dst
+}
+
+// IsPcap reports whether data starts with a pcap file header.
+func IsPcap(data []byte) bool {
+	_, err := pcapByteOrder(data)
+	return err == nil
+}
+
+// ReadPcap parses a classic libpcap file and returns its tcp streams in order
+// of first appearance. Segments are reassembled by sequence number, so
+// retransmissions and reordering in the capture are tolerated.
+func ReadPcap(r io.Reader) ([]*Stream, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	order, err := pcapByteOrder(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < pcapHeaderLen {
+		return nil, fmt.Errorf("%w: truncated file header", ErrNotPcap)
+	}
+	linkType := order.Uint32(data[20:24]) & 0x0fffffff
+
+	asm := newAssembler()
+	for off := pcapHeaderLen; off < len(data); {
+		if len(data)-off < pcapRecordHeaderLen {
+			return nil, fmt.Errorf("[capture] truncated pcap record header at offset %d", off)
+		}
+		inclLen := int(order.Uint32(data[off+8 : off+12]))
+		off += pcapRecordHeaderLen
+		if inclLen > len(data)-off {
+			return nil, fmt.Errorf("[capture] truncated pcap record at offset %d: %d bytes declared, %d present", off, inclLen, len(data)-off)
+		}
+
+		packet := data[off : off+inclLen]
+		off += inclLen
+
+		seg, ok := parsePacket(linkType, packet)
+		if ok {
+			asm.add(seg)
+		}
+	}
+
+	return asm.streams(), nil
+}
+
+func pcapByteOrder(data []byte) (binary.ByteOrder, error) {
+	if len(data) < 4 {
+		return nil, ErrNotPcap
+	}
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		switch order.Uint32(data[:4]) {
+		case pcapMagicMicro, pcapMagicNano:
+			return order, nil
+		}
+	}
+	return nil, ErrNotPcap
+}
+
+type segment struct {
+	src, dst string
+	seq      uint32
+	syn      bool
+	payload  []byte
+}
+
+// parsePacket extracts the tcp segment of a link-layer packet, non-tcp
+// packets and ip fragments are skipped.
+func parsePacket(linkType uint32, packet []byte) (segment, bool) {
+	var ethType uint16
+	switch linkType {
+	case LinkTypeEthernet:
+		if len(packet) < 14 {
+			return segment{}, false
+		}
+		ethType = binary.BigEndian.Uint16(packet[12:14])
+		packet = packet[14:]
+		// skip 802.1Q vlan tags
+		for ethType == 0x8100 && len(packet) >= 4 {
+			ethType = binary.BigEndian.Uint16(packet[2:4])
+			packet = packet[4:]
+		}
+	case LinkTypeLinuxSLL:
+		if len(packet) < 16 {
+			return segment{}, false
+		}
+		ethType = binary.BigEndian.Uint16(packet[14:16])
+		packet = packet[16:]
+	case LinkTypeNull:
+		if len(packet) < 4 {
+			return segment{}, false
+		}
+		// the address family is in host byte order of the capturing machine
+		family := binary.LittleEndian.Uint32(packet[:4])
+		if family > 0xffff {
+			family = binary.BigEndian.Uint32(packet[:4])
+		}
+		packet = packet[4:]
+		switch family {
+		case 2:
+			ethType = 0x0800
+		case 24, 28, 30:
+			ethType = 0x86dd
+		}
+	case LinkTypeRaw, LinkTypeIPv4, LinkTypeIPv6:
+		if len(packet) == 0 {
+			return segment{}, false
+		}
+		switch packet[0] >> 4 {
+		case 4:
+			ethType = 0x0800
+		case 6:
+			ethType = 0x86dd
+		}
+	default:
+		return segment{}, false
+	}
+
+	var srcIP, dstIP net.IP
+	var tcp []byte
+	switch ethType {
+	case 0x0800:
+		if len(packet) < 20 || packet[0]>>4 != 4 {
+			return segment{}, false
+		}
+		ihl := int(packet[0]&0x0f) * 4
+		total := int(binary.BigEndian.Uint16(packet[2:4]))
+		flagsFrag := binary.BigEndian.Uint16(packet[6:8])
+		if packet[9] != 6 || ihl < 20 || total < ihl || total > len(packet) || flagsFrag&0x3fff != 0 {
+			return segment{}, false
+		}
+		srcIP, dstIP = net.IP(packet[12:16]), net.IP(packet[16:20])
+		tcp = packet[ihl:total]
+	case 0x86dd:
+		if len(packet) < 40 || packet[6] != 6 {
+			return segment{}, false
+		}
+		payloadLen := int(binary.BigEndian.Uint16(packet[4:6]))
+		if 40+payloadLen > len(packet) {
+			return segment{}, false
+		}
+		srcIP, dstIP = net.IP(packet[8:24]), net.IP(packet[24:40])
+		tcp = packet[40 : 40+payloadLen]
+	default:
+		return segment{}, false
+	}
+
+	if len(tcp) < 20 {
+		return segment{}, false
+	}
+	dataOff := int(tcp[12]>>4) * 4
+	if dataOff < 20 || dataOff > len(tcp) {
+		return segment{}, false
+	}
+	flags := tcp[13]
+
+	return segment{
+		src:     net.JoinHostPort(srcIP.String(), strconv.Itoa(int(binary.BigEndian.Uint16(tcp[0:2])))),
+		dst:     net.JoinHostPort(dstIP.String(), strconv.Itoa(int(binary.BigEndian.Uint16(tcp[2:4])))),
+		seq:     binary.BigEndian.Uint32(tcp[4:8]),
+		syn:     flags&0x02 != 0,
+		payload: tcp[dataOff:],
+	}, true
+}
+
+type flow struct {
+	stream  *Stream
+	started bool
+	next    uint32
+	pending map[uint32][]byte
+}
+
+type assembler struct {
+	flows map[string]*flow
+	order []*flow
+}
+
+func newAssembler() *assembler {
+	return &assembler{flows: make(map[string]*flow)}
+}
+
+func (a *assembler) add(seg segment) {
+	key := seg.src + ">" + seg.dst
+	f, ok := a.flows[key]
+	if !ok || (seg.syn && f.started && len(f.stream.Data) > 0) {
+		// a new syn on a used 4-tuple starts a new connection
+		f = &flow{
+			stream:  &Stream{Src: seg.src, Dst: seg.dst},
+			pending: make(map[uint32][]byte),
+		}
+		a.flows[key] = f
+		a.order = append(a.order, f)
+	}
+
+	if !f.started {
+		f.started = true
+		f.next = seg.seq
+		if seg.syn {
+			f.next++
+		}
+	}
+
+	seq := seg.seq
+	if seg.syn {
+		seq++
+	}
+	if len(seg.payload) == 0 {
+		return
+	}
+
+	f.push(seq, seg.payload)
+}
+
+func (f *flow) push(seq uint32, payload []byte) {
+	if diff := int32(seq - f.next); diff > 0 {
+		// out of order, keep it until the hole is filled
+		if prev, ok := f.pending[seq]; !ok || len(prev) < len(payload) {
+			f.pending[seq] = append([]byte(nil), payload...)
+		}
+		return
+	} else if diff < 0 {
+		// retransmission, keep only the bytes not seen yet
+		if -int(diff) >= len(payload) {
+			return
+		}
+		payload = payload[-int(diff):]
+	}
+
+	f.stream.Data = append(f.stream.Data, payload...)
+	f.next += uint32(len(payload))
+
+	for {
+		progressed := false
+		for s, p := range f.pending {
+			if int32(s-f.next) <= 0 {
+				delete(f.pending, s)
+				f.push(s, p)
+				progressed = true
+				break
+			}
+		}
+		if !progressed {
+			return
+		}
+	}
+}
+
+// flush appends the segments still waiting behind a hole in sequence order.
+func (f *flow) flush() {
+	for len(f.pending) > 0 {
+		seqs := make([]uint32, 0, len(f.pending))
+		for s := range f.pending {
+			seqs = append(seqs, s)
+		}
+		sort.Slice(seqs, func(i, j int) bool { return int32(seqs[i]-f.next) < int32(seqs[j]-f.next) })
+
+		f.stream.Gaps++
+		f.next = seqs[0]
+		p := f.pending[seqs[0]]
+		delete(f.pending, seqs[0])
+		f.push(seqs[0], p)
+	}
+}
+
+func (a *assembler) streams() []*Stream {
+	var streams []*Stream
+	for _, f := range a.order {
+		f.flush()
+		if len(f.stream.Data) > 0 {
+			streams = append(streams, f.stream)
+		}
+	}
+	return streams
+}
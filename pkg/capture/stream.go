@@ -0,0 +1,288 @@
+package capture
+
+import (
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/fdingiit/mpl/pkg/plugin/demo/codec"
+	"github.com/fdingiit/mpl/pkg/simple"
+	"mosn.io/pkg/buffer"
+	"mosn.io/pkg/header"
+)
+
+type Protocol string
+
+const (
+	ProtocolUnknown Protocol = "unknown"
+	ProtocolDemo    Protocol = "demo"
+	ProtocolSDBS    Protocol = "sdbs"
+)
+
+// sdbsHeaderLen is the length of the fixed-width SDBS header preceding the
+// xml body.
+const sdbsHeaderLen = 52
+
+// Record is one frame found in a stream, or a malformed region of it.
+type Record struct {
+	// Offset of the first byte of the record within the stream.
+	Offset int
+	// Length in bytes of the frame, or of the skipped region for a malformed one.
+	Length   int
+	Protocol Protocol
+
+	// Message is one of *codec.Request, *codec.Response, *simple.Request or
+	// *simple.Response, nil when Err is set.
+	Message interface{}
+
+	// Err explains why the bytes at Offset could not be decoded.
+	Err error
+}
+
+func (r Record) Malformed() bool {
+	return r.Err != nil
+}
+
+// DecodeStream splits one direction of a tcp stream into demo and SDBS frames.
+// Undecodable bytes are reported as malformed records and decoding resumes at
+// the next position where a frame may start.
+func DecodeStream(data []byte) []Record {
+	var records []Record
+
+	for off := 0; off < len(data); {
+		rec := decodeAt(data[off:])
+		rec.Offset = off
+
+		if rec.Err != nil {
+			// resynchronize on the next plausible frame start
+			rec.Length = len(data) - off
+			for next := off + 1; next < len(data); next++ {
+				if looksLikeFrame(data[next:]) {
+					rec.Length = next - off
+					break
+				}
+			}
+		}
+
+		records = append(records, rec)
+		off += rec.Length
+	}
+
+	return records
+}
+
+func decodeAt(data []byte) Record {
+	switch {
+	case data[0] == codec.Magic:
+		return decodeDemo(data)
+	case isDigit(data[0]):
+		return decodeSDBS(data)
+	default:
+		return Record{
+			Protocol: ProtocolUnknown,
+			Err:      fmt.Errorf("byte 0x%02x is neither the demo magic %q nor an SDBS length digit", data[0], codec.Magic),
+		}
+	}
+}
+
+// looksLikeFrame is a cheap check used to find where to resume after garbage.
+func looksLikeFrame(data []byte) bool {
+	if data[0] == codec.Magic {
+		return len(data) <= codec.DirIndex || data[codec.DirIndex] == codec.DirRequest || data[codec.DirIndex] == codec.DirResponse
+	}
+
+	if len(data) < 10 {
+		return false
+	}
+	for _, b := range data[:8] {
+		if !isDigit(b) {
+			return false
+		}
+	}
+	typ := string(data[8:10])
+	return typ == "RQ" || typ == "RS"
+}
+
+func decodeDemo(data []byte) Record {
+	rec := Record{Protocol: ProtocolDemo}
+
+	if len(data) <= codec.DirIndex {
+		rec.Err = fmt.Errorf("truncated demo frame: %d bytes, need %d to read the direction", len(data), codec.DirIndex+1)
+		return rec
+	}
+
+	headerLen, payloadIndex := codec.RequestHeaderLen, codec.RequestPayloadIndex
+	switch dir := data[codec.DirIndex]; dir {
+	case codec.DirRequest:
+	case codec.DirResponse:
+		headerLen, payloadIndex = codec.ResponseHeaderLen, codec.ResponsePayloadIndex
+	default:
+		rec.Err = fmt.Errorf("invalid demo direction %d, want %d (request) or %d (response)", dir, codec.DirRequest, codec.DirResponse)
+		return rec
+	}
+
+	if len(data) < headerLen {
+		rec.Err = fmt.Errorf("truncated demo header: %d bytes, need %d", len(data), headerLen)
+		return rec
+	}
+
+	payloadLen := binary.BigEndian.Uint32(data[payloadIndex:headerLen])
+	if payloadLen > codec.MaxPayloadLen {
+		rec.Err = fmt.Errorf("implausible demo payload length %d, limit is %d", payloadLen, codec.MaxPayloadLen)
+		return rec
+	}
+	frameLen := headerLen + int(payloadLen)
+	if len(data) < frameLen {
+		rec.Err = fmt.Errorf("truncated demo payload: header declares %d bytes, only %d present", payloadLen, len(data)-headerLen)
+		return rec
+	}
+
+	payload := make([]byte, payloadLen)
+	copy(payload, data[headerLen:frameLen])
+
+	req := codec.Request{
+		Type:         data[codec.TypeIndex],
+		RequestId:    binary.BigEndian.Uint32(data[codec.RequestIdIndex:codec.RequestPayloadIndex]),
+		PayloadLen:   payloadLen,
+		Payload:      buffer.NewIoBufferBytes(payload),
+		CommonHeader: header.CommonHeader{},
+	}
+
+	rec.Length = frameLen
+	if data[codec.DirIndex] == codec.DirRequest {
+		rec.Message = &req
+	} else {
+		rec.Message = &codec.Response{
+			Request: req,
+			Status:  binary.BigEndian.Uint16(data[codec.ResponseStatusIndex:codec.ResponsePayloadIndex]),
+		}
+	}
+	return rec
+}
+
+func decodeSDBS(data []byte) Record {
+	rec := Record{Protocol: ProtocolSDBS}
+
+	if len(data) < 8 {
+		rec.Err = fmt.Errorf("truncated SDBS length prefix: %d bytes, need 8", len(data))
+		return rec
+	}
+	totalLength, err := strconv.Atoi(string(data[:8]))
+	if err != nil {
+		rec.Err = fmt.Errorf("SDBS length prefix %q is not numeric", data[:8])
+		return rec
+	}
+	if totalLength < sdbsHeaderLen {
+		rec.Err = fmt.Errorf("SDBS total length %d is shorter than the %d-byte header", totalLength, sdbsHeaderLen)
+		return rec
+	}
+	if len(data) < totalLength {
+		rec.Err = fmt.Errorf("truncated SDBS message: total length %d, only %d bytes present", totalLength, len(data))
+		return rec
+	}
+
+	msg := data[:totalLength]
+	h, err := decodeSDBSHeader(msg)
+	if err == nil {
+		switch h.Type {
+		case "RQ":
+			req := &simple.Request{Header: h}
+			err = decodeSDBSBody(msg[sdbsHeaderLen:], req)
+			rec.Message = req
+		case "RS":
+			rsp := &simple.Response{Header: h}
+			err = decodeSDBSBody(msg[sdbsHeaderLen:], rsp)
+			rec.Message = rsp
+		default:
+			err = fmt.Errorf("unknown SDBS message type %q, want RQ or RS", h.Type)
+		}
+	}
+	if err != nil {
+		rec.Message = nil
+		rec.Err = fmt.Errorf("invalid SDBS message: %w", err)
+		return rec
+	}
+
+	rec.Length = totalLength
+	return rec
+}
+
+// decodeSDBSHeader reads the fixed-width fields of the SDBS header starting
+// msg, which holds at least sdbsHeaderLen bytes. The header is decoded here
+// rather than by pkg/simple so that the offending field can be named.
+func decodeSDBSHeader(msg []byte) (simple.Header, error) {
+	h := simple.Header{Type: string(msg[8:10]), Checksum: string(msg[11:43])}
+	var err error
+	if h.TotalLength, err = sdbsField(msg, 0, 8, "total length"); err != nil {
+		return h, err
+	}
+	if h.PageMark, err = sdbsField(msg, 10, 11, "page mark"); err != nil {
+		return h, err
+	}
+	if h.ServiceCode, err = sdbsField(msg, 43, 51, "service code"); err != nil {
+		return h, err
+	}
+	if h.Reserved, err = sdbsField(msg, 51, 52, "reserved"); err != nil {
+		return h, err
+	}
+	return h, nil
+}
+
+// sdbsField reads the numeric header field at msg[start:end].
+func sdbsField(msg []byte, start, end int, name string) (int, error) {
+	n, err := strconv.Atoi(string(msg[start:end]))
+	if err != nil {
+		return 0, fmt.Errorf("%s %q at offset %d is not numeric", name, msg[start:end], start)
+	}
+	return n, nil
+}
+
+// decodeSDBSBody unmarshals the xml body of an SDBS message, which has no
+// root element, into v.
+func decodeSDBSBody(body []byte, v interface{}) error {
+	data := make([]byte, 0, len(body)+len("<body></body>"))
+	data = append(data, "<body>"...)
+	data = append(data, body...)
+	data = append(data, "</body>"...)
+	return xml.Unmarshal(data, v)
+}
+
+func isDigit(b byte) bool {
+	return b >= '0' && b <= '9'
+}
+
+// Describe renders a one-line summary of the record.
+func (r Record) Describe() string {
+	if r.Err != nil {
+		return fmt.Sprintf("MALFORMED %s, %d bytes: %v", r.Protocol, r.Length, r.Err)
+	}
+
+	switch m := r.Message.(type) {
+	case *codec.Request:
+		return fmt.Sprintf("demo request id=%d type=%d payload=%s", m.RequestId, m.Type, quote(m.Payload.Bytes()))
+	case *codec.Response:
+		return fmt.Sprintf("demo response id=%d type=%d status=%d payload=%s", m.RequestId, m.Type, m.Status, quote(m.Payload.Bytes()))
+	case *simple.Request:
+		return fmt.Sprintf("sdbs request service=%d page=%d serial=%d out=%d/%d in=%d/%d amount=%d unit=%d currency=%d notes=%q",
+			m.ServiceCode, m.PageMark, m.SerialNo, m.OutBankId, m.OutAccountId,
+			m.InBankId, m.InAccountId, m.Amount, m.Unit, m.Currency, m.Notes)
+	case *simple.Response:
+		return fmt.Sprintf("sdbs response service=%d page=%d serial=%d err_code=%d message=%q",
+			m.ServiceCode, m.PageMark, m.SerialNo, m.ErrCode, m.Message)
+	default:
+		return fmt.Sprintf("%s %d bytes", r.Protocol, r.Length)
+	}
+}
+
+func quote(b []byte) string {
+	const max = 64
+	if len(b) > max {
+		return strconv.Quote(string(b[:max])) + fmt.Sprintf("...(%d bytes)", len(b))
+	}
+	if !utf8.Valid(b) {
+		return fmt.Sprintf("%x", b)
+	}
+	return strconv.Quote(string(b))
+}
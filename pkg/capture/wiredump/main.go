@@ -0,0 +1,90 @@
+// wiredump decodes demo and SDBS frames from captured traffic. The input is
+// either a pcap file or a raw dump of one direction of a tcp stream, detected
+// from its first bytes.
+//
+//	wiredump client.pcap
+//	wiredump -x stream.bin
+//	tcpdump -i lo -w - port 2045 | wiredump -
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/fdingiit/mpl/pkg/capture"
+)
+
+var (
+	hexDump   = flag.Bool("x", false, "hex dump malformed regions")
+	malformed = flag.Bool("malformed", false, "print only malformed records")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: wiredump [flags] <file|->")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	bad, err := run(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "wiredump:", err)
+		os.Exit(1)
+	}
+	if bad > 0 {
+		os.Exit(3)
+	}
+}
+
+func run(path string) (int, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(path)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var streams []*capture.Stream
+	if capture.IsPcap(data) {
+		if streams, err = capture.ReadPcap(bytes.NewReader(data)); err != nil {
+			return 0, err
+		}
+	} else {
+		streams = []*capture.Stream{{Src: path, Dst: "?", Data: data}}
+	}
+
+	bad := 0
+	for _, s := range streams {
+		fmt.Printf("== %s, %d bytes", s.Flow(), len(s.Data))
+		if s.Gaps > 0 {
+			fmt.Printf(", %d gaps in capture", s.Gaps)
+		}
+		fmt.Println()
+
+		for _, rec := range capture.DecodeStream(s.Data) {
+			if rec.Malformed() {
+				bad++
+			} else if *malformed {
+				continue
+			}
+
+			fmt.Printf("@%08d %s\n", rec.Offset, rec.Describe())
+			if rec.Malformed() && *hexDump {
+				fmt.Print(hex.Dump(s.Data[rec.Offset : rec.Offset+rec.Length]))
+			}
+		}
+	}
+	return bad, nil
+}
@@ -0,0 +1,163 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fdingiit/mpl/pkg/plugin/demo/codec"
+	"mosn.io/pkg/buffer"
+	"mosn.io/pkg/header"
+)
+
+const (
+	DefaultPoolSize          = 4
+	DefaultDialTimeout       = 3 * time.Second
+	DefaultHeartbeatInterval = 15 * time.Second
+	DefaultHeartbeatTimeout  = 3 * time.Second
+	DefaultReconnectInterval = time.Second
+)
+
+var (
+	ErrClientClosed = errors.New("[demo client] client closed")
+	ErrConnClosed   = errors.New("[demo client] connection closed")
+	ErrHeartbeat    = errors.New("[demo client] heartbeat failed")
+)
+
+// Options tunes a Client, zero values fall back to the defaults above.
+// A negative HeartbeatInterval disables heartbeats.
+type Options struct {
+	PoolSize          int
+	DialTimeout       time.Duration
+	HeartbeatInterval time.Duration
+	HeartbeatTimeout  time.Duration
+	ReconnectInterval time.Duration
+}
+
+func (o *Options) setDefaults() {
+	if o.PoolSize <= 0 {
+		o.PoolSize = DefaultPoolSize
+	}
+	if o.DialTimeout <= 0 {
+		o.DialTimeout = DefaultDialTimeout
+	}
+	if o.HeartbeatInterval == 0 {
+		o.HeartbeatInterval = DefaultHeartbeatInterval
+	}
+	if o.HeartbeatTimeout <= 0 {
+		o.HeartbeatTimeout = DefaultHeartbeatTimeout
+	}
+	if o.ReconnectInterval <= 0 {
+		o.ReconnectInterval = DefaultReconnectInterval
+	}
+}
+
+// Client is a demo protocol client. It keeps a fixed pool of connections to
+// one address and multiplexes concurrent calls over them by request id.
+// A Client is safe for concurrent use.
+type Client struct {
+	addr string
+	opts Options
+
+	proto codec.Proto
+	pool  []*conn
+
+	next      uint32
+	requestId uint32
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// Dial creates a Client for addr and establishes its first connection, so
+// that an unreachable address is reported immediately. The remaining
+// connections of the pool are dialed on demand.
+func Dial(addr string, opts Options) (*Client, error) {
+	opts.setDefaults()
+
+	c := &Client{
+		addr:   addr,
+		opts:   opts,
+		pool:   make([]*conn, opts.PoolSize),
+		closed: make(chan struct{}),
+	}
+	for i := range c.pool {
+		c.pool[i] = &conn{client: c}
+	}
+
+	if _, err := c.pool[0].session(); err != nil {
+		return nil, err
+	}
+
+	if opts.HeartbeatInterval > 0 {
+		for _, cn := range c.pool {
+			go cn.keepalive()
+		}
+	}
+
+	return c, nil
+}
+
+// Call sends a request frame of the given type carrying payload and waits for
+// the matching response, or until ctx is done.
+func (c *Client) Call(ctx context.Context, typ byte, payload []byte) (*codec.Response, error) {
+	req := &codec.Request{
+		Type:         typ,
+		CommonHeader: header.CommonHeader{},
+	}
+	if len(payload) > 0 {
+		req.Payload = buffer.NewIoBufferBytes(payload)
+	}
+	return c.Send(ctx, req)
+}
+
+// Send is like Call but takes a prepared request. Its request id is always
+// overwritten by the client.
+func (c *Client) Send(ctx context.Context, req *codec.Request) (*codec.Response, error) {
+	select {
+	case <-c.closed:
+		return nil, ErrClientClosed
+	default:
+	}
+
+	cn := c.pool[atomic.AddUint32(&c.next, 1)%uint32(len(c.pool))]
+	s, err := cn.session()
+	if err != nil {
+		return nil, err
+	}
+
+	req.RequestId = c.nextRequestId()
+	return s.roundTrip(ctx, req)
+}
+
+// Ping sends a heartbeat on every live connection of the pool.
+func (c *Client) Ping(ctx context.Context) error {
+	for _, cn := range c.pool {
+		s, err := cn.session()
+		if err != nil {
+			return err
+		}
+		if err := s.heartbeat(ctx, c.nextRequestId()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes all connections and fails the in-flight calls with
+// ErrClientClosed.
+func (c *Client) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		for _, cn := range c.pool {
+			cn.close(ErrClientClosed)
+		}
+	})
+	return nil
+}
+
+func (c *Client) nextRequestId() uint32 {
+	return atomic.AddUint32(&c.requestId, 1)
+}
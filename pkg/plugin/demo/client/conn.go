@@ -0,0 +1,261 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/fdingiit/mpl/pkg/plugin/demo/codec"
+	"mosn.io/pkg/buffer"
+	"mosn.io/pkg/header"
+)
+
+// conn is one slot of the pool. It owns at most one live session and
+// replaces it when the underlying connection breaks.
+type conn struct {
+	client *Client
+
+	mu       sync.Mutex
+	current  *session
+	lastDial time.Time
+	dialErr  error
+}
+
+// session returns the live session of the slot, dialing a new one if needed.
+// Redials are throttled by Options.ReconnectInterval.
+func (cn *conn) session() (*session, error) {
+	cn.mu.Lock()
+	defer cn.mu.Unlock()
+
+	select {
+	case <-cn.client.closed:
+		return nil, ErrClientClosed
+	default:
+	}
+
+	if cn.current != nil && !cn.current.isClosed() {
+		return cn.current, nil
+	}
+
+	if cn.dialErr != nil && time.Since(cn.lastDial) < cn.client.opts.ReconnectInterval {
+		return nil, cn.dialErr
+	}
+
+	cn.lastDial = time.Now()
+	nc, err := net.DialTimeout("tcp", cn.client.addr, cn.client.opts.DialTimeout)
+	if err != nil {
+		cn.dialErr = fmt.Errorf("[demo client] dial %s failed: %w", cn.client.addr, err)
+		return nil, cn.dialErr
+	}
+	cn.dialErr = nil
+
+	cn.current = newSession(cn.client, nc)
+	return cn.current, nil
+}
+
+// keepalive sends heartbeats on the current session and reconnects the slot
+// in background once a heartbeat fails.
+func (cn *conn) keepalive() {
+	ticker := time.NewTicker(cn.client.opts.HeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-cn.client.closed:
+			return
+		case <-ticker.C:
+		}
+
+		s, err := cn.session()
+		if err != nil {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), cn.client.opts.HeartbeatTimeout)
+		if err := s.heartbeat(ctx, cn.client.nextRequestId()); err != nil {
+			s.close(fmt.Errorf("%w: %v", ErrHeartbeat, err))
+		}
+		cancel()
+	}
+}
+
+func (cn *conn) close(err error) {
+	cn.mu.Lock()
+	defer cn.mu.Unlock()
+
+	if cn.current != nil {
+		cn.current.close(err)
+	}
+}
+
+// session is a single tcp connection with its in-flight calls.
+type session struct {
+	client *Client
+	nc     net.Conn
+
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	pending map[uint32]chan *codec.Response
+	err     error
+	done    chan struct{}
+}
+
+func newSession(c *Client, nc net.Conn) *session {
+	s := &session{
+		client:  c,
+		nc:      nc,
+		pending: make(map[uint32]chan *codec.Response),
+		done:    make(chan struct{}),
+	}
+	go s.readLoop()
+	return s
+}
+
+func (s *session) isClosed() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// roundTrip writes req and waits for the response carrying the same request id.
+func (s *session) roundTrip(ctx context.Context, req *codec.Request) (*codec.Response, error) {
+	ch := make(chan *codec.Response, 1)
+
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return nil, s.err
+	}
+	s.pending[req.RequestId] = ch
+	s.mu.Unlock()
+
+	if err := s.write(ctx, req); err != nil {
+		s.forget(req.RequestId)
+		s.close(err)
+		return nil, err
+	}
+
+	select {
+	case resp := <-ch:
+		return resp, nil
+	case <-ctx.Done():
+		s.forget(req.RequestId)
+		return nil, ctx.Err()
+	case <-s.done:
+		return nil, s.err
+	}
+}
+
+func (s *session) heartbeat(ctx context.Context, requestId uint32) error {
+	req := &codec.Request{
+		Type:         codec.TypeHeartbeat,
+		RequestId:    requestId,
+		CommonHeader: header.CommonHeader{},
+	}
+	resp, err := s.roundTrip(ctx, req)
+	if err != nil {
+		return err
+	}
+	if resp.Status != codec.ResponseStatusSuccess {
+		return fmt.Errorf("%w: status %d", ErrHeartbeat, resp.Status)
+	}
+	return nil
+}
+
+func (s *session) write(ctx context.Context, frame interface{}) error {
+	buf, err := s.client.proto.Encode(ctx, frame)
+	if err != nil {
+		return err
+	}
+	defer buffer.PutIoBuffer(buf)
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	deadline, _ := ctx.Deadline()
+	if err := s.nc.SetWriteDeadline(deadline); err != nil {
+		return err
+	}
+	_, err = s.nc.Write(buf.Bytes())
+	return err
+}
+
+func (s *session) forget(requestId uint32) {
+	s.mu.Lock()
+	delete(s.pending, requestId)
+	s.mu.Unlock()
+}
+
+// readLoop decodes the frames coming from the server until the connection
+// breaks. Bytes read along with an error are decoded before the error ends
+// the loop, the last response may arrive with the EOF.
+func (s *session) readLoop() {
+	buf := buffer.NewIoBuffer(4096)
+
+	for {
+		n, readErr := buf.ReadOnce(s.nc)
+		if n > 0 {
+			if err := s.dispatch(buf); err != nil {
+				s.close(err)
+				return
+			}
+		}
+		if readErr != nil {
+			s.close(fmt.Errorf("%w: %v", ErrConnClosed, readErr))
+			return
+		}
+	}
+}
+
+// dispatch decodes the complete frames in buf. Responses are routed to their
+// callers by request id, heartbeats initiated by the server are answered in
+// place.
+func (s *session) dispatch(buf buffer.IoBuffer) error {
+	for {
+		frame, err := s.client.proto.Decode(context.Background(), buf)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrConnClosed, err)
+		}
+		if frame == nil {
+			return nil
+		}
+
+		switch f := frame.(type) {
+		case *codec.Response:
+			s.mu.Lock()
+			ch, ok := s.pending[f.RequestId]
+			delete(s.pending, f.RequestId)
+			s.mu.Unlock()
+			if ok {
+				ch <- f
+			}
+		case *codec.Request:
+			if f.IsHeartbeatFrame() {
+				reply := s.client.proto.Reply(context.Background(), f)
+				if err := s.write(context.Background(), reply); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// close tears the session down, failing all pending calls with err.
+func (s *session) close(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return
+	}
+	s.err = err
+	s.pending = make(map[uint32]chan *codec.Response)
+	close(s.done)
+	s.nc.Close()
+}
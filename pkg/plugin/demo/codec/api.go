@@ -24,6 +24,9 @@ import (
 
 // NewRpcRequest is a utility function which build rpc Request object of codec protocol.
 func NewRpcRequest(headers header.CommonHeader, data api.IoBuffer) *Request {
+	if data == nil {
+		return nil
+	}
 	frame, err := decodeRequest(nil, data)
 	if err != nil {
 		return nil
@@ -45,6 +48,9 @@ func NewRpcRequest(headers header.CommonHeader, data api.IoBuffer) *Request {
 
 // NewRpcResponse is a utility function which build rpc Response object of codec protocol.
 func NewRpcResponse(headers header.CommonHeader, data api.IoBuffer) *Response {
+	if data == nil {
+		return nil
+	}
 	frame, err := decodeResponse(nil, data)
 	if err != nil {
 		return nil
@@ -31,7 +31,7 @@ type Request struct {
 }
 
 func (r *Request) IsHeartbeatFrame() bool {
-	return false
+	return r.Type == TypeHeartbeat
 }
 
 func (r *Request) GetTimeout() int32 {
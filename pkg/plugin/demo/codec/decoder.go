@@ -32,19 +32,30 @@ import (
 func decodeRequest(ctx context.Context, data api.IoBuffer) (cmd interface{}, err error) {
 	fmt.Printf("[in decodeRequest]\n")
 
+	if data == nil {
+		return nil, errors.New("[protocol][mychain] decode failed, nil buffer")
+	}
 	bytesLen := data.Len()
 	bytes := data.Bytes()
 
-	// 1. least bytes to decode header is RequestHeaderLen
+	// 0. reject frames of another protocol or direction as early as possible
+	if err := checkFrame(bytes, DirRequest); err != nil {
+		return nil, err
+	}
+
+	// 1. least bytes to decode header is RequestHeaderLen, wait for more data
 	if bytesLen < RequestHeaderLen {
-		return nil, errors.New("error request")
+		return nil, nil
 	}
 
-	// 2. least bytes to decode whole frame
+	// 2. least bytes to decode whole frame, wait for more data
 	payloadLen := binary.BigEndian.Uint32(bytes[RequestPayloadIndex:RequestHeaderLen])
+	if payloadLen > MaxPayloadLen {
+		return nil, fmt.Errorf("[protocol][mychain] decode failed, payload length %d exceeds %d", payloadLen, MaxPayloadLen)
+	}
 	frameLen := RequestHeaderLen + int(payloadLen)
 	if bytesLen < frameLen {
-		return nil, errors.New("error request")
+		return nil, nil
 	}
 	data.Drain(frameLen)
 
@@ -57,7 +68,7 @@ func decodeRequest(ctx context.Context, data api.IoBuffer) (cmd interface{}, err
 	}
 
 	//4. copy data for io multiplexing
-	request.Payload = buffer.NewIoBufferBytes(bytes[RequestHeaderLen:])
+	request.Payload = buffer.NewIoBufferBytes(copyPayload(bytes[RequestHeaderLen:frameLen]))
 
 	fmt.Printf("[out decodeRequest] payload: %s\n", request.Payload)
 
@@ -68,19 +79,30 @@ func decodeRequest(ctx context.Context, data api.IoBuffer) (cmd interface{}, err
 func decodeResponse(ctx context.Context, data api.IoBuffer) (cmd interface{}, err error) {
 	fmt.Printf("[in decodeResponse]\n")
 
+	if data == nil {
+		return nil, errors.New("[protocol][mychain] decode failed, nil buffer")
+	}
 	bytesLen := data.Len()
 	bytes := data.Bytes()
 
-	// 1. least bytes to decode header is ResponseHeaderLen
+	// 0. reject frames of another protocol or direction as early as possible
+	if err := checkFrame(bytes, DirResponse); err != nil {
+		return nil, err
+	}
+
+	// 1. least bytes to decode header is ResponseHeaderLen, wait for more data
 	if bytesLen < ResponseHeaderLen {
-		return nil, errors.New("error response")
+		return nil, nil
 	}
 
-	// 2. least bytes to decode whole frame
+	// 2. least bytes to decode whole frame, wait for more data
 	payloadLen := binary.BigEndian.Uint32(bytes[ResponsePayloadIndex:ResponseHeaderLen])
+	if payloadLen > MaxPayloadLen {
+		return nil, fmt.Errorf("[protocol][mychain] decode failed, payload length %d exceeds %d", payloadLen, MaxPayloadLen)
+	}
 	frameLen := ResponseHeaderLen + int(payloadLen)
 	if bytesLen < frameLen {
-		return nil, errors.New("error response")
+		return nil, nil
 	}
 	data.Drain(frameLen)
 
@@ -92,13 +114,32 @@ func decodeResponse(ctx context.Context, data api.IoBuffer) (cmd interface{}, er
 			PayloadLen:   payloadLen,
 			CommonHeader: header.CommonHeader{},
 		},
-		Status: ResponseStatusSuccess,
+		Status: binary.BigEndian.Uint16(bytes[ResponseStatusIndex:ResponsePayloadIndex]),
 	}
 
 	//4. copy data for io multiplexing
-	response.Payload = buffer.NewIoBufferBytes(bytes[ResponseHeaderLen:])
+	response.Payload = buffer.NewIoBufferBytes(copyPayload(bytes[ResponseHeaderLen:frameLen]))
 
 	fmt.Printf("[out decodeRequest] payload: %s\n", response.Payload)
 
 	return response, nil
 }
+
+// checkFrame validates the magic and direction bytes present in data.
+func checkFrame(data []byte, dir byte) error {
+	if len(data) > MagicIdx && data[MagicIdx] != Magic {
+		return fmt.Errorf("[protocol][mychain] decode failed, magic error = %d", data[MagicIdx])
+	}
+	if len(data) > DirIndex && data[DirIndex] != dir {
+		return fmt.Errorf("[protocol][mychain] decode failed, direction error = %d", data[DirIndex])
+	}
+	return nil
+}
+
+// copyPayload detaches the payload from the connection buffer, which is reused
+// for the following frames once drained.
+func copyPayload(b []byte) []byte {
+	payload := make([]byte, len(b))
+	copy(payload, b)
+	return payload
+}
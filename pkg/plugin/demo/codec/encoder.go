@@ -32,6 +32,7 @@ func encodeRequest(ctx context.Context, request *Request) (api.IoBuffer, error)
 	// 1. TODO: fast-path, use existed raw data
 
 	// 2.1 calculate frame length
+	request.PayloadLen = 0
 	if request.Payload != nil {
 		request.PayloadLen = uint32(len(request.Payload.Bytes()))
 	}
@@ -62,6 +63,7 @@ func encodeResponse(ctx context.Context, response *Response) (api.IoBuffer, erro
 	fmt.Printf("[in encodeResponse] response: %+v\n", response.Payload)
 
 	// 2.1 calculate frame length
+	response.PayloadLen = 0
 	if response.Payload != nil {
 		response.PayloadLen = uint32(len(response.Payload.Bytes()))
 	}
@@ -25,11 +25,14 @@ type Matcher struct{}
 
 // predicate codec header len and compare magic number
 func (exampleMatcher *Matcher) ExampleMatcher(data []byte) api.MatchResult {
-	if len(data) < RequestHeaderLen {
-		return api.MatchAgain
+	if len(data) > MagicIdx && data[MagicIdx] != Magic {
+		return api.MatchFailed
 	}
-	if data[MagicIdx] != Magic {
+	if len(data) > DirIndex && data[DirIndex] != DirRequest && data[DirIndex] != DirResponse {
 		return api.MatchFailed
 	}
+	if len(data) < RequestHeaderLen {
+		return api.MatchAgain
+	}
 	return api.MatchSuccess
 }
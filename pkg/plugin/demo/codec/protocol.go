@@ -24,6 +24,7 @@ import (
 	"sync/atomic"
 
 	"mosn.io/api"
+	"mosn.io/pkg/header"
 )
 
 /**
@@ -61,6 +62,10 @@ func (proto *Proto) Encode(ctx context.Context, model interface{}) (api.IoBuffer
 
 //读取二进制流，然后判断判断是否符合协议，再根据是request 还是responce 读取二进制流信息 返回封装好的request responce对象
 func (proto *Proto) Decode(ctx context.Context, data api.IoBuffer) (interface{}, error) {
+	if data == nil {
+		return nil, errors.New("[protocol][mychain] decode failed, nil buffer")
+	}
+
 	if data.Len() >= MinimalDecodeLen {
 		magic := data.Bytes()[MagicIdx]
 		dir := data.Bytes()[DirIndex]
@@ -87,13 +92,22 @@ func (proto *Proto) Decode(ctx context.Context, data api.IoBuffer) (interface{},
 
 // Heartbeater
 func (proto *Proto) Trigger(context context.Context, requestId uint64) api.XFrame {
-	// not supported for poc demo
-	return nil
+	return &Request{
+		Type:         TypeHeartbeat,
+		RequestId:    uint32(requestId),
+		CommonHeader: header.CommonHeader{},
+	}
 }
 
 func (proto *Proto) Reply(context context.Context, request api.XFrame) api.XRespFrame {
-	// not supported for poc demo
-	return nil
+	return &Response{
+		Request: Request{
+			Type:         TypeHeartbeat,
+			RequestId:    uint32(request.GetRequestId()),
+			CommonHeader: header.CommonHeader{},
+		},
+		Status: ResponseStatusSuccess,
+	}
 }
 
 // Hijacker
@@ -41,8 +41,11 @@ const (
 	ResponseHeaderLen int = 13
 	MinimalDecodeLen  int = RequestHeaderLen // minimal length for decoding
 
+	MaxPayloadLen uint32 = 16 << 20 // larger payloads are rejected instead of buffered
+
 	RequestIdIndex       = 3
 	RequestPayloadIndex  = 7
+	ResponseStatusIndex  = 7
 	ResponsePayloadIndex = 9
 	TypeIndex            = 1
 	DirIndex             = 2
@@ -0,0 +1,240 @@
+// democurl sends demo protocol requests to a server or a MOSN listener and
+// prints the decoded responses.
+//
+//	democurl -addr 127.0.0.1:2045 -d 'Hello World'
+//	democurl -addr 127.0.0.1:2045 -f request.bin -n 100 -c 10
+//	echo -n 'Hello World' | democurl -f -
+//	democurl -H 'service: echo' -d 'Hello World'
+//
+// Headers given with -H are set on the CommonHeader of the request frame.
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fdingiit/mpl/pkg/plugin/demo/client"
+	"github.com/fdingiit/mpl/pkg/plugin/demo/codec"
+	"mosn.io/pkg/buffer"
+	"mosn.io/pkg/header"
+)
+
+type headerFlags []string
+
+func (h *headerFlags) String() string {
+	return strings.Join(*h, ", ")
+}
+
+func (h *headerFlags) Set(v string) error {
+	if !strings.Contains(v, ":") {
+		return fmt.Errorf("header %q is not in 'key: value' form", v)
+	}
+	*h = append(*h, v)
+	return nil
+}
+
+var (
+	addr        = flag.String("addr", "127.0.0.1:2045", "address to connect to")
+	typ         = flag.String("type", "message", "request type: message, heartbeat, goaway or a number")
+	data        = flag.String("d", "", "inline request payload")
+	file        = flag.String("f", "", "read the request payload from a file, - for stdin")
+	count       = flag.Int("n", 1, "number of requests to send")
+	concurrency = flag.Int("c", 1, "number of requests in flight")
+	timeout     = flag.Duration("timeout", 3*time.Second, "timeout of each request")
+	hexDump     = flag.Bool("x", false, "print the response payload as a hex dump")
+	quiet       = flag.Bool("q", false, "print only the summary")
+	headers     headerFlags
+)
+
+func main() {
+	flag.Var(&headers, "H", "request header 'key: value', may be repeated")
+	flag.Parse()
+
+	if err := run(); err != nil {
+		fmt.Fprintln(os.Stderr, "democurl:", err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	reqType, err := parseType(*typ)
+	if err != nil {
+		return err
+	}
+
+	payload, err := readPayload()
+	if err != nil {
+		return err
+	}
+
+	if *count < 1 {
+		*count = 1
+	}
+	if *concurrency < 1 {
+		*concurrency = 1
+	}
+	if *concurrency > *count {
+		*concurrency = *count
+	}
+
+	cli, err := client.Dial(*addr, client.Options{
+		PoolSize:          *concurrency,
+		HeartbeatInterval: -1,
+	})
+	if err != nil {
+		return err
+	}
+	defer cli.Close()
+
+	var (
+		mu   sync.Mutex
+		st   stats
+		jobs = make(chan int)
+		wg   sync.WaitGroup
+	)
+	for i := 0; i < *concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for seq := range jobs {
+				rsp, cost, err := send(cli, reqType, payload)
+
+				mu.Lock()
+				st.add(rsp, cost, err)
+				if !*quiet {
+					printResult(seq, rsp, cost, err)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	start := time.Now()
+	for i := 0; i < *count; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	if *count > 1 || *quiet {
+		st.print(time.Since(start))
+	}
+	if st.failed > 0 {
+		return fmt.Errorf("%d of %d requests failed", st.failed, *count)
+	}
+	return nil
+}
+
+func parseType(s string) (byte, error) {
+	switch strings.ToLower(s) {
+	case "message":
+		return codec.TypeMessage, nil
+	case "heartbeat":
+		return codec.TypeHeartbeat, nil
+	case "goaway":
+		return codec.TypeGoAway, nil
+	}
+
+	n, err := strconv.ParseUint(s, 0, 8)
+	if err != nil {
+		return 0, fmt.Errorf("invalid request type %q", s)
+	}
+	return byte(n), nil
+}
+
+func readPayload() ([]byte, error) {
+	switch {
+	case *data != "" && *file != "":
+		return nil, fmt.Errorf("-d and -f are mutually exclusive")
+	case *file == "-":
+		return ioutil.ReadAll(os.Stdin)
+	case *file != "":
+		return ioutil.ReadFile(*file)
+	default:
+		return []byte(*data), nil
+	}
+}
+
+func send(cli *client.Client, reqType byte, payload []byte) (*codec.Response, time.Duration, error) {
+	req := &codec.Request{
+		Type:         reqType,
+		CommonHeader: header.CommonHeader{},
+	}
+	if len(payload) > 0 {
+		req.Payload = buffer.NewIoBufferBytes(payload)
+	}
+	for _, h := range headers {
+		kv := strings.SplitN(h, ":", 2)
+		req.Set(strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1]))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	start := time.Now()
+	rsp, err := cli.Send(ctx, req)
+	return rsp, time.Since(start), err
+}
+
+func printResult(seq int, rsp *codec.Response, cost time.Duration, err error) {
+	if err != nil {
+		fmt.Printf("#%d error: %v (%v)\n", seq, err, cost)
+		return
+	}
+
+	var body []byte
+	if rsp.Payload != nil {
+		body = rsp.Payload.Bytes()
+	}
+
+	fmt.Printf("#%d status: %d, request id: %d, type: %d, payload: %d bytes, time: %v\n",
+		seq, rsp.Status, rsp.RequestId, rsp.Type, len(body), cost)
+	if len(body) == 0 {
+		return
+	}
+	if *hexDump {
+		fmt.Print(hex.Dump(body))
+	} else {
+		fmt.Println(string(body))
+	}
+}
+
+type stats struct {
+	ok, failed, errStatus int
+	min, max, total       time.Duration
+}
+
+func (s *stats) add(rsp *codec.Response, cost time.Duration, err error) {
+	if err != nil {
+		s.failed++
+		return
+	}
+	if rsp.Status != codec.ResponseStatusSuccess {
+		s.errStatus++
+	}
+	s.ok++
+	s.total += cost
+	if s.min == 0 || cost < s.min {
+		s.min = cost
+	}
+	if cost > s.max {
+		s.max = cost
+	}
+}
+
+func (s *stats) print(elapsed time.Duration) {
+	fmt.Printf("--- %s ---\n", *addr)
+	fmt.Printf("%d requests, %d responses (%d with error status), %d failed, elapsed %v\n",
+		s.ok+s.failed, s.ok, s.errStatus, s.failed, elapsed)
+	if s.ok > 0 {
+		fmt.Printf("time min/avg/max = %v/%v/%v\n", s.min, s.total/time.Duration(s.ok), s.max)
+	}
+}
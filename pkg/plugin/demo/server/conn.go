@@ -0,0 +1,99 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/fdingiit/mpl/pkg/plugin/demo/codec"
+	"mosn.io/pkg/buffer"
+)
+
+type conn struct {
+	server *Server
+	nc     net.Conn
+
+	writeMu  sync.Mutex
+	inflight sync.WaitGroup
+}
+
+// serve reads frames until the peer goes away, the connection idles out or
+// the server shuts down. It returns only after every dispatched request has
+// been answered.
+func (c *conn) serve() {
+	defer c.server.untrackConn(c)
+	defer c.nc.Close()
+	defer c.inflight.Wait()
+
+	ctx := c.server.baseContext()
+	buf := buffer.NewIoBuffer(4096)
+
+	for {
+		if c.server.shuttingDown() {
+			return
+		}
+
+		var deadline time.Time
+		if c.server.IdleTimeout > 0 {
+			deadline = time.Now().Add(c.server.IdleTimeout)
+		}
+		if err := c.nc.SetReadDeadline(deadline); err != nil {
+			return
+		}
+
+		if _, err := buf.ReadOnce(c.nc); err != nil {
+			return
+		}
+
+		for {
+			frame, err := c.server.proto.Decode(ctx, buf)
+			if err != nil {
+				fmt.Printf("[demo server] decode error from %s: %v\n", c.nc.RemoteAddr(), err)
+				return
+			}
+			if frame == nil {
+				break
+			}
+
+			req, ok := frame.(*codec.Request)
+			if !ok {
+				fmt.Printf("[demo server] unexpected response frame from %s\n", c.nc.RemoteAddr())
+				return
+			}
+
+			c.inflight.Add(1)
+			go c.handle(ctx, req)
+		}
+	}
+}
+
+func (c *conn) handle(ctx context.Context, req *codec.Request) {
+	defer c.inflight.Done()
+
+	resp := c.server.serve(ctx, req)
+
+	out, err := c.server.proto.Encode(ctx, resp)
+	if err != nil {
+		fmt.Printf("[demo server] encode error for request %d: %v\n", req.RequestId, err)
+		return
+	}
+	defer buffer.PutIoBuffer(out)
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if _, err := c.nc.Write(out.Bytes()); err != nil {
+		fmt.Printf("[demo server] write error to %s: %v\n", c.nc.RemoteAddr(), err)
+	}
+}
+
+func errorResponse(err error) *codec.Response {
+	return &codec.Response{
+		Request: codec.Request{
+			Payload: buffer.NewIoBufferString(err.Error()),
+		},
+		Status: codec.ResponseStatusError,
+	}
+}
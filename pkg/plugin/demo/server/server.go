@@ -0,0 +1,285 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fdingiit/mpl/pkg/plugin/demo/codec"
+	"mosn.io/pkg/header"
+)
+
+var ErrServerClosed = errors.New("[demo server] server closed")
+
+// Handler serves one decoded request frame. The returned response only needs
+// to carry the payload and status, the server fills in type and request id.
+// A non-nil error is answered with ResponseStatusError and the error text as
+// payload.
+type Handler interface {
+	ServeDemo(ctx context.Context, req *codec.Request) (*codec.Response, error)
+}
+
+type HandlerFunc func(ctx context.Context, req *codec.Request) (*codec.Response, error)
+
+func (f HandlerFunc) ServeDemo(ctx context.Context, req *codec.Request) (*codec.Response, error) {
+	return f(ctx, req)
+}
+
+// Server accepts demo protocol connections and dispatches every request frame
+// to the handler registered for its type. Frames pipelined on a connection are
+// handled concurrently and their responses written as soon as they are ready.
+// Heartbeat frames are answered by the server itself.
+type Server struct {
+	// Addr is the tcp address to listen on for ListenAndServe.
+	Addr string
+
+	// HandlerTimeout bounds the context passed to handlers, zero means no limit.
+	HandlerTimeout time.Duration
+
+	// IdleTimeout closes connections without any incoming frame for this
+	// long, zero means no limit.
+	IdleTimeout time.Duration
+
+	proto codec.Proto
+
+	mu        sync.Mutex
+	handlers  map[byte]Handler
+	listeners map[net.Listener]struct{}
+	conns     map[*conn]struct{}
+	wg        sync.WaitGroup
+
+	inShutdown int32
+	ctx        context.Context
+	cancel     context.CancelFunc
+}
+
+// Handle registers h for request frames of type typ, replacing any previous one.
+func (s *Server) Handle(typ byte, h Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.handlers == nil {
+		s.handlers = make(map[byte]Handler)
+	}
+	s.handlers[typ] = h
+}
+
+func (s *Server) HandleFunc(typ byte, f func(ctx context.Context, req *codec.Request) (*codec.Response, error)) {
+	s.Handle(typ, HandlerFunc(f))
+}
+
+func (s *Server) handler(typ byte) Handler {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.handlers[typ]
+}
+
+func (s *Server) ListenAndServe() error {
+	if s.shuttingDown() {
+		return ErrServerClosed
+	}
+
+	l, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts connections on l until the server is shut down, in which
+// case ErrServerClosed is returned. l is closed on return.
+func (s *Server) Serve(l net.Listener) error {
+	if !s.trackListener(l) {
+		l.Close()
+		return ErrServerClosed
+	}
+	defer s.untrackListener(l)
+
+	var delay time.Duration
+	for {
+		nc, err := l.Accept()
+		if err != nil {
+			if s.shuttingDown() {
+				return ErrServerClosed
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				if delay == 0 {
+					delay = 5 * time.Millisecond
+				} else if delay *= 2; delay > time.Second {
+					delay = time.Second
+				}
+				fmt.Printf("[demo server] accept error: %v, retrying in %v\n", err, delay)
+				time.Sleep(delay)
+				continue
+			}
+			return err
+		}
+		delay = 0
+
+		c := &conn{server: s, nc: nc}
+		if !s.trackConn(c) {
+			nc.Close()
+			return ErrServerClosed
+		}
+		go c.serve()
+	}
+}
+
+// Shutdown stops accepting connections, stops reading new frames and waits for
+// the in-flight requests to be answered before closing the connections. If
+// ctx is done first, the remaining connections are closed forcibly and the
+// context error is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	atomic.StoreInt32(&s.inShutdown, 1)
+
+	s.mu.Lock()
+	for l := range s.listeners {
+		l.Close()
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		// wake up the readers blocked on idle connections, they re-check
+		// the shutdown flag before every read
+		s.mu.Lock()
+		for c := range s.conns {
+			c.nc.SetReadDeadline(time.Now())
+		}
+		s.mu.Unlock()
+
+		select {
+		case <-done:
+			return nil
+		case <-ctx.Done():
+			s.Close()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Close closes all listeners and connections immediately and cancels the
+// contexts of the running handlers.
+func (s *Server) Close() error {
+	atomic.StoreInt32(&s.inShutdown, 1)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cancel != nil {
+		s.cancel()
+	}
+	for l := range s.listeners {
+		l.Close()
+	}
+	for c := range s.conns {
+		c.nc.Close()
+	}
+	return nil
+}
+
+func (s *Server) shuttingDown() bool {
+	return atomic.LoadInt32(&s.inShutdown) != 0
+}
+
+func (s *Server) baseContext() context.Context {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ctx == nil {
+		s.ctx, s.cancel = context.WithCancel(context.Background())
+	}
+	return s.ctx
+}
+
+func (s *Server) trackListener(l net.Listener) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.shuttingDown() {
+		return false
+	}
+	if s.listeners == nil {
+		s.listeners = make(map[net.Listener]struct{})
+	}
+	s.listeners[l] = struct{}{}
+	return true
+}
+
+func (s *Server) untrackListener(l net.Listener) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	l.Close()
+	delete(s.listeners, l)
+}
+
+func (s *Server) trackConn(c *conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.shuttingDown() {
+		return false
+	}
+	if s.conns == nil {
+		s.conns = make(map[*conn]struct{})
+	}
+	s.conns[c] = struct{}{}
+	s.wg.Add(1)
+	return true
+}
+
+func (s *Server) untrackConn(c *conn) {
+	s.mu.Lock()
+	delete(s.conns, c)
+	s.mu.Unlock()
+
+	s.wg.Done()
+}
+
+// serve runs h for req and builds the response frame to write back.
+func (s *Server) serve(ctx context.Context, req *codec.Request) *codec.Response {
+	if req.IsHeartbeatFrame() {
+		return s.proto.Reply(ctx, req).(*codec.Response)
+	}
+
+	if s.HandlerTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.HandlerTimeout)
+		defer cancel()
+	}
+
+	var resp *codec.Response
+	var err error
+	if h := s.handler(req.Type); h != nil {
+		resp, err = h.ServeDemo(ctx, req)
+	} else {
+		err = fmt.Errorf("no handler for request type %d", req.Type)
+	}
+
+	if err != nil {
+		resp = errorResponse(err)
+	} else if resp == nil {
+		resp = &codec.Response{Status: codec.ResponseStatusSuccess}
+	}
+
+	resp.Type = req.Type
+	resp.RequestId = req.RequestId
+	if resp.CommonHeader == nil {
+		resp.CommonHeader = header.CommonHeader{}
+	}
+	return resp
+}
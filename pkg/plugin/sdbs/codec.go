@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+
+	"github.com/fdingiit/mpl/pkg/plugin/sdbs/codec"
+	"mosn.io/api"
+)
+
+type Codec struct {
+	statusMapping codec.StatusMapping
+
+	matcher codec.Matcher
+}
+
+// NewXProtocol returns a protocol per connection, it holds the pages of the
+// messages being assembled.
+func (r Codec) NewXProtocol(ctx context.Context) api.XProtocol {
+	return &codec.Proto{}
+}
+
+func (r Codec) ProtocolName() api.ProtocolName {
+	return codec.ProtocolName
+}
+
+func (r Codec) ProtocolMatch() api.ProtocolMatch {
+	return r.matcher.SDBSMatcher
+}
+
+func (r Codec) HTTPMapping() api.HTTPMapping {
+	return &r.statusMapping
+}
+
+// LoadCodec is the loader function MOSN looks up in the plugin.
+func LoadCodec() api.XProtocolCodec {
+	return &Codec{}
+}
+
+// main is never run, the package is built as a go plugin by make_codec.sh.
+func main() {}
@@ -0,0 +1,88 @@
+package codec
+
+import (
+	"strconv"
+
+	"github.com/fdingiit/mpl/pkg/simple"
+	"mosn.io/api"
+	"mosn.io/pkg/header"
+)
+
+// Request is a whole SDBS request, its pages assembled. Body is the xml body
+// as on the wire.
+type Request struct {
+	simple.Header
+	SerialNo int
+	Body     api.IoBuffer
+	header.CommonHeader
+
+	// requestId pairs the request with its response inside MOSN, SDBS has
+	// none on the wire
+	requestId uint64
+	// bodyChanged tells the encoder to compute the checksum again
+	bodyChanged bool
+}
+
+var _ api.XFrame = &Request{}
+var _ api.ServiceAware = &Request{}
+
+// SDBS has no heartbeat.
+func (r *Request) IsHeartbeatFrame() bool {
+	return false
+}
+
+func (r *Request) GetTimeout() int32 {
+	return 0
+}
+
+func (r *Request) GetStreamType() api.StreamType {
+	return api.Request
+}
+
+func (r *Request) GetHeader() api.HeaderMap {
+	return r
+}
+
+func (r *Request) GetData() api.IoBuffer {
+	return r.Body
+}
+
+func (r *Request) SetData(data api.IoBuffer) {
+	r.Body = data
+	r.bodyChanged = true
+}
+
+func (r *Request) GetRequestId() uint64 {
+	return r.requestId
+}
+
+func (r *Request) SetRequestId(id uint64) {
+	r.requestId = id
+}
+
+func (r *Request) GetServiceName() string {
+	return strconv.Itoa(r.ServiceCode)
+}
+
+func (r *Request) GetMethodName() string {
+	return ""
+}
+
+type Response struct {
+	Request
+	ErrCode int
+}
+
+var _ api.XRespFrame = &Response{}
+
+func (r *Response) GetStatusCode() uint32 {
+	return uint32(r.ErrCode)
+}
+
+func (r *Response) GetStreamType() api.StreamType {
+	return api.Response
+}
+
+func (r *Response) GetHeader() api.HeaderMap {
+	return r
+}
@@ -0,0 +1,23 @@
+package codec
+
+import (
+	"context"
+	"errors"
+
+	"github.com/fdingiit/mpl/pkg/simple"
+	"mosn.io/api"
+)
+
+type StatusMapping struct {
+	// Statuses maps the error codes of responses to HTTP statuses, nil
+	// meaning simple.DefaultStatuses.
+	Statuses *simple.StatusMapping
+}
+
+func (m *StatusMapping) MappingHeaderStatusCode(ctx context.Context, headers api.HeaderMap) (int, error) {
+	cmd, ok := headers.(api.XRespFrame)
+	if !ok {
+		return 0, errors.New("no response status in headers")
+	}
+	return m.Statuses.Status(int(cmd.GetStatusCode())), nil
+}
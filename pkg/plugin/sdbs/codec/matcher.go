@@ -0,0 +1,27 @@
+package codec
+
+import (
+	"mosn.io/api"
+)
+
+type Matcher struct{}
+
+// SDBSMatcher recognizes the eight digits of the total length followed by the
+// RQ or RS type.
+func (m *Matcher) SDBSMatcher(data []byte) api.MatchResult {
+	for i := 0; i < len(data) && i < lengthLen; i++ {
+		if data[i] < '0' || data[i] > '9' {
+			return api.MatchFailed
+		}
+	}
+	if len(data) > lengthLen && data[lengthLen] != 'R' {
+		return api.MatchFailed
+	}
+	if len(data) > lengthLen+1 && data[lengthLen+1] != 'Q' && data[lengthLen+1] != 'S' {
+		return api.MatchFailed
+	}
+	if len(data) < typeEnd {
+		return api.MatchAgain
+	}
+	return api.MatchSuccess
+}
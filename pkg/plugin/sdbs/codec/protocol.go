@@ -0,0 +1,244 @@
+package codec
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/fdingiit/mpl/pkg/simple"
+	"mosn.io/api"
+	"mosn.io/pkg/buffer"
+	"mosn.io/pkg/header"
+)
+
+/**
+ * SDBS message
+ * 0           8     10    11                                  43          51    52
+ * +-----------+-----+-----+-----------------------------------+-----------+-----+---------------+
+ * |totalLength| type|page |             checksum              |serviceCode| rsv | xml body ...  |
+ * +-----------+-----+-----+-----------------------------------+-----------+-----+---------------+
+ *
+ * All header fields are ASCII digits but the type, RQ or RS, and the checksum.
+ * Messages larger than a page travel as several pages sharing a serial number.
+ */
+
+// Proto is stateful: it assembles the pages of the messages of its connection,
+// each connection gets its own, see NewXProtocol.
+type Proto struct {
+	// Checksum verifies pages, and computes the checksum of assembled
+	// messages, hijack responses and frames whose body was replaced. Nil
+	// means MD5.
+	Checksum simple.Checksummer
+
+	assembler simple.Assembler
+}
+
+func (proto *Proto) Name() api.ProtocolName {
+	return ProtocolName
+}
+
+func (proto *Proto) checksum() simple.Checksummer {
+	if proto.Checksum == nil {
+		return simple.MD5Checksum
+	}
+	return proto.Checksum
+}
+
+func (proto *Proto) Encode(ctx context.Context, model interface{}) (api.IoBuffer, error) {
+	switch frame := model.(type) {
+	case *Request:
+		return proto.encode(ctx, frame, simple.TypeRequest)
+	case *Response:
+		return proto.encode(ctx, &frame.Request, simple.TypeResponse)
+	default:
+		return nil, fmt.Errorf("[protocol][sdbs] encode failed, unknown command type %T", model)
+	}
+}
+
+// encode writes the header of r, its length adjusted to the body.
+func (proto *Proto) encode(ctx context.Context, r *Request, typ string) (api.IoBuffer, error) {
+	var body []byte
+	if r.Body != nil {
+		body = r.Body.Bytes()
+	}
+
+	h := r.Header
+	h.Type, h.TotalLength = typ, simple.HeaderLen+len(body)
+	if r.bodyChanged {
+		h.Checksum = proto.checksum().Sum(body)
+	}
+	head, err := h.Encode(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("[protocol][sdbs] encode failed, %w", err)
+	}
+
+	buf := buffer.GetIoBuffer(h.TotalLength)
+	buf.Write(head)
+	buf.Write(body)
+	return buf, nil
+}
+
+// Decode returns the next whole message of data, nil if more data is needed.
+// Pages are drained and kept until the message is complete.
+func (proto *Proto) Decode(ctx context.Context, data api.IoBuffer) (interface{}, error) {
+	if data == nil {
+		return nil, errors.New("[protocol][sdbs] decode failed, nil buffer")
+	}
+
+	for {
+		b := data.Bytes()
+		if (&Matcher{}).SDBSMatcher(b) == api.MatchFailed {
+			n := len(b)
+			if n > typeEnd {
+				n = typeEnd
+			}
+			return nil, fmt.Errorf("[protocol][sdbs] decode failed, not an sdbs message: %q", b[:n])
+		}
+		if len(b) < simple.HeaderLen {
+			return nil, nil
+		}
+
+		var h simple.Header
+		if err := h.Decode(ctx, b[:simple.HeaderLen]); err != nil {
+			return nil, fmt.Errorf("[protocol][sdbs] decode failed, %w", err)
+		}
+		if h.TotalLength > MaxMessageSize {
+			return nil, fmt.Errorf("[protocol][sdbs] decode failed, message length %d exceeds %d", h.TotalLength, MaxMessageSize)
+		}
+		if len(b) < h.TotalLength {
+			return nil, nil
+		}
+
+		// copy the message out of the connection buffer, reused once drained
+		msg := append([]byte(nil), b[:h.TotalLength]...)
+		data.Drain(h.TotalLength)
+
+		if h.PageMark != simple.PageSingle {
+			whole, err := proto.assembler.Add(simple.WithChecksum(ctx, proto.checksum()), msg)
+			if err != nil {
+				return nil, fmt.Errorf("[protocol][sdbs] decode failed, %w", err)
+			}
+			if whole == nil {
+				continue
+			}
+			msg = whole
+			if err := h.Decode(ctx, msg); err != nil {
+				return nil, fmt.Errorf("[protocol][sdbs] decode failed, %w", err)
+			}
+		}
+
+		return newFrame(h, msg[simple.HeaderLen:]), nil
+	}
+}
+
+// newFrame builds the frame of a whole message.
+func newFrame(h simple.Header, body []byte) interface{} {
+	req := Request{
+		Header:       h,
+		SerialNo:     scanInt(body, "serial_no"),
+		Body:         buffer.NewIoBufferBytes(body),
+		CommonHeader: header.CommonHeader{},
+	}
+	req.Set(HeaderServiceCode, strconv.Itoa(h.ServiceCode))
+	req.Set(HeaderSerialNo, strconv.Itoa(req.SerialNo))
+
+	if h.Type == simple.TypeRequest {
+		return &req
+	}
+	rsp := &Response{Request: req, ErrCode: scanInt(body, "err_code")}
+	rsp.Set(HeaderErrCode, strconv.Itoa(rsp.ErrCode))
+	return rsp
+}
+
+// NewRequest returns the request frame of body, the xml body of a message
+// headed by h, as stream filters building SDBS requests hand them over. Its
+// total length and checksum are computed when it is encoded.
+func NewRequest(h simple.Header, body []byte) *Request {
+	h.Type, h.PageMark = simple.TypeRequest, simple.PageSingle
+	req := newFrame(h, body).(*Request)
+	req.bodyChanged = true
+	return req
+}
+
+// scanInt reads the integer in the first element named name of an xml body
+// without decoding it: '<' never occurs inside a multi-byte character of the
+// SDBS charsets, so the search is safe whatever the charset.
+func scanInt(body []byte, name string) int {
+	open := []byte("<" + name + ">")
+	i := bytes.Index(body, open)
+	if i < 0 {
+		return 0
+	}
+	value := body[i+len(open):]
+	if j := bytes.IndexByte(value, '<'); j >= 0 {
+		value = value[:j]
+	}
+	n, _ := strconv.Atoi(string(bytes.TrimSpace(value)))
+	return n
+}
+
+// Heartbeater, SDBS has no heartbeat
+func (proto *Proto) Trigger(context context.Context, requestId uint64) api.XFrame {
+	return nil
+}
+
+func (proto *Proto) Reply(context context.Context, request api.XFrame) api.XRespFrame {
+	return nil
+}
+
+// Hijack answers request with an SDBS error response, its error code mapped
+// from statusCode.
+func (proto *Proto) Hijack(ctx context.Context, request api.XFrame, statusCode uint32) api.XRespFrame {
+	rsp := &simple.Response{
+		Header:        simple.Header{Type: simple.TypeResponse},
+		UnixTimestamp: time.Now().Unix(),
+		ErrCode:       int(proto.Mapping(statusCode)),
+		Message:       fmt.Sprintf("%d %s", statusCode, http.StatusText(int(statusCode))),
+	}
+	if req, ok := request.(*Request); ok {
+		rsp.ServiceCode, rsp.SerialNo = req.ServiceCode, req.SerialNo
+	}
+
+	data, err := simple.Marshal(simple.WithChecksum(ctx, proto.checksum()), rsp)
+	if err != nil {
+		return nil
+	}
+	frame := newFrame(rsp.Header, data[simple.HeaderLen:]).(*Response)
+	frame.SetRequestId(request.GetRequestId())
+	return frame
+}
+
+// Mapping maps the status codes of the proxy to SDBS error codes.
+func (proto *Proto) Mapping(httpStatusCode uint32) uint32 {
+	switch httpStatusCode {
+	case http.StatusOK:
+		return simple.ErrCodeNo
+	case api.RouterUnavailableCode:
+		return simple.ErrCodeUnknownService
+	case api.CodecExceptionCode, api.DeserialExceptionCode, http.StatusBadRequest:
+		return simple.ErrCodeIncorrectRequest
+	case api.TimeoutExceptionCode:
+		return simple.ErrCodeTimeout
+	default:
+		return simple.ErrCodeInternal
+	}
+}
+
+// PoolMode is ping-pong: SDBS answers the requests of a connection in order
+// and has no request id to multiplex on.
+func (proto *Proto) PoolMode() api.PoolMode {
+	return api.PingPong
+}
+
+func (proto *Proto) EnableWorkerPool() bool {
+	return true
+}
+
+func (proto *Proto) GenerateRequestID(streamID *uint64) uint64 {
+	return atomic.AddUint64(streamID, 1)
+}
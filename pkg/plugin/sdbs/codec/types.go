@@ -0,0 +1,26 @@
+package codec
+
+import (
+	"github.com/fdingiit/mpl/pkg/simple"
+	"mosn.io/api"
+)
+
+// protocol constants
+const (
+	ProtocolName api.ProtocolName = "sdbs" // protocol
+
+	// MaxMessageSize bounds a frame, larger ones are rejected instead of
+	// buffered.
+	MaxMessageSize = simple.DefaultMaxMessageSize
+
+	lengthLen = 8 // digits of the total length, followed by the type
+	typeEnd   = lengthLen + 2
+)
+
+// Keys of the frame headers MOSN routes on, taken from the SDBS header and
+// body of the frame.
+const (
+	HeaderServiceCode = "sdbs-service-code"
+	HeaderSerialNo    = "sdbs-serial-no"
+	HeaderErrCode     = "sdbs-err-code"
+)
@@ -0,0 +1,13 @@
+#!/bin/bash
+
+# Builds the SDBS codec as a go plugin, codec.so, next to this script. MOSN
+# must be built with the same go version and dependencies.
+
+cd "$(dirname "$0")" || exit 1
+
+function make_so {
+  go build -buildmode=plugin -o codec.so . || exit 1
+  echo "built $(pwd)/codec.so"
+}
+
+make_so
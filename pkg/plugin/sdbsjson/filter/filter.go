@@ -0,0 +1,331 @@
+// Package filter is a MOSN stream filter transcoding HTTP/JSON to SDBS: on an
+// HTTP listener it turns the JSON body of a request into an SDBS message for
+// the route it matched, and the SDBS response back into JSON, its HTTP status
+// derived from the error code. SDBS thereby gets MOSN routing, retries and
+// observability in front of it.
+//
+// The filter and the sdbs codec, see pkg/plugin/sdbs, only exchange xml
+// bodies: requests are handed over as a codec.Request frame whose header the
+// codec writes, its length and checksum computed, and responses come back as
+// the codec.Response frame of the assembled message, its body without the
+// header.
+package filter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/fdingiit/mpl/pkg/plugin/sdbs/codec"
+	"github.com/fdingiit/mpl/pkg/simple"
+	"github.com/fdingiit/mpl/pkg/simple/charset"
+	"mosn.io/api"
+	"mosn.io/pkg/buffer"
+)
+
+// FilterName is the stream filter type the factory is registered under.
+const FilterName = "sdbs_transcoder"
+
+// HeaderStatus is the header MOSN takes the status of an HTTP response from.
+const HeaderStatus = "x-mosn-status"
+
+func init() {
+	api.RegisterStream(FilterName, CreateFilterFactory)
+}
+
+// Config is the filter config, as in
+//
+//	{
+//	  "checksum": "md5",
+//	  "charset": "gbk",
+//	  "routes": {
+//	    "/transfer": {"service_code": 1000501, "fields": {"ts": "timestamp"}}
+//	  },
+//	  "statuses": {"5": 409, "funds": 402},
+//	  "default_status": 500
+//	}
+//
+// Routes are keyed by the path the MOSN route matches on, requests of other
+// routes pass untouched. The checksum verifies responses and must match that
+// of the codec, which computes the checksum of requests. Statuses are keyed
+// by error code or category and override simple.DefaultStatuses, codes out of
+// the catalogue take default_status.
+type Config struct {
+	Checksum    string `json:"checksum"`
+	ChecksumKey string `json:"checksum_key"`
+	Charset     string `json:"charset"`
+
+	Routes map[string]*RouteConfig `json:"routes"`
+
+	Statuses      map[string]int `json:"statuses"`
+	DefaultStatus int            `json:"default_status"`
+}
+
+// RouteConfig is the mapping of one route.
+type RouteConfig struct {
+	// ServiceCode is the SDBS service the JSON body is a request of.
+	ServiceCode int `json:"service_code"`
+	// Fields renames JSON keys to the xml element names of the message,
+	// and back in responses. Keys not listed are taken as is.
+	Fields map[string]string `json:"fields"`
+}
+
+// Factory creates the filters of the streams, sharing its parsed config.
+type Factory struct {
+	checksum simple.Checksummer
+	charset  charset.Charset
+	routes   map[string]*route
+	statuses simple.StatusMapping
+}
+
+type route struct {
+	serviceCode int
+	toElement   map[string]string
+	toJSON      map[string]string
+}
+
+// CreateFilterFactory parses the filter config, see Config.
+func CreateFilterFactory(conf map[string]interface{}) (api.StreamFilterChainFactory, error) {
+	data, err := json.Marshal(conf)
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("[%s] config: %w", FilterName, err)
+	}
+	return NewFactory(&cfg)
+}
+
+func NewFactory(cfg *Config) (*Factory, error) {
+	f := &Factory{
+		routes:   make(map[string]*route),
+		statuses: simple.StatusMapping{Default: cfg.DefaultStatus},
+	}
+
+	name := cfg.Checksum
+	if name == "" {
+		name = "md5"
+	}
+	var err error
+	if f.checksum, err = simple.ChecksumByName(name, []byte(cfg.ChecksumKey)); err != nil {
+		return nil, fmt.Errorf("[%s] %w", FilterName, err)
+	}
+	if cfg.Charset != "" {
+		if f.charset, err = charset.Lookup(cfg.Charset); err != nil {
+			return nil, fmt.Errorf("[%s] %w", FilterName, err)
+		}
+	}
+
+	for path, rc := range cfg.Routes {
+		if rc == nil {
+			return nil, fmt.Errorf("[%s] route %s: no mapping", FilterName, path)
+		}
+		if _, err := simple.NewMessage(rc.ServiceCode, simple.TypeRequest); err != nil {
+			return nil, fmt.Errorf("[%s] route %s: %w", FilterName, path, err)
+		}
+		r := &route{serviceCode: rc.ServiceCode, toElement: rc.Fields, toJSON: make(map[string]string)}
+		for key, element := range rc.Fields {
+			if _, dup := r.toJSON[element]; dup {
+				return nil, fmt.Errorf("[%s] route %s: two fields mapped to %s", FilterName, path, element)
+			}
+			r.toJSON[element] = key
+		}
+		f.routes[path] = r
+	}
+
+	for key, status := range cfg.Statuses {
+		if err := f.statuses.Set(key, status); err != nil {
+			return nil, fmt.Errorf("[%s] statuses: %w", FilterName, err)
+		}
+	}
+	return f, nil
+}
+
+func (f *Factory) CreateFilterChain(ctx context.Context, callbacks api.StreamFilterChainFactoryCallbacks) {
+	filter := &Filter{factory: f}
+	callbacks.AddStreamReceiverFilter(filter, api.AfterRoute)
+	callbacks.AddStreamSenderFilter(filter, api.BeforeSend)
+}
+
+// context returns ctx carrying the checksum and charset of the config.
+func (f *Factory) context(ctx context.Context) context.Context {
+	ctx = simple.WithChecksum(ctx, f.checksum)
+	if f.charset != nil {
+		ctx = simple.WithCharset(ctx, f.charset)
+	}
+	return ctx
+}
+
+// Filter transcodes one stream, its request on the way in and its response
+// on the way out.
+type Filter struct {
+	factory  *Factory
+	receiver api.StreamReceiverFilterHandler
+	sender   api.StreamSenderFilterHandler
+
+	// route is the mapping of the request, nil when it passed untouched
+	route *route
+}
+
+func (f *Filter) OnDestroy() {}
+
+func (f *Filter) SetReceiveFilterHandler(handler api.StreamReceiverFilterHandler) {
+	f.receiver = handler
+}
+
+func (f *Filter) SetSenderFilterHandler(handler api.StreamSenderFilterHandler) {
+	f.sender = handler
+}
+
+func errorJSON(code int, err error) string {
+	data, _ := json.Marshal(simple.NewErrorBody(code, err.Error()))
+	return string(data)
+}
+
+func (f *Filter) OnReceive(ctx context.Context, headers api.HeaderMap, buf api.IoBuffer, trailers api.HeaderMap) api.StreamFilterStatus {
+	r := f.routeOf()
+	if r == nil {
+		return api.StreamFilterContinue
+	}
+
+	var body []byte
+	if buf != nil {
+		body = buf.Bytes()
+	}
+	frame, err := f.encodeRequest(ctx, r, body)
+	if err != nil {
+		headers.Set("Content-Type", "application/json")
+		f.receiver.SendHijackReplyWithBody(400, headers, errorJSON(simple.ErrCodeIncorrectRequest, err))
+		return api.StreamFilterStop
+	}
+
+	f.route = r
+	f.receiver.SetRequestHeaders(frame)
+	f.receiver.SetRequestData(frame.GetData())
+	return api.StreamFilterContinue
+}
+
+func (f *Filter) Append(ctx context.Context, headers api.HeaderMap, buf api.IoBuffer, trailers api.HeaderMap) api.StreamFilterStatus {
+	if f.route == nil {
+		return api.StreamFilterContinue
+	}
+
+	var data []byte
+	if buf != nil {
+		data = buf.Bytes()
+	}
+	var body []byte
+	var errCode int
+	frame, ok := headers.(*codec.Response)
+	err := errors.New("not an sdbs response")
+	if ok {
+		body, errCode, err = f.decodeResponse(ctx, f.route, frame.Header, data)
+	}
+	status := f.factory.statuses.Status(errCode)
+	if err != nil {
+		body, status = []byte(errorJSON(simple.ErrCodeInternal, err)), 502
+	}
+
+	headers.Set(HeaderStatus, strconv.Itoa(status))
+	headers.Set("Content-Type", "application/json")
+	headers.Set("Content-Length", strconv.Itoa(len(body)))
+	f.sender.SetResponseData(buffer.NewIoBufferBytes(body))
+	return api.StreamFilterContinue
+}
+
+// routeOf returns the mapping of the route the stream matched, if any.
+func (f *Filter) routeOf() *route {
+	if f.receiver == nil {
+		return nil
+	}
+	rt := f.receiver.Route()
+	if rt == nil || rt.RouteRule() == nil || rt.RouteRule().PathMatchCriterion() == nil {
+		return nil
+	}
+	return f.factory.routes[rt.RouteRule().PathMatchCriterion().Matcher()]
+}
+
+// rename re-keys a JSON object following names, keys not listed are kept.
+func rename(data []byte, names map[string]string) ([]byte, error) {
+	if len(names) == 0 {
+		return data, nil
+	}
+
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(data, &object); err != nil {
+		return nil, err
+	}
+	renamed := make(map[string]json.RawMessage, len(object))
+	for key, value := range object {
+		if name, ok := names[key]; ok {
+			key = name
+		}
+		if _, dup := renamed[key]; dup {
+			return nil, fmt.Errorf("field %s given twice", key)
+		}
+		renamed[key] = value
+	}
+	return json.Marshal(renamed)
+}
+
+// encodeRequest returns the SDBS request frame of the JSON body of a request.
+func (f *Filter) encodeRequest(ctx context.Context, r *route, body []byte) (*codec.Request, error) {
+	body, err := rename(body, r.toElement)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := simple.NewMessage(r.serviceCode, simple.TypeRequest)
+	if err != nil {
+		return nil, err
+	}
+	if err := simple.UnmarshalJSON(body, req); err != nil {
+		return nil, err
+	}
+
+	// the codec writes the header, its checksum computed over the body
+	if f.factory.charset != nil {
+		ctx = simple.WithCharset(ctx, f.factory.charset)
+	}
+	data, err := simple.Marshal(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return codec.NewRequest(*simple.HeaderOf(req), data[simple.HeaderLen:]), nil
+}
+
+// decodeResponse decodes body, the xml body of the SDBS response headed by h,
+// and returns its JSON form and error code.
+func (f *Filter) decodeResponse(ctx context.Context, r *route, h simple.Header, body []byte) ([]byte, int, error) {
+	ctx = f.factory.context(ctx)
+
+	h.TotalLength = simple.HeaderLen + len(body)
+	head, err := h.Encode(ctx)
+	if err != nil {
+		return nil, 0, fmt.Errorf("sdbs response: %w", err)
+	}
+
+	rsp, err := simple.NewMessage(r.serviceCode, simple.TypeResponse)
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := simple.Unmarshal(ctx, append(head, body...), rsp); err != nil {
+		return nil, 0, fmt.Errorf("sdbs response: %w", err)
+	}
+
+	errCode := simple.ErrCodeOf(rsp)
+	body, err = simple.MarshalJSON(rsp)
+	if err == nil {
+		body, err = rename(body, r.toJSON)
+	}
+	if err == nil && errCode != simple.ErrCodeNo {
+		body, err = simple.AddErrorFields(body, errCode)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	return body, errCode, nil
+}
@@ -0,0 +1,15 @@
+package main
+
+import (
+	"github.com/fdingiit/mpl/pkg/plugin/sdbsjson/filter"
+	"mosn.io/api"
+)
+
+// CreateFilterFactory is the factory method MOSN looks up in the plugin, see
+// the go_plugin_config of mosn_config.json.
+func CreateFilterFactory(conf map[string]interface{}) (api.StreamFilterChainFactory, error) {
+	return filter.CreateFilterFactory(conf)
+}
+
+// main is never run, the package is built as a go plugin by make_filter.sh.
+func main() {}
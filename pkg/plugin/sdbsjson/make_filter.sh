@@ -0,0 +1,13 @@
+#!/bin/bash
+
+# Builds the transcoder filter as a go plugin, sdbsjson.so, next to this
+# script. MOSN must be built with the same go version and dependencies.
+
+cd "$(dirname "$0")" || exit 1
+
+function make_so {
+  go build -buildmode=plugin -o sdbsjson.so . || exit 1
+  echo "built $(pwd)/sdbsjson.so"
+}
+
+make_so
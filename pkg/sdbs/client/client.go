@@ -0,0 +1,228 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/fdingiit/mpl/pkg/simple"
+)
+
+const (
+	DefaultDialTimeout  = 3 * time.Second
+	DefaultMaxIdleConns = 4
+	DefaultIdleTimeout  = 30 * time.Second
+)
+
+var ErrClientClosed = errors.New("[sdbs client] client closed")
+
+// Options tunes a Client, zero values fall back to the defaults above.
+// IdleTimeout should stay below the idle timeout of the server so that
+// connections are dropped here first.
+type Options struct {
+	DialTimeout    time.Duration
+	MaxIdleConns   int
+	IdleTimeout    time.Duration
+	MaxMessageSize int
+
+	// TLSConfig, if set, makes connections TLS ones, see simple.TLSFiles.
+	// Without a ServerName, the host of the address is used.
+	TLSConfig *tls.Config
+}
+
+func (o *Options) setDefaults() {
+	if o.DialTimeout <= 0 {
+		o.DialTimeout = DefaultDialTimeout
+	}
+	if o.MaxIdleConns <= 0 {
+		o.MaxIdleConns = DefaultMaxIdleConns
+	}
+	if o.IdleTimeout <= 0 {
+		o.IdleTimeout = DefaultIdleTimeout
+	}
+}
+
+// Client is an SDBS client. SDBS answers the requests of a connection in
+// order and without identifiers, so a connection carries one exchange at a
+// time; idle connections are pooled and reused by later calls. Checksum and
+// charset are taken from the context, see simple.WithChecksum and
+// simple.WithCharset. A Client is safe for concurrent use.
+type Client struct {
+	addr string
+	opts Options
+
+	mu     sync.Mutex
+	idle   []*conn
+	closed bool
+}
+
+type conn struct {
+	net.Conn
+	r         *simple.Reader
+	w         *simple.Writer
+	assembler simple.Assembler
+	idleSince time.Time
+}
+
+func New(addr string, opts Options) *Client {
+	opts.setDefaults()
+	if opts.TLSConfig != nil && opts.TLSConfig.ServerName == "" {
+		opts.TLSConfig = opts.TLSConfig.Clone()
+		opts.TLSConfig.ServerName, _, _ = net.SplitHostPort(addr)
+	}
+	return &Client{addr: addr, opts: opts}
+}
+
+// Call sends req as a single message and decodes the answer into rsp.
+func (c *Client) Call(ctx context.Context, req, rsp simple.Message) error {
+	data, err := simple.Marshal(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	data, err = c.RoundTrip(ctx, data)
+	if err != nil {
+		return err
+	}
+
+	return simple.Unmarshal(ctx, data, rsp)
+}
+
+// RoundTrip sends the pages of one encoded request and returns the response,
+// its pages assembled. The deadline of ctx bounds the whole exchange.
+//
+// A pooled connection the server closed in between is detected by a failed
+// write or a clean EOF before any byte of the response. The server only
+// closes between requests, so the request is sent again on a new connection.
+func (c *Client) RoundTrip(ctx context.Context, pages ...[]byte) ([]byte, error) {
+	for {
+		cn, reused, err := c.get(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		data, err := cn.roundTrip(ctx, pages)
+		if err == nil {
+			c.put(cn)
+			return data, nil
+		}
+
+		cn.Close()
+		var werr *writeError
+		if errors.As(err, &werr) {
+			err = werr.err
+		}
+		if !reused || (err != io.EOF && werr == nil) {
+			return nil, err
+		}
+	}
+}
+
+// Close closes the idle connections, calls in flight complete on theirs.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	idle := c.idle
+	c.idle, c.closed = nil, true
+	c.mu.Unlock()
+
+	for _, cn := range idle {
+		cn.Close()
+	}
+	return nil
+}
+
+// get returns the most recently used idle connection, or a new one.
+func (c *Client) get(ctx context.Context) (*conn, bool, error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, false, ErrClientClosed
+	}
+	for len(c.idle) > 0 {
+		cn := c.idle[len(c.idle)-1]
+		c.idle = c.idle[:len(c.idle)-1]
+		if time.Since(cn.idleSince) < c.opts.IdleTimeout {
+			c.mu.Unlock()
+			return cn, true, nil
+		}
+		cn.Close()
+	}
+	c.mu.Unlock()
+
+	d := &net.Dialer{Timeout: c.opts.DialTimeout}
+	var nc net.Conn
+	var err error
+	if c.opts.TLSConfig != nil {
+		// the handshake is bounded by the dial timeout too
+		nc, err = (&tls.Dialer{NetDialer: d, Config: c.opts.TLSConfig}).DialContext(ctx, "tcp", c.addr)
+	} else {
+		nc, err = d.DialContext(ctx, "tcp", c.addr)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	cn := &conn{Conn: nc, r: simple.NewReader(nc), w: simple.NewWriter(nc)}
+	cn.r.MaxSize = c.opts.MaxMessageSize
+	return cn, false, nil
+}
+
+func (c *Client) put(cn *conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed || len(c.idle) >= c.opts.MaxIdleConns {
+		cn.Close()
+		return
+	}
+	cn.idleSince = time.Now()
+	c.idle = append(c.idle, cn)
+}
+
+func (cn *conn) roundTrip(ctx context.Context, pages [][]byte) ([]byte, error) {
+	deadline, _ := ctx.Deadline()
+	cn.SetDeadline(deadline)
+	stop := context.AfterFunc(ctx, func() {
+		cn.SetDeadline(time.Unix(1, 0))
+	})
+
+	data, err := cn.exchange(ctx, pages)
+	if !stop() {
+		// the deadline may have been cut short, the connection is not reusable
+		return nil, ctx.Err()
+	}
+	if err == nil {
+		cn.SetDeadline(time.Time{})
+	}
+	return data, err
+}
+
+func (cn *conn) exchange(ctx context.Context, pages [][]byte) ([]byte, error) {
+	if err := cn.w.WriteMessage(pages...); err != nil {
+		return nil, &writeError{err}
+	}
+
+	for {
+		msg, err := cn.r.ReadMessage()
+		if err != nil {
+			return nil, err
+		}
+
+		data, err := cn.assembler.Add(ctx, msg)
+		if err != nil || data != nil {
+			return data, err
+		}
+	}
+}
+
+// writeError marks a request that did not reach the server.
+type writeError struct {
+	err error
+}
+
+func (e *writeError) Error() string {
+	return e.err.Error()
+}
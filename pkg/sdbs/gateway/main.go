@@ -0,0 +1,151 @@
+// The HTTP/JSON gateway to the SDBS server, see start_gateway.sh and
+// stop_gateway.sh.
+//
+//	gateway -addr :80 -sdbs 127.0.0.1:9999
+//	gateway -sdbs sdbs.internal:9999 -tls-ca ca.pem -tls-cert gateway.pem -tls-key gateway-key.pem
+//
+// SIGINT and SIGTERM shut the gateway down gracefully.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/fdingiit/mpl/pkg/sdbs/client"
+	"github.com/fdingiit/mpl/pkg/sdbs/gateway/pkg"
+	"github.com/fdingiit/mpl/pkg/simple"
+	"github.com/fdingiit/mpl/pkg/simple/charset"
+)
+
+func main() {
+	if err := run(); err != nil {
+		fmt.Fprintln(os.Stderr, "[Gateway]", err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	addr := flag.String("addr", pkg.DefaultAddr, "address to listen on")
+	sdbsAddr := flag.String("sdbs", pkg.DefaultSDBSAddr, "address of the SDBS server")
+	timeout := flag.Duration("timeout", pkg.DefaultTimeout, "timeout of a request to the SDBS server")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 30*time.Second, "time given to in-flight requests on shutdown")
+	pageSize := flag.Int("page-size", simple.DefaultPageSize, "largest request body sent in a single page, in bytes")
+	checksum := flag.String("checksum", "md5", "checksum algorithm: md5, sha256, hmac-md5 or hmac-sha256")
+	checksumKey := flag.String("checksum-key", "", "key of the hmac checksums")
+	bodyCharset := flag.String("charset", "utf-8", "charset of message bodies: utf-8, gbk or gb18030")
+	statuses := flag.String("statuses", "", "http statuses of error codes overriding the defaults, as in funds=409,5=402")
+	currencies := flag.String("currencies", joinInts(pkg.DefaultCurrencies), "currencies accepted in transfers, comma separated")
+	units := flag.String("units", joinInts(pkg.DefaultUnits), "units accepted in transfers, comma separated")
+	maxSkew := flag.Duration("max-skew", pkg.DefaultMaxSkew, "largest distance between request timestamps and the gateway clock, negative to accept any")
+	keysFile := flag.String("keys", "", "JSON file holding the key of the client id, requests are sent unsigned if empty")
+	clientId := flag.String("client-id", "gateway", "client id the gateway signs requests as")
+	tlsCA := flag.String("tls-ca", "", "PEM CA certificates verifying the SDBS server, plain TCP if empty")
+	tlsCert := flag.String("tls-cert", "", "PEM client certificate presented to the SDBS server")
+	tlsKey := flag.String("tls-key", "", "PEM key of the client certificate")
+	tlsServerName := flag.String("tls-server-name", "", "name in the certificate of the SDBS server, the host of its address if empty")
+	flag.Parse()
+
+	var opts client.Options
+	if *tlsCA != "" || *tlsCert != "" || *tlsKey != "" {
+		serverName := *tlsServerName
+		if serverName == "" {
+			serverName, _, _ = net.SplitHostPort(*sdbsAddr)
+		}
+		files := simple.TLSFiles{CertFile: *tlsCert, KeyFile: *tlsKey, CAFile: *tlsCA}
+		var err error
+		if opts.TLSConfig, err = files.ClientConfig(serverName); err != nil {
+			return err
+		}
+	}
+
+	gw := &pkg.Gateway{
+		Client:   client.New(*sdbsAddr, opts),
+		PageSize: *pageSize,
+		Timeout:  *timeout,
+		MaxSkew:  *maxSkew,
+	}
+	defer gw.Client.Close()
+
+	var err error
+	if gw.Checksum, err = simple.ChecksumByName(*checksum, []byte(*checksumKey)); err != nil {
+		return err
+	}
+	if gw.Charset, err = charset.Lookup(*bodyCharset); err != nil {
+		return err
+	}
+	if gw.Statuses, err = simple.ParseStatusMapping(*statuses); err != nil {
+		return err
+	}
+	accepted, err := splitInts(*currencies)
+	if err != nil {
+		return fmt.Errorf("currencies: %w", err)
+	}
+	acceptedUnits, err := splitInts(*units)
+	if err != nil {
+		return fmt.Errorf("units: %w", err)
+	}
+	gw.Schemas = pkg.NewSchemas(accepted, acceptedUnits)
+	if *keysFile != "" {
+		keys, err := simple.LoadKeys(*keysFile)
+		if err != nil {
+			return err
+		}
+		key, ok := keys[*clientId]
+		if !ok {
+			return fmt.Errorf("no key of client %q in %s", *clientId, *keysFile)
+		}
+		gw.Signer = &simple.Signer{ClientId: *clientId, Key: key}
+	}
+
+	srv := &http.Server{Addr: *addr, Handler: gw}
+
+	done := make(chan error, 1)
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+		fmt.Println("[Gateway] Shutting down on", <-sig)
+
+		ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+		defer cancel()
+		done <- srv.Shutdown(ctx)
+	}()
+
+	fmt.Printf("[Gateway] Listening on %s, SDBS server at %s\n", *addr, *sdbsAddr)
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return <-done
+}
+
+func joinInts(values []int64) string {
+	items := make([]string, len(values))
+	for i, v := range values {
+		items[i] = strconv.FormatInt(v, 10)
+	}
+	return strings.Join(items, ",")
+}
+
+func splitInts(s string) ([]int64, error) {
+	var values []int64
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		v, err := strconv.ParseInt(item, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
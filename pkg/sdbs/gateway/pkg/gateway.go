@@ -0,0 +1,264 @@
+// Package pkg is the HTTP/JSON gateway in front of the SDBS server. Clients
+// POST the body of an SDBS request as JSON, keyed by the xml element names of
+// the message, to the path of its service; the gateway sends it as an SDBS
+// message and answers the SDBS response as JSON the same way.
+//
+// The HTTP status of a response follows its error code, see
+// simple.StatusMapping. Failed responses carry the error, category and
+// retryable fields of simple.ErrorBody along with their own, failures with no
+// SDBS response answer a simple.ErrorBody.
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"time"
+
+	"github.com/fdingiit/mpl/pkg/sdbs/client"
+	"github.com/fdingiit/mpl/pkg/simple"
+	"github.com/fdingiit/mpl/pkg/simple/charset"
+)
+
+const (
+	DefaultAddr        = ":80"
+	DefaultSDBSAddr    = "127.0.0.1:9999"
+	DefaultTimeout     = 10 * time.Second
+	DefaultMaxBodySize = 64 * 1024
+)
+
+// Request headers.
+const (
+	// HeaderPagingMask set to 1 lets the gateway split a request larger than
+	// its page size into pages, 0 or no header sends it as a single page.
+	HeaderPagingMask = "X-SDBS-PAGING-MASK"
+	// HeaderChecksum is accepted for the clients of the lab contract but its
+	// value is not used: the checksum covers the SDBS bytes, which only the
+	// gateway sees, so the gateway always computes it over what it sends.
+	HeaderChecksum = "X-SDBS-CHECKSUM"
+)
+
+// Routes maps the paths served by the gateway to SDBS service codes.
+var Routes = map[string]int{
+	"/transfer":  simple.ServiceTransfer,
+	"/query":     simple.ServiceQuery,
+	"/reversal":  simple.ServiceReversal,
+	"/balance":   simple.ServiceBalance,
+	"/statement": simple.ServiceStatement,
+}
+
+// Gateway is an http.Handler, zero fields take the defaults above.
+type Gateway struct {
+	// Client sends the requests to the SDBS server.
+	Client *client.Client
+
+	// Checksum and Charset must match the server, nil meaning MD5 and
+	// UTF-8.
+	Checksum simple.Checksummer
+	Charset  charset.Charset
+
+	// PageSize is the largest request body sent in a single page when
+	// paging is allowed.
+	PageSize int
+
+	// Timeout bounds the exchange with the SDBS server.
+	Timeout time.Duration
+
+	// MaxBodySize bounds the JSON body of a request.
+	MaxBodySize int64
+
+	// Statuses maps the error codes of responses to HTTP statuses, nil
+	// meaning simple.DefaultStatuses.
+	Statuses *simple.StatusMapping
+
+	// Schemas validate requests by service code before they are sent, nil
+	// meaning DefaultSchemas. Services without a schema are not validated.
+	Schemas map[int]Schema
+
+	// MaxSkew bounds the distance between request timestamps and the
+	// gateway clock, negative accepting any timestamp.
+	MaxSkew time.Duration
+
+	// Signer signs the requests sent to the server, nil sending them
+	// unsigned.
+	Signer *simple.Signer
+}
+
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	serviceCode, ok := Routes[r.URL.Path]
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no service at %s", r.URL.Path))
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+
+	status, rsp, err := g.serve(r, serviceCode)
+	if err != nil {
+		fmt.Printf("[Gateway] %s: %v\n", r.URL.Path, err)
+		writeError(w, status, err)
+		return
+	}
+
+	data, err := simple.MarshalJSON(rsp)
+	errCode := simple.ErrCodeOf(rsp)
+	if err == nil && errCode != simple.ErrCodeNo {
+		data, err = simple.AddErrorFields(data, errCode)
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(g.Statuses.Status(errCode))
+	w.Write(data)
+}
+
+// serve transcodes one request, returning the http status to answer along
+// with an error.
+func (g *Gateway) serve(r *http.Request, serviceCode int) (int, simple.Message, error) {
+	maxBodySize := g.MaxBodySize
+	if maxBodySize <= 0 {
+		maxBodySize = DefaultMaxBodySize
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(nil, r.Body, maxBodySize))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return http.StatusRequestEntityTooLarge, nil, fmt.Errorf("body larger than %d bytes", maxBodySize)
+		}
+		return http.StatusBadRequest, nil, err
+	}
+
+	if err := g.validate(serviceCode, body); err != nil {
+		return http.StatusBadRequest, nil, err
+	}
+
+	req, err := simple.NewMessage(serviceCode, simple.TypeRequest)
+	if err != nil {
+		return http.StatusInternalServerError, nil, err
+	}
+	if err := simple.UnmarshalJSON(body, req); err != nil {
+		return http.StatusBadRequest, nil, err
+	}
+
+	checksum := g.Checksum
+	if checksum == nil {
+		checksum = simple.MD5Checksum
+	}
+	ctx := r.Context()
+	if g.Charset != nil {
+		ctx = simple.WithCharset(ctx, g.Charset)
+	}
+	if g.Signer != nil {
+		ctx = simple.WithSigner(ctx, g.Signer)
+	}
+	timeout := g.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	pages, err := g.encode(simple.WithChecksum(ctx, checksum), req, r.Header)
+	if err != nil {
+		return http.StatusBadRequest, nil, err
+	}
+
+	data, err := g.Client.RoundTrip(ctx, pages...)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return http.StatusGatewayTimeout, nil, fmt.Errorf("sdbs server: %w", err)
+		}
+		return http.StatusBadGateway, nil, fmt.Errorf("sdbs server: %w", err)
+	}
+
+	rsp, err := simple.NewMessage(serviceCode, simple.TypeResponse)
+	if err != nil {
+		return http.StatusInternalServerError, nil, err
+	}
+	if err := simple.Unmarshal(simple.WithChecksum(ctx, checksum), data, rsp); err != nil {
+		return http.StatusBadGateway, nil, fmt.Errorf("sdbs response: %w", err)
+	}
+	return http.StatusOK, rsp, nil
+}
+
+// validate checks the JSON body of a request against the schema of its
+// service.
+func (g *Gateway) validate(serviceCode int, body []byte) error {
+	schemas := g.Schemas
+	if schemas == nil {
+		schemas = DefaultSchemas
+	}
+	schema, ok := schemas[serviceCode]
+	if !ok {
+		return nil
+	}
+
+	v := &validator{now: time.Now(), maxSkew: g.MaxSkew, charset: g.Charset}
+	if v.maxSkew == 0 {
+		v.maxSkew = DefaultMaxSkew
+	}
+	return v.validate(schema, body)
+}
+
+// encode encodes req as told by the request headers.
+func (g *Gateway) encode(ctx context.Context, req simple.Message, header http.Header) ([][]byte, error) {
+	paging := false
+	switch mask := header.Get(HeaderPagingMask); mask {
+	case "", "0":
+	case "1":
+		paging = true
+	default:
+		return nil, fmt.Errorf("%s %q, expected 0 or 1", HeaderPagingMask, mask)
+	}
+
+	if paging {
+		return simple.EncodePages(ctx, req, serialNoOf(req), g.PageSize)
+	}
+	data, err := simple.Marshal(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return [][]byte{data}, nil
+}
+
+// serialNoOf returns the serial number of a request, which pages carry.
+func serialNoOf(msg simple.Message) int {
+	f := reflect.ValueOf(msg).Elem().FieldByName("SerialNo")
+	if f.Kind() != reflect.Int {
+		return 0
+	}
+	return int(f.Int())
+}
+
+// writeError answers a failure of the gateway itself, its error code
+// following status.
+func writeError(w http.ResponseWriter, status int, err error) {
+	code := simple.ErrCodeIncorrectRequest
+	switch status {
+	case http.StatusNotFound:
+		code = simple.ErrCodeUnknownService
+	case http.StatusGatewayTimeout:
+		code = simple.ErrCodeTimeout
+	case http.StatusInternalServerError, http.StatusBadGateway:
+		code = simple.ErrCodeInternal
+	}
+
+	body := simple.NewErrorBody(code, err.Error())
+	var invalid *ValidationError
+	if errors.As(err, &invalid) {
+		body.Errors = invalid.Fields
+	}
+	data, _ := json.Marshal(body)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data)
+}
@@ -0,0 +1,245 @@
+package pkg
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fdingiit/mpl/pkg/simple"
+	"github.com/fdingiit/mpl/pkg/simple/charset"
+)
+
+const (
+	// DefaultMaxSkew bounds the distance between the timestamp of a request
+	// and the clock of the gateway.
+	DefaultMaxSkew = 5 * time.Minute
+	// DefaultMaxNotesBytes bounds notes, in bytes once in the charset of the
+	// server.
+	DefaultMaxNotesBytes = 512
+)
+
+// Currencies and units accepted by DefaultSchemas.
+var (
+	DefaultCurrencies = []int64{1, 2, 3}
+	DefaultUnits      = []int64{0, 1, 2}
+)
+
+// Kind is the JSON type of a field.
+type Kind int
+
+const (
+	// KindInt is an integer, written without fraction nor exponent.
+	KindInt Kind = iota
+	// KindString is a string.
+	KindString
+	// KindTimestamp is a unix timestamp in seconds, within the skew of the
+	// gateway clock.
+	KindTimestamp
+)
+
+// Rule constrains one field of a request, keyed by its xml element name.
+type Rule struct {
+	Field    string
+	Kind     Kind
+	Required bool
+
+	// Min and Max bound integers, inclusive. Max zero means no upper bound,
+	// so negative values are refused unless Min says otherwise.
+	Min, Max int64
+	// Enum lists the accepted integers, empty accepting the whole range.
+	Enum []int64
+
+	// MaxBytes bounds strings once encoded in the charset of the server,
+	// zero meaning no bound.
+	MaxBytes int
+}
+
+// Schema declares the fields of the requests of a service, others being
+// refused.
+type Schema []Rule
+
+// NewSchemas declares the requests of the services of Routes, transfers
+// accepting the given currencies and units.
+func NewSchemas(currencies, units []int64) map[int]Schema {
+	id := func(field string) Rule {
+		return Rule{Field: field, Required: true, Min: 1}
+	}
+	notes := Rule{Field: "notes", Kind: KindString, MaxBytes: DefaultMaxNotesBytes}
+
+	return map[int]Schema{
+		simple.ServiceTransfer: {
+			{Field: "timestamp", Kind: KindTimestamp, Required: true},
+			id("serial_no"),
+			{Field: "currency", Required: true, Enum: currencies},
+			{Field: "amount", Required: true, Min: 1},
+			{Field: "unit", Enum: units},
+			id("out_bank_id"),
+			id("out_account_id"),
+			id("in_bank_id"),
+			id("in_account_id"),
+			notes,
+		},
+		simple.ServiceQuery: {
+			{Field: "timestamp", Kind: KindTimestamp},
+			id("serial_no"),
+			id("out_bank_id"),
+			id("orig_serial_no"),
+		},
+		simple.ServiceReversal: {
+			{Field: "timestamp", Kind: KindTimestamp},
+			id("serial_no"),
+			id("out_bank_id"),
+			id("orig_serial_no"),
+			notes,
+		},
+		simple.ServiceBalance: {
+			{Field: "timestamp", Kind: KindTimestamp},
+			id("serial_no"),
+			id("bank_id"),
+			id("account_id"),
+		},
+		simple.ServiceStatement: {
+			{Field: "timestamp", Kind: KindTimestamp},
+			id("serial_no"),
+			id("bank_id"),
+			id("account_id"),
+			{Field: "from"},
+			{Field: "to"},
+			{Field: "after_id"},
+		},
+	}
+}
+
+// DefaultSchemas are the schemas of the gateway unless configured otherwise.
+var DefaultSchemas = NewSchemas(DefaultCurrencies, DefaultUnits)
+
+// ValidationError lists the fields of a request failing its schema.
+type ValidationError struct {
+	Fields []simple.FieldError
+}
+
+func (e *ValidationError) Error() string {
+	reasons := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		reasons[i] = f.Field + " " + f.Reason
+	}
+	return "invalid request: " + strings.Join(reasons, ", ")
+}
+
+// validator checks requests against schemas, timestamps against now. A
+// negative maxSkew accepts any timestamp.
+type validator struct {
+	now     time.Time
+	maxSkew time.Duration
+	charset charset.Charset
+}
+
+// validate checks the JSON object data against s, all the failing fields
+// listed in a *ValidationError.
+func (v *validator) validate(s Schema, data []byte) error {
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(data, &object); err != nil {
+		return err
+	}
+
+	var fields []simple.FieldError
+	fail := func(field, format string, args ...interface{}) {
+		fields = append(fields, simple.FieldError{Field: field, Reason: fmt.Sprintf(format, args...)})
+	}
+
+	declared := make(map[string]bool, len(s))
+	for _, rule := range s {
+		declared[rule.Field] = true
+
+		raw, ok := object[rule.Field]
+		if !ok || string(raw) == "null" {
+			if rule.Required {
+				fail(rule.Field, "is required")
+			}
+			continue
+		}
+		if reason := v.check(rule, raw); reason != "" {
+			fail(rule.Field, "%s", reason)
+		}
+	}
+
+	var unknown []string
+	for key := range object {
+		if !declared[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unknown)
+	for _, key := range unknown {
+		fail(key, "is not a field of the request")
+	}
+
+	if len(fields) > 0 {
+		return &ValidationError{Fields: fields}
+	}
+	return nil
+}
+
+// check returns why raw fails rule, empty if it does not.
+func (v *validator) check(rule Rule, raw json.RawMessage) string {
+	if rule.Kind == KindString {
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return "must be a string"
+		}
+		if rule.MaxBytes <= 0 {
+			return ""
+		}
+		encoded, name := []byte(s), charset.UTF8.Name()
+		if v.charset != nil {
+			var err error
+			if encoded, err = v.charset.Encode(encoded); err != nil {
+				return fmt.Sprintf("is not representable in %s", v.charset.Name())
+			}
+			name = v.charset.Name()
+		}
+		if len(encoded) > rule.MaxBytes {
+			return fmt.Sprintf("is %d bytes in %s, longer than %d", len(encoded), name, rule.MaxBytes)
+		}
+		return ""
+	}
+
+	n, err := strconv.ParseInt(string(raw), 10, 64)
+	if err != nil {
+		if errors.Is(err, strconv.ErrRange) {
+			return "is out of range"
+		}
+		return "must be an integer"
+	}
+
+	if rule.Kind == KindTimestamp {
+		skew := v.now.Truncate(time.Second).Sub(time.Unix(n, 0))
+		if skew < 0 {
+			skew = -skew
+		}
+		if v.maxSkew >= 0 && skew > v.maxSkew {
+			return fmt.Sprintf("is %s away from the gateway clock, more than %s", skew, v.maxSkew)
+		}
+		return ""
+	}
+
+	if len(rule.Enum) > 0 {
+		for _, e := range rule.Enum {
+			if n == e {
+				return ""
+			}
+		}
+		return fmt.Sprintf("must be one of %v", rule.Enum)
+	}
+	if rule.Max == 0 && n < rule.Min {
+		return fmt.Sprintf("must be at least %d", rule.Min)
+	}
+	if rule.Max != 0 && (n < rule.Min || n > rule.Max) {
+		return fmt.Sprintf("must be between %d and %d", rule.Min, rule.Max)
+	}
+	return ""
+}
@@ -1,11 +1,43 @@
 #!/bin/bash
 
+# Builds the gateway and starts it in the background, settings come from the
+# environment:
+#
+#   GATEWAY_ADDR  address to listen on, default :80
+#   SDBS_ADDR     address of the SDBS server, default 127.0.0.1:9999
+#   GATEWAY_PID   pid file, default gateway.pid
+#   GATEWAY_LOG   log file, default gateway.log
+#
+# Further arguments are passed to the gateway, see bin/gateway -h.
+
+cd "$(dirname "$0")" || exit 1
+
+GATEWAY_ADDR=${GATEWAY_ADDR:-:80}
+SDBS_ADDR=${SDBS_ADDR:-127.0.0.1:9999}
+GATEWAY_PID=${GATEWAY_PID:-gateway.pid}
+GATEWAY_LOG=${GATEWAY_LOG:-gateway.log}
+
 function start_gateway {
-  # todo lab1-task-c
-  # start the gateway
-  echo "implement me!"
-  exit -1
-}
+  if [ -f "$GATEWAY_PID" ] && kill -0 "$(cat "$GATEWAY_PID")" 2>/dev/null; then
+    echo "gateway already running, pid $(cat "$GATEWAY_PID")"
+    exit 1
+  fi
 
-start_gateway
+  go build -o bin/gateway . || exit 1
+
+  nohup bin/gateway -addr "$GATEWAY_ADDR" -sdbs "$SDBS_ADDR" "$@" >>"$GATEWAY_LOG" 2>&1 &
+  local pid=$!
+  echo $pid >"$GATEWAY_PID"
+
+  # give it a moment to fail on a bad flag or a busy port
+  sleep 1
+  if ! kill -0 $pid 2>/dev/null; then
+    rm -f "$GATEWAY_PID"
+    echo "gateway failed to start, see $GATEWAY_LOG"
+    tail -n 5 "$GATEWAY_LOG"
+    exit 1
+  fi
+  echo "gateway started, pid $pid, listening on $GATEWAY_ADDR"
+}
 
+start_gateway "$@"
@@ -1,11 +1,36 @@
 #!/bin/bash
 
+# Stops the gateway started by start_gateway.sh, waiting up to
+# GATEWAY_STOP_TIMEOUT seconds (default 30) for in-flight requests before
+# killing it. GATEWAY_PID is the pid file, default gateway.pid.
+
+cd "$(dirname "$0")" || exit 1
+
+GATEWAY_PID=${GATEWAY_PID:-gateway.pid}
+GATEWAY_STOP_TIMEOUT=${GATEWAY_STOP_TIMEOUT:-30}
+
 function stop_gateway {
-  # todo lab1-task-c
-  # stop the gateway
-  echo "implement me!"
-  exit -1
+  if [ ! -f "$GATEWAY_PID" ]; then
+    echo "gateway not running"
+    exit 0
+  fi
+
+  local pid
+  pid=$(cat "$GATEWAY_PID")
+  if kill -0 "$pid" 2>/dev/null; then
+    kill -TERM "$pid"
+    for _ in $(seq "$GATEWAY_STOP_TIMEOUT"); do
+      kill -0 "$pid" 2>/dev/null || break
+      sleep 1
+    done
+    if kill -0 "$pid" 2>/dev/null; then
+      echo "gateway still running after ${GATEWAY_STOP_TIMEOUT}s, killing it"
+      kill -KILL "$pid"
+    fi
+  fi
+
+  rm -f "$GATEWAY_PID"
+  echo "gateway stopped"
 }
 
 stop_gateway
-
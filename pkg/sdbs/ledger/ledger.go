@@ -0,0 +1,446 @@
+// Package ledger is the stand-in bank behind the SDBS server: accounts keyed
+// by bank and account id, holding balances per currency and unit, between
+// which transfers move money atomically. Booked transfers are kept in a
+// journal, where they can be looked up and reversed.
+package ledger
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	ErrUnknownAccount    = errors.New("[ledger] unknown account")
+	ErrAccountExists     = errors.New("[ledger] account already exists")
+	ErrAccountFrozen     = errors.New("[ledger] account frozen")
+	ErrCurrencyMismatch  = errors.New("[ledger] account does not hold the currency")
+	ErrInsufficientFunds = errors.New("[ledger] insufficient funds")
+	ErrInvalidAmount     = errors.New("[ledger] invalid amount")
+	ErrSameAccount       = errors.New("[ledger] transfer to the same account")
+	ErrUnknownTransfer   = errors.New("[ledger] unknown transfer")
+	ErrAlreadyReversed   = errors.New("[ledger] transfer already reversed")
+	ErrSerialNoReused    = errors.New("[ledger] serial number already booked")
+)
+
+// Balance is the amount an account holds in one currency, counted in a unit
+// of that currency. Balances of different units are kept apart.
+type Balance struct {
+	Currency int   `json:"currency"`
+	Unit     int   `json:"unit"`
+	Amount   int64 `json:"amount"`
+}
+
+type Account struct {
+	BankId    int       `json:"bank_id"`
+	AccountId int       `json:"account_id"`
+	Frozen    bool      `json:"frozen,omitempty"`
+	Balances  []Balance `json:"balances"`
+}
+
+// Balance returns the balance of a in currency and unit.
+func (a *Account) Balance(currency, unit int) (int64, bool) {
+	for _, b := range a.Balances {
+		if b.Currency == currency && b.Unit == unit {
+			return b.Amount, true
+		}
+	}
+	return 0, false
+}
+
+func (a *Account) balance(currency, unit int) *Balance {
+	for i := range a.Balances {
+		if b := &a.Balances[i]; b.Currency == currency && b.Unit == unit {
+			return b
+		}
+	}
+	return nil
+}
+
+func (a *Account) clone() Account {
+	c := *a
+	c.Balances = append([]Balance(nil), a.Balances...)
+	return c
+}
+
+// Transfer moves Amount from the out account to the in account. SerialNo is
+// chosen by the out bank, the transfer is journaled under both.
+type Transfer struct {
+	SerialNo     int   `json:"serial_no"`
+	Currency     int   `json:"currency"`
+	Unit         int   `json:"unit"`
+	Amount       int64 `json:"amount"`
+	OutBankId    int   `json:"out_bank_id"`
+	OutAccountId int   `json:"out_account_id"`
+	InBankId     int   `json:"in_bank_id"`
+	InAccountId  int   `json:"in_account_id"`
+}
+
+// Entry is a booked transfer. A reversal is booked as an entry of its own,
+// moving the amount back, with ReversalOf set to the id of the reversed entry
+// and its serial number chosen by the bank asking for the reversal.
+type Entry struct {
+	Id         int       `json:"id"`
+	Time       time.Time `json:"time"`
+	Transfer   Transfer  `json:"transfer"`
+	ReversalOf int       `json:"reversal_of,omitempty"`
+	ReversedBy int       `json:"reversed_by,omitempty"`
+}
+
+type accountKey struct {
+	bankId, accountId int
+}
+
+type serialKey struct {
+	outBankId, serialNo int
+}
+
+// Ledger is safe for concurrent use. A ledger opened on a file writes every
+// change through to it before reporting success.
+type Ledger struct {
+	mu       sync.Mutex
+	accounts map[accountKey]*Account
+	path     string
+
+	// journal holds the entries in booking order, entry ids start at 1 and
+	// are their index in journal plus one. bySerial indexes the transfers,
+	// not the reversals, a serial number is booked once per out bank.
+	journal  []Entry
+	bySerial map[serialKey]int
+}
+
+// New returns an empty in-memory ledger.
+func New() *Ledger {
+	return &Ledger{
+		accounts: make(map[accountKey]*Account),
+		bySerial: make(map[serialKey]int),
+	}
+}
+
+type ledgerFile struct {
+	Accounts []Account `json:"accounts"`
+	Journal  []Entry   `json:"journal,omitempty"`
+}
+
+// Open returns a ledger backed by the JSON file at path, loading its accounts
+// if the file exists.
+func Open(path string) (*Ledger, error) {
+	l := New()
+	l.path = path
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var f ledgerFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("[ledger] %s: %w", path, err)
+	}
+	for _, acc := range f.Accounts {
+		key := accountKey{acc.BankId, acc.AccountId}
+		if _, dup := l.accounts[key]; dup {
+			return nil, fmt.Errorf("%w: %s: bank %d account %d", ErrAccountExists, path, acc.BankId, acc.AccountId)
+		}
+		acc := acc.clone()
+		l.accounts[key] = &acc
+	}
+	for i, e := range f.Journal {
+		if e.Id != i+1 {
+			return nil, fmt.Errorf("[ledger] %s: journal entry %d has id %d", path, i+1, e.Id)
+		}
+		l.journal = append(l.journal, e)
+		if e.ReversalOf == 0 {
+			l.bySerial[serialKey{e.Transfer.OutBankId, e.Transfer.SerialNo}] = e.Id
+		}
+	}
+	return l, nil
+}
+
+// AddAccount opens a new account.
+func (l *Ledger) AddAccount(acc Account) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := accountKey{acc.BankId, acc.AccountId}
+	if _, dup := l.accounts[key]; dup {
+		return fmt.Errorf("%w: bank %d account %d", ErrAccountExists, acc.BankId, acc.AccountId)
+	}
+	for _, b := range acc.Balances {
+		if b.Amount < 0 {
+			return fmt.Errorf("%w: negative opening balance %d", ErrInvalidAmount, b.Amount)
+		}
+	}
+
+	acc = acc.clone()
+	l.accounts[key] = &acc
+	if err := l.save(); err != nil {
+		delete(l.accounts, key)
+		return err
+	}
+	return nil
+}
+
+// Account returns a copy of an account.
+func (l *Ledger) Account(bankId, accountId int) (Account, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	acc, err := l.account(bankId, accountId)
+	if err != nil {
+		return Account{}, err
+	}
+	return acc.clone(), nil
+}
+
+// Accounts returns copies of all accounts ordered by bank and account id.
+func (l *Ledger) Accounts() []Account {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.snapshot()
+}
+
+// SetFrozen freezes or unfreezes an account. Frozen accounts can neither send
+// nor receive transfers.
+func (l *Ledger) SetFrozen(bankId, accountId int, frozen bool) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	acc, err := l.account(bankId, accountId)
+	if err != nil {
+		return err
+	}
+
+	old := acc.Frozen
+	acc.Frozen = frozen
+	if err := l.save(); err != nil {
+		acc.Frozen = old
+		return err
+	}
+	return nil
+}
+
+// Transfer debits the out account and credits the in account, both or none.
+// A serial number the out bank already booked a transfer under is refused
+// with ErrSerialNoReused.
+func (l *Ledger) Transfer(t Transfer) error {
+	if t.Amount <= 0 {
+		return fmt.Errorf("%w: %d", ErrInvalidAmount, t.Amount)
+	}
+	if t.OutBankId == t.InBankId && t.OutAccountId == t.InAccountId {
+		return fmt.Errorf("%w: bank %d account %d", ErrSameAccount, t.OutBankId, t.OutAccountId)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := serialKey{t.OutBankId, t.SerialNo}
+	if id, dup := l.bySerial[key]; dup {
+		return fmt.Errorf("%w: bank %d serial no %d, entry %d", ErrSerialNoReused, t.OutBankId, t.SerialNo, id)
+	}
+	from, to, err := l.balances(t)
+	if err != nil {
+		return err
+	}
+
+	from.Amount -= t.Amount
+	to.Amount += t.Amount
+	l.journal = append(l.journal, Entry{Id: len(l.journal) + 1, Time: time.Now(), Transfer: t})
+	l.bySerial[key] = len(l.journal)
+	if err := l.save(); err != nil {
+		from.Amount += t.Amount
+		to.Amount -= t.Amount
+		l.journal = l.journal[:len(l.journal)-1]
+		delete(l.bySerial, key)
+		return err
+	}
+	return nil
+}
+
+// Lookup returns the transfer booked by the out bank under serialNo.
+// Transfers refused by the ledger are never booked.
+func (l *Ledger) Lookup(outBankId, serialNo int) (Entry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e, err := l.lookup(outBankId, serialNo)
+	if err != nil {
+		return Entry{}, err
+	}
+	return *e, nil
+}
+
+// Reverse moves the amount of a booked transfer back to its out account and
+// returns the reversal entry, booked under reversalSerialNo. A transfer can
+// be reversed once, as long as the accounts are not frozen and the in account
+// still holds the amount.
+func (l *Ledger) Reverse(outBankId, serialNo, reversalSerialNo int) (Entry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	orig, err := l.lookup(outBankId, serialNo)
+	if err != nil {
+		return Entry{}, err
+	}
+	if orig.ReversedBy != 0 {
+		return Entry{}, fmt.Errorf("%w: bank %d serial no %d", ErrAlreadyReversed, outBankId, serialNo)
+	}
+
+	t := orig.Transfer
+	back := Transfer{
+		SerialNo:     reversalSerialNo,
+		Currency:     t.Currency,
+		Unit:         t.Unit,
+		Amount:       t.Amount,
+		OutBankId:    t.InBankId,
+		OutAccountId: t.InAccountId,
+		InBankId:     t.OutBankId,
+		InAccountId:  t.OutAccountId,
+	}
+	from, to, err := l.balances(back)
+	if err != nil {
+		return Entry{}, err
+	}
+
+	from.Amount -= back.Amount
+	to.Amount += back.Amount
+	e := Entry{Id: len(l.journal) + 1, Time: time.Now(), Transfer: back, ReversalOf: orig.Id}
+	orig.ReversedBy = e.Id
+	l.journal = append(l.journal, e)
+	if err := l.save(); err != nil {
+		from.Amount += back.Amount
+		to.Amount -= back.Amount
+		l.journal = l.journal[:len(l.journal)-1]
+		l.journal[e.ReversalOf-1].ReversedBy = 0
+		return Entry{}, err
+	}
+	return e, nil
+}
+
+// Statement returns the entries moving money from or to an account booked
+// within [from, to), oldest first. A zero from or to leaves that end open.
+func (l *Ledger) Statement(bankId, accountId int, from, to time.Time) ([]Entry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, err := l.account(bankId, accountId); err != nil {
+		return nil, err
+	}
+
+	var entries []Entry
+	for _, e := range l.journal {
+		if !from.IsZero() && e.Time.Before(from) || !to.IsZero() && !e.Time.Before(to) {
+			continue
+		}
+		t := e.Transfer
+		if t.OutBankId == bankId && t.OutAccountId == accountId || t.InBankId == bankId && t.InAccountId == accountId {
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
+
+func (l *Ledger) lookup(outBankId, serialNo int) (*Entry, error) {
+	id, ok := l.bySerial[serialKey{outBankId, serialNo}]
+	if !ok {
+		return nil, fmt.Errorf("%w: bank %d serial no %d", ErrUnknownTransfer, outBankId, serialNo)
+	}
+	return &l.journal[id-1], nil
+}
+
+// balances returns the balances t moves money between, once checked that the
+// transfer can be booked.
+func (l *Ledger) balances(t Transfer) (from, to *Balance, err error) {
+	out, err := l.account(t.OutBankId, t.OutAccountId)
+	if err != nil {
+		return nil, nil, err
+	}
+	in, err := l.account(t.InBankId, t.InAccountId)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, acc := range []*Account{out, in} {
+		if acc.Frozen {
+			return nil, nil, fmt.Errorf("%w: bank %d account %d", ErrAccountFrozen, acc.BankId, acc.AccountId)
+		}
+	}
+
+	from, to = out.balance(t.Currency, t.Unit), in.balance(t.Currency, t.Unit)
+	for i, b := range []*Balance{from, to} {
+		if b == nil {
+			acc := []*Account{out, in}[i]
+			return nil, nil, fmt.Errorf("%w: bank %d account %d, currency %d unit %d", ErrCurrencyMismatch, acc.BankId, acc.AccountId, t.Currency, t.Unit)
+		}
+	}
+	if from.Amount < t.Amount {
+		return nil, nil, fmt.Errorf("%w: bank %d account %d holds %d, needs %d", ErrInsufficientFunds, out.BankId, out.AccountId, from.Amount, t.Amount)
+	}
+	if to.Amount > math.MaxInt64-t.Amount {
+		return nil, nil, fmt.Errorf("%w: bank %d account %d balance overflows", ErrInvalidAmount, in.BankId, in.AccountId)
+	}
+	return from, to, nil
+}
+
+func (l *Ledger) account(bankId, accountId int) (*Account, error) {
+	acc, ok := l.accounts[accountKey{bankId, accountId}]
+	if !ok {
+		return nil, fmt.Errorf("%w: bank %d account %d", ErrUnknownAccount, bankId, accountId)
+	}
+	return acc, nil
+}
+
+func (l *Ledger) snapshot() []Account {
+	accounts := make([]Account, 0, len(l.accounts))
+	for _, acc := range l.accounts {
+		accounts = append(accounts, acc.clone())
+	}
+	sort.Slice(accounts, func(i, j int) bool {
+		if accounts[i].BankId != accounts[j].BankId {
+			return accounts[i].BankId < accounts[j].BankId
+		}
+		return accounts[i].AccountId < accounts[j].AccountId
+	})
+	return accounts
+}
+
+// save writes the ledger to its file, through a temporary file renamed over
+// it so that a crash leaves either the old or the new content.
+func (l *Ledger) save() error {
+	if l.path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(ledgerFile{Accounts: l.snapshot(), Journal: l.journal}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := l.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, l.path)
+}
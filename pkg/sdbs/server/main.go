@@ -1,7 +1,239 @@
+// The SDBS server. Settings come from an optional JSON config file, flags
+// given on the command line override it.
+//
+//	server -addr :9999 -idle-timeout 30s
+//	server -config sdbs.json
+//
+// with sdbs.json like
+//
+//	{"addr": ":9999", "read_timeout": "5s", "max_conns": 256, "checksum": "md5", "charset": "gbk", "ledger": "accounts.json"}
+//
+// With auth_keys, a JSON file mapping client ids to their keys (see
+// simple.LoadKeys), requests must be signed by one of the clients. With
+// tls_cert and tls_key the server accepts TLS connections only, with
+// tls_client_ca too clients must present a certificate signed by that CA. The
+// PEM files are read again when they change.
+//
+// SIGINT and SIGTERM shut the server down gracefully.
 package main
 
-import "github.com/fdingiit/mpl/pkg/sdbs/server/pkg"
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/fdingiit/mpl/pkg/sdbs/ledger"
+	"github.com/fdingiit/mpl/pkg/sdbs/server/pkg"
+	"github.com/fdingiit/mpl/pkg/simple"
+	"github.com/fdingiit/mpl/pkg/simple/charset"
+)
+
+// duration reads "1m30s" style durations from JSON.
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	*d = duration(v)
+	return err
+}
+
+type config struct {
+	Addr               string   `json:"addr"`
+	ReadTimeout        duration `json:"read_timeout"`
+	WriteTimeout       duration `json:"write_timeout"`
+	HandlerTimeout     duration `json:"handler_timeout"`
+	IdleTimeout        duration `json:"idle_timeout"`
+	ShutdownTimeout    duration `json:"shutdown_timeout"`
+	MaxConns           int      `json:"max_conns"`
+	MaxRequestsPerConn int      `json:"max_requests_per_conn"`
+	MaxMessageSize     int      `json:"max_message_size"`
+	PageSize           int      `json:"page_size"`
+	Checksum           string   `json:"checksum"`
+	ChecksumKey        string   `json:"checksum_key"`
+	Charset            string   `json:"charset"`
+	Ledger             string   `json:"ledger"`
+	IdempotencyWindow  duration `json:"idempotency_window"`
+	IdempotencyEntries int      `json:"idempotency_entries"`
+	AuthKeys           string   `json:"auth_keys"`
+	AuthMaxSkew        duration `json:"auth_max_skew"`
+	TLSCert            string   `json:"tls_cert"`
+	TLSKey             string   `json:"tls_key"`
+	TLSClientCA        string   `json:"tls_client_ca"`
+}
+
+func defaultConfig() config {
+	return config{
+		Addr:               pkg.DefaultAddr,
+		ReadTimeout:        duration(pkg.DefaultReadTimeout),
+		WriteTimeout:       duration(pkg.DefaultWriteTimeout),
+		HandlerTimeout:     duration(pkg.DefaultHandlerTimeout),
+		IdleTimeout:        duration(pkg.DefaultIdleTimeout),
+		ShutdownTimeout:    duration(30 * time.Second),
+		MaxConns:           pkg.DefaultMaxConns,
+		MaxRequestsPerConn: pkg.DefaultMaxRequestsPerConn,
+		MaxMessageSize:     pkg.DefaultMaxMessageSize,
+		PageSize:           simple.DefaultPageSize,
+		Checksum:           "md5",
+		Charset:            "utf-8",
+		IdempotencyWindow:  duration(pkg.DefaultReplayWindow),
+		IdempotencyEntries: pkg.DefaultReplayMaxEntries,
+		AuthMaxSkew:        duration(simple.DefaultAuthMaxSkew),
+	}
+}
 
 func main() {
-	pkg.Serve()
+	if err := run(); err != nil {
+		fmt.Fprintln(os.Stderr, "[SDBS]", err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	cfg := defaultConfig()
+
+	configFile := flag.String("config", "", "JSON config file, flags override it")
+	addr := flag.String("addr", cfg.Addr, "address to listen on")
+	readTimeout := flag.Duration("read-timeout", time.Duration(cfg.ReadTimeout), "timeout reading a request")
+	writeTimeout := flag.Duration("write-timeout", time.Duration(cfg.WriteTimeout), "timeout writing a response")
+	handlerTimeout := flag.Duration("handler-timeout", time.Duration(cfg.HandlerTimeout), "timeout handling a request")
+	idleTimeout := flag.Duration("idle-timeout", time.Duration(cfg.IdleTimeout), "close connections idle this long")
+	shutdownTimeout := flag.Duration("shutdown-timeout", time.Duration(cfg.ShutdownTimeout), "time given to in-flight requests on shutdown")
+	maxConns := flag.Int("max-conns", cfg.MaxConns, "connections served at once, -1 for no limit")
+	maxRequests := flag.Int("max-requests-per-conn", cfg.MaxRequestsPerConn, "requests served per connection, -1 for no limit")
+	maxMessageSize := flag.Int("max-message-size", cfg.MaxMessageSize, "largest message accepted, in bytes")
+	pageSize := flag.Int("page-size", cfg.PageSize, "largest response body sent in a single page, in bytes")
+	checksum := flag.String("checksum", cfg.Checksum, "checksum algorithm: md5, sha256, hmac-md5 or hmac-sha256")
+	checksumKey := flag.String("checksum-key", cfg.ChecksumKey, "key of the hmac checksums")
+	bodyCharset := flag.String("charset", cfg.Charset, "charset of message bodies: utf-8, gbk or gb18030")
+	ledgerFile := flag.String("ledger", cfg.Ledger, "JSON file holding the accounts, in memory only if empty")
+	idempotencyWindow := flag.Duration("idempotency-window", time.Duration(cfg.IdempotencyWindow), "how long transfer serial numbers are remembered")
+	authKeys := flag.String("auth-keys", cfg.AuthKeys, "JSON file of the keys of the clients allowed to sign requests, no authentication if empty")
+	authMaxSkew := flag.Duration("auth-max-skew", time.Duration(cfg.AuthMaxSkew), "largest distance between the timestamp of a signed request and the server clock")
+	tlsCert := flag.String("tls-cert", cfg.TLSCert, "PEM certificate of the server, plain TCP if empty")
+	tlsKey := flag.String("tls-key", cfg.TLSKey, "PEM key of the server certificate")
+	tlsClientCA := flag.String("tls-client-ca", cfg.TLSClientCA, "PEM CA certificates verifying client certificates, none required if empty")
+	flag.Parse()
+
+	if *configFile != "" {
+		data, err := ioutil.ReadFile(*configFile)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return fmt.Errorf("config %s: %w", *configFile, err)
+		}
+	}
+
+	// flags given explicitly win over the config file
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "addr":
+			cfg.Addr = *addr
+		case "read-timeout":
+			cfg.ReadTimeout = duration(*readTimeout)
+		case "write-timeout":
+			cfg.WriteTimeout = duration(*writeTimeout)
+		case "handler-timeout":
+			cfg.HandlerTimeout = duration(*handlerTimeout)
+		case "idle-timeout":
+			cfg.IdleTimeout = duration(*idleTimeout)
+		case "shutdown-timeout":
+			cfg.ShutdownTimeout = duration(*shutdownTimeout)
+		case "max-conns":
+			cfg.MaxConns = *maxConns
+		case "max-requests-per-conn":
+			cfg.MaxRequestsPerConn = *maxRequests
+		case "max-message-size":
+			cfg.MaxMessageSize = *maxMessageSize
+		case "page-size":
+			cfg.PageSize = *pageSize
+		case "checksum":
+			cfg.Checksum = *checksum
+		case "checksum-key":
+			cfg.ChecksumKey = *checksumKey
+		case "charset":
+			cfg.Charset = *bodyCharset
+		case "ledger":
+			cfg.Ledger = *ledgerFile
+		case "idempotency-window":
+			cfg.IdempotencyWindow = duration(*idempotencyWindow)
+		case "auth-keys":
+			cfg.AuthKeys = *authKeys
+		case "auth-max-skew":
+			cfg.AuthMaxSkew = duration(*authMaxSkew)
+		case "tls-cert":
+			cfg.TLSCert = *tlsCert
+		case "tls-key":
+			cfg.TLSKey = *tlsKey
+		case "tls-client-ca":
+			cfg.TLSClientCA = *tlsClientCA
+		}
+	})
+
+	var err error
+	if pkg.Checksum, err = simple.ChecksumByName(cfg.Checksum, []byte(cfg.ChecksumKey)); err != nil {
+		return err
+	}
+	if pkg.Charset, err = charset.Lookup(cfg.Charset); err != nil {
+		return err
+	}
+	pkg.PageSize = cfg.PageSize
+	pkg.Replays.Window = time.Duration(cfg.IdempotencyWindow)
+	pkg.Replays.MaxEntries = cfg.IdempotencyEntries
+	if cfg.Ledger != "" {
+		if pkg.Ledger, err = ledger.Open(cfg.Ledger); err != nil {
+			return err
+		}
+	}
+
+	srv := &pkg.Server{
+		Addr:               cfg.Addr,
+		ReadTimeout:        time.Duration(cfg.ReadTimeout),
+		WriteTimeout:       time.Duration(cfg.WriteTimeout),
+		HandlerTimeout:     time.Duration(cfg.HandlerTimeout),
+		IdleTimeout:        time.Duration(cfg.IdleTimeout),
+		MaxConns:           cfg.MaxConns,
+		MaxRequestsPerConn: cfg.MaxRequestsPerConn,
+		MaxMessageSize:     cfg.MaxMessageSize,
+	}
+	if cfg.AuthKeys != "" {
+		keys, err := simple.LoadKeys(cfg.AuthKeys)
+		if err != nil {
+			return err
+		}
+		srv.Auth = &simple.Verifier{Keys: keys, MaxSkew: time.Duration(cfg.AuthMaxSkew)}
+	}
+	if cfg.TLSCert != "" || cfg.TLSKey != "" || cfg.TLSClientCA != "" {
+		files := simple.TLSFiles{CertFile: cfg.TLSCert, KeyFile: cfg.TLSKey, CAFile: cfg.TLSClientCA}
+		if srv.TLSConfig, err = files.ServerConfig(); err != nil {
+			return err
+		}
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+		fmt.Println("[SDBS] Shutting down on", <-sig)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.ShutdownTimeout))
+		defer cancel()
+		done <- srv.Shutdown(ctx)
+	}()
+
+	fmt.Println("[SDBS] Listening on", cfg.Addr)
+	if err := srv.ListenAndServe(); err != pkg.ErrServerClosed {
+		return err
+	}
+	return <-done
 }
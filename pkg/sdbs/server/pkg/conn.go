@@ -0,0 +1,164 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
+
+	"github.com/fdingiit/mpl/pkg/simple"
+)
+
+type serverConn struct {
+	net.Conn
+	server *Server
+	r      *simple.Reader
+	w      *simple.Writer
+	// pages of a request may be interleaved with other requests
+	assembler simple.Assembler
+	// busy is set from the first page of a request until its response is
+	// written
+	busy int32
+}
+
+func (s *Server) newConn(nc net.Conn) *serverConn {
+	c := &serverConn{
+		Conn:   nc,
+		server: s,
+		r:      simple.NewReader(nc),
+		w:      simple.NewWriter(nc),
+	}
+	c.r.Timeout, c.r.IdleTimeout, c.r.MaxSize = s.ReadTimeout, s.IdleTimeout, s.MaxMessageSize
+	c.w.Timeout = s.WriteTimeout
+	c.assembler.MaxSize = s.MaxMessageSize
+	return c
+}
+
+func (c *serverConn) idle() bool {
+	return atomic.LoadInt32(&c.busy) == 0
+}
+
+// serve handles the requests of the connection in sequence until the peer
+// closes its side, stays idle too long, MaxRequestsPerConn is reached or the
+// server shuts down.
+func (c *serverConn) serve() {
+	defer c.server.untrackConn(c)
+	defer c.Close()
+
+	for n := 0; c.server.MaxRequestsPerConn < 0 || n < c.server.MaxRequestsPerConn; n++ {
+		if c.server.shuttingDown() {
+			return
+		}
+		if err := c.handleRequest(); err != nil {
+			if err != io.EOF && err != simple.ErrIdle && !c.server.shuttingDown() {
+				fmt.Println("[SDBS] Handle req err:", err.Error())
+			}
+			return
+		}
+	}
+}
+
+func (c *serverConn) handleRequest() error {
+	defer atomic.StoreInt32(&c.busy, 0)
+
+	ctx := simple.WithChecksum(c.server.ctx, Checksum)
+	ctx = simple.WithCharset(ctx, Charset)
+
+	response, serialNo, err := c.serveRequest(ctx)
+	if err != nil {
+		return err
+	}
+
+	pages, err := simple.EncodePages(ctx, response, serialNo, PageSize)
+	if err != nil {
+		return fmt.Errorf("error encode: %w", err)
+	}
+
+	// Send a response back to person contacting us.
+	if err := c.w.WriteMessage(pages...); err != nil {
+		return fmt.Errorf("error writing: %w", err)
+	}
+	for _, page := range pages {
+		fmt.Println("[SDBS] Rsp: ", string(page))
+	}
+	return nil
+}
+
+// serveRequest reads the pages of one request and dispatches it. Malformed
+// requests and handler failures are answered with an error response, only
+// read errors are returned, io.EOF when the peer is done sending. The serial
+// number of the request is returned along to page the response.
+//
+// HandlerTimeout runs from the first page of the request on, the time the
+// connection spent idle before does not count.
+func (c *serverConn) serveRequest(ctx context.Context) (simple.Message, int, error) {
+	info := &requestInfo{}
+
+	var data []byte
+	for data == nil {
+		msg, err := c.r.ReadMessage()
+		if err == io.EOF || err == simple.ErrIdle {
+			return nil, 0, err
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("error reading: %w", err)
+		}
+		if atomic.SwapInt32(&c.busy, 1) == 0 {
+			// first page of the request
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, c.server.HandlerTimeout)
+			defer cancel()
+		}
+
+		if data, err = c.assembler.Add(ctx, msg); err != nil {
+			fmt.Println("[SDBS] Error Decode:", err.Error())
+			// pages carry the serial number of their message too
+			simple.Unmarshal(ctx, msg, info)
+			return errorResponse(info, err), info.SerialNo, nil
+		}
+	}
+
+	// checksum and body errors are reported below
+	simple.Unmarshal(ctx, data, info)
+
+	if c.server.Auth != nil {
+		clientId, err := c.server.Auth.Verify(data)
+		if err != nil {
+			fmt.Println("[SDBS] Error Auth:", err.Error())
+			return errorResponse(info, err), info.SerialNo, nil
+		}
+		ctx = context.WithValue(ctx, clientIdKey{}, clientId)
+	}
+
+	request, err := simple.DecodeMessage(ctx, data)
+	if err != nil {
+		fmt.Println("[SDBS] Error Decode:", err.Error())
+		return errorResponse(info, err), info.SerialNo, nil
+	}
+	if info.Type != simple.TypeRequest {
+		return errorResponse(info, fmt.Errorf("unexpected message type %q", info.Type)), info.SerialNo, nil
+	}
+
+	response, err := c.server.Handler.ServeSDBS(ctx, request)
+	if err == nil && response == nil {
+		err = errors.New("no response")
+	}
+	if err != nil {
+		fmt.Println("[SDBS] Error Dispatch:", err.Error())
+		switch {
+		case errors.Is(err, ErrUnknownService):
+		case ctx.Err() == context.DeadlineExceeded:
+			err = fmt.Errorf("%w: %v", errHandlerTimeout, err)
+		default:
+			err = fmt.Errorf("%w: %v", errHandler, err)
+		}
+		return errorResponse(info, err), info.SerialNo, nil
+	}
+
+	h := simple.HeaderOf(response)
+	// responses are not signed, whatever the request
+	h.Type, h.ServiceCode, h.Reserved = simple.TypeResponse, info.ServiceCode, 0
+	return response, info.SerialNo, nil
+}
@@ -0,0 +1,125 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/fdingiit/mpl/pkg/simple"
+)
+
+var ErrUnknownService = errors.New("[SDBS] unknown service code")
+
+// errHandler marks the errors returned by handlers.
+var errHandler = errors.New("handler failed")
+
+// errHandlerTimeout marks the errors of handlers past the handler timeout.
+var errHandlerTimeout = errors.New("handler timed out")
+
+// Handler serves one decoded request, a value of the type registered for its
+// service code with simple.Register. The server fills in type and service
+// code of the returned response. A non-nil error is answered with an error
+// response.
+type Handler interface {
+	ServeSDBS(ctx context.Context, req simple.Message) (simple.Message, error)
+}
+
+type HandlerFunc func(ctx context.Context, req simple.Message) (simple.Message, error)
+
+func (f HandlerFunc) ServeSDBS(ctx context.Context, req simple.Message) (simple.Message, error) {
+	return f(ctx, req)
+}
+
+// Middleware wraps a handler, to log, authenticate or measure requests.
+type Middleware func(Handler) Handler
+
+// Registry dispatches requests to the handler registered for their service
+// code, through the middleware in the order they were added. It is itself a
+// Handler and is safe for concurrent use.
+type Registry struct {
+	mu         sync.RWMutex
+	handlers   map[int]Handler
+	middleware []Middleware
+}
+
+// DefaultRegistry is the handler of servers without one.
+var DefaultRegistry = &Registry{}
+
+// Register registers h for requests of serviceCode on DefaultRegistry.
+func Register(serviceCode int, h Handler) {
+	DefaultRegistry.Register(serviceCode, h)
+}
+
+// Use adds middleware to DefaultRegistry.
+func Use(mw ...Middleware) {
+	DefaultRegistry.Use(mw...)
+}
+
+// Register registers h for requests of serviceCode, replacing any previous one.
+func (r *Registry) Register(serviceCode int, h Handler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.handlers == nil {
+		r.handlers = make(map[int]Handler)
+	}
+	r.handlers[serviceCode] = h
+}
+
+func (r *Registry) RegisterFunc(serviceCode int, f func(ctx context.Context, req simple.Message) (simple.Message, error)) {
+	r.Register(serviceCode, HandlerFunc(f))
+}
+
+// Use adds middleware wrapping every handler, the first one added is the
+// outermost.
+func (r *Registry) Use(mw ...Middleware) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.middleware = append(r.middleware, mw...)
+}
+
+func (r *Registry) ServeSDBS(ctx context.Context, req simple.Message) (simple.Message, error) {
+	serviceCode := simple.HeaderOf(req).ServiceCode
+
+	r.mu.RLock()
+	h, ok := r.handlers[serviceCode]
+	mw := r.middleware
+	r.mu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownService, serviceCode)
+	}
+	for i := len(mw) - 1; i >= 0; i-- {
+		h = mw[i](h)
+	}
+	return h.ServeSDBS(ctx, req)
+}
+
+// Logging prints every request with its outcome and duration.
+func Logging(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, req simple.Message) (simple.Message, error) {
+		start := time.Now()
+		rsp, err := next.ServeSDBS(ctx, req)
+		if err != nil {
+			fmt.Printf("[SDBS] service %d failed in %v: %v\n", simple.HeaderOf(req).ServiceCode, time.Since(start), err)
+		} else {
+			fmt.Printf("[SDBS] service %d served in %v\n", simple.HeaderOf(req).ServiceCode, time.Since(start))
+		}
+		return rsp, err
+	})
+}
+
+// Recover turns a panicking handler into an error.
+func Recover(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, req simple.Message) (rsp simple.Message, err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				rsp, err = nil, fmt.Errorf("[SDBS] handler panic: %v", p)
+			}
+		}()
+		return next.ServeSDBS(ctx, req)
+	})
+}
@@ -0,0 +1,170 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/fdingiit/mpl/pkg/simple"
+)
+
+const (
+	DefaultReplayWindow     = 24 * time.Hour
+	DefaultReplayMaxEntries = 100000
+)
+
+// Replays makes the transfer handler idempotent.
+var Replays = &ReplayCache{}
+
+// ReplayCache remembers the transfers served within Window, keyed by out bank
+// and serial number, so that retried requests are answered with the original
+// response instead of being booked again. A serial number reused for a
+// different transfer is refused with ErrCodeSerialNoReused. The timestamp is
+// not part of the comparison, retries may be stamped anew.
+//
+// The cache only spares the ledger the retries it remembers. Serial numbers
+// it forgot, once expired, evicted or lost in a restart, are still refused by
+// the ledger, which holds every booked transfer.
+type ReplayCache struct {
+	// Window is how long a serial number is remembered, zero means
+	// DefaultReplayWindow.
+	Window time.Duration
+	// MaxEntries caps the serial numbers remembered, the oldest are evicted
+	// first. Zero means DefaultReplayMaxEntries.
+	MaxEntries int
+
+	mu      sync.Mutex
+	entries map[replayKey]*replayEntry
+	// order lists the entries from oldest to newest, for expiry
+	order []*replayEntry
+}
+
+type replayKey struct {
+	outBankId int
+	serialNo  int
+}
+
+type replayEntry struct {
+	key     replayKey
+	request simple.Request
+	at      time.Time
+
+	// response and err are set once done is closed
+	done     chan struct{}
+	response simple.Message
+	err      error
+}
+
+// Middleware applies the cache to the transfer requests served by next, other
+// requests pass through. Handler errors are not remembered, the request can
+// be retried.
+func (c *ReplayCache) Middleware(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, msg simple.Message) (simple.Message, error) {
+		req, ok := msg.(*simple.Request)
+		if !ok {
+			return next.ServeSDBS(ctx, msg)
+		}
+
+		key := replayKey{outBankId: req.OutBankId, serialNo: req.SerialNo}
+		content := *req
+		content.Header, content.UnixTimestamp = simple.Header{}, 0
+
+		c.mu.Lock()
+		c.expire(time.Now())
+		if e, ok := c.entries[key]; ok {
+			c.mu.Unlock()
+			return c.replay(ctx, e, &content)
+		}
+
+		c.evict()
+		e := &replayEntry{key: key, request: content, at: time.Now(), done: make(chan struct{})}
+		if c.entries == nil {
+			c.entries = make(map[replayKey]*replayEntry)
+		}
+		c.entries[key] = e
+		c.order = append(c.order, e)
+		c.mu.Unlock()
+
+		rsp, err := next.ServeSDBS(ctx, msg)
+
+		c.mu.Lock()
+		if err != nil {
+			if c.entries[key] == e {
+				delete(c.entries, key)
+			}
+			e.err = err
+		} else {
+			e.response = cloneMessage(rsp)
+		}
+		c.mu.Unlock()
+		close(e.done)
+
+		return rsp, err
+	})
+}
+
+// replay answers a request whose serial number was seen before, waiting for
+// the original one if it is still being served.
+func (c *ReplayCache) replay(ctx context.Context, e *replayEntry, content *simple.Request) (simple.Message, error) {
+	if e.request != *content {
+		return &simple.Response{
+			UnixTimestamp: time.Now().Unix(),
+			SerialNo:      content.SerialNo,
+			ErrCode:       ErrCodeSerialNoReused,
+			Message:       fmt.Sprintf("serial no %d of bank %d was used for a different transfer", e.key.serialNo, e.key.outBankId),
+		}, nil
+	}
+
+	select {
+	case <-e.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if e.err != nil {
+		return nil, e.err
+	}
+	return cloneMessage(e.response), nil
+}
+
+// expire forgets the entries older than the window.
+func (c *ReplayCache) expire(now time.Time) {
+	window := c.Window
+	if window <= 0 {
+		window = DefaultReplayWindow
+	}
+
+	n := 0
+	for ; n < len(c.order) && now.Sub(c.order[n].at) > window; n++ {
+		if e := c.order[n]; c.entries[e.key] == e {
+			delete(c.entries, e.key)
+		}
+	}
+	c.order = c.order[n:]
+}
+
+// evict forgets the oldest entries to make room for a new one.
+func (c *ReplayCache) evict() {
+	max := c.MaxEntries
+	if max <= 0 {
+		max = DefaultReplayMaxEntries
+	}
+
+	n := 0
+	for ; n < len(c.order) && len(c.order)-n >= max; n++ {
+		if e := c.order[n]; c.entries[e.key] == e {
+			delete(c.entries, e.key)
+		}
+	}
+	c.order = c.order[n:]
+}
+
+// cloneMessage returns a shallow copy of msg, responses are modified on their
+// way out.
+func cloneMessage(msg simple.Message) simple.Message {
+	v := reflect.ValueOf(msg).Elem()
+	c := reflect.New(v.Type())
+	c.Elem().Set(v)
+	return c.Interface().(simple.Message)
+}
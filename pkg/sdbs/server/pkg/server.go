@@ -2,122 +2,639 @@ package pkg
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"net"
-	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/fdingiit/mpl/pkg/sdbs/ledger"
 	"github.com/fdingiit/mpl/pkg/simple"
+	"github.com/fdingiit/mpl/pkg/simple/charset"
 )
 
+// Error codes of the responses, see the catalogue of simple.LookupErrCode.
 const (
-	ErrCodeNo = iota
-	ErrCodeIncorrectRequest
+	ErrCodeNo                = simple.ErrCodeNo
+	ErrCodeIncorrectRequest  = simple.ErrCodeIncorrectRequest
+	ErrCodeChecksumMismatch  = simple.ErrCodeChecksumMismatch
+	ErrCodeUnknownService    = simple.ErrCodeUnknownService
+	ErrCodeInternal          = simple.ErrCodeInternal
+	ErrCodeInsufficientFunds = simple.ErrCodeInsufficientFunds
+	ErrCodeUnknownAccount    = simple.ErrCodeUnknownAccount
+	ErrCodeCurrencyMismatch  = simple.ErrCodeCurrencyMismatch
+	ErrCodeAccountFrozen     = simple.ErrCodeAccountFrozen
+	ErrCodeSerialNoReused    = simple.ErrCodeSerialNoReused
+	ErrCodeUnknownTransfer   = simple.ErrCodeUnknownTransfer
+	ErrCodeAlreadyReversed   = simple.ErrCodeAlreadyReversed
+	ErrCodeUnauthorized      = simple.ErrCodeUnauthorized
+	ErrCodeTimeout           = simple.ErrCodeTimeout
 )
 
-func handleTrans(ctx context.Context, req *simple.Request) (*simple.Response, error) {
+// Checksum is used to verify the checksum of incoming requests and to fill
+// the one of responses.
+var Checksum simple.Checksummer = simple.MD5Checksum
+
+// Charset is the character set of message bodies on the wire.
+var Charset = charset.UTF8
+
+// Ledger holds the accounts transfers are booked on.
+var Ledger = ledger.New()
+
+func init() {
+	Register(simple.ServiceTransfer, Replays.Middleware(HandlerFunc(handleTrans)))
+	Register(simple.ServiceQuery, HandlerFunc(handleQuery))
+	Register(simple.ServiceReversal, HandlerFunc(handleReversal))
+	Register(simple.ServiceBalance, HandlerFunc(handleBalance))
+	Register(simple.ServiceStatement, HandlerFunc(handleStatement))
+}
+
+func handleTrans(ctx context.Context, msg simple.Message) (simple.Message, error) {
+	req, ok := msg.(*simple.Request)
+	if !ok {
+		return nil, fmt.Errorf("unexpected transfer request %T", msg)
+	}
+
+	t := ledger.Transfer{
+		SerialNo:     req.SerialNo,
+		Currency:     req.Currency,
+		Unit:         req.Unit,
+		Amount:       int64(req.Amount),
+		OutBankId:    req.OutBankId,
+		OutAccountId: req.OutAccountId,
+		InBankId:     req.InBankId,
+		InAccountId:  req.InAccountId,
+	}
+	err := Ledger.Transfer(t)
+	if errors.Is(err, ledger.ErrSerialNoReused) {
+		// a retry the replay cache no longer remembers is answered as the
+		// booked transfer was
+		if e, lookupErr := Ledger.Lookup(t.OutBankId, t.SerialNo); lookupErr == nil && e.Transfer == t {
+			err = nil
+		}
+	}
+	errCode, ok := ledgerErrCode(err)
+	if !ok {
+		return nil, err
+	}
+
+	message := "ok"
+	if err != nil {
+		message = err.Error()
+	}
 	return &simple.Response{
-		Header: simple.Header{
-			Type:        "RS",
-			PageMark:    req.PageMark,
-			ServiceCode: req.ServiceCode,
-		},
 		UnixTimestamp: time.Now().Unix(),
 		SerialNo:      req.SerialNo,
-		ErrCode:       ErrCodeNo,
-		Message:       "ok",
+		ErrCode:       errCode,
+		Message:       message,
 	}, nil
 }
 
-func dispatch(ctx context.Context, req *simple.Request) (*simple.Response, error) {
-	switch req.ServiceCode {
-	case 100501:
-		return handleTrans(ctx, req)
-	default:
-		return nil, errors.New("should not be here")
+// handleQuery reports whether a transfer was booked, and whether it was
+// reversed since. Transfers refused by the ledger are unknown to it.
+func handleQuery(ctx context.Context, msg simple.Message) (simple.Message, error) {
+	req, ok := msg.(*simple.QueryRequest)
+	if !ok {
+		return nil, fmt.Errorf("unexpected query request %T", msg)
+	}
+
+	rsp := &simple.QueryResponse{
+		UnixTimestamp: time.Now().Unix(),
+		SerialNo:      req.SerialNo,
+		OrigSerialNo:  req.OrigSerialNo,
+	}
+
+	e, err := Ledger.Lookup(req.OutBankId, req.OrigSerialNo)
+	errCode, ok := ledgerErrCode(err)
+	if !ok {
+		return nil, err
 	}
+	if err != nil {
+		rsp.ErrCode, rsp.Message = errCode, err.Error()
+		return rsp, nil
+	}
+
+	t := e.Transfer
+	rsp.Message, rsp.Status = "ok", simple.TransferBooked
+	if e.ReversedBy != 0 {
+		rsp.Status = simple.TransferReversed
+	}
+	rsp.BookedAt = e.Time.Unix()
+	rsp.Currency, rsp.Amount, rsp.Unit = t.Currency, int(t.Amount), t.Unit
+	rsp.OutBankId, rsp.OutAccountId = t.OutBankId, t.OutAccountId
+	rsp.InBankId, rsp.InAccountId = t.InBankId, t.InAccountId
+	return rsp, nil
 }
 
-func handleRequest(conn net.Conn) {
-	defer conn.Close()
+// handleReversal moves the amount of a booked transfer back.
+func handleReversal(ctx context.Context, msg simple.Message) (simple.Message, error) {
+	req, ok := msg.(*simple.ReversalRequest)
+	if !ok {
+		return nil, fmt.Errorf("unexpected reversal request %T", msg)
+	}
+
+	_, err := Ledger.Reverse(req.OutBankId, req.OrigSerialNo, req.SerialNo)
+	errCode, ok := ledgerErrCode(err)
+	if !ok {
+		return nil, err
+	}
+
+	message := "ok"
+	if err != nil {
+		message = err.Error()
+	}
+	return &simple.ReversalResponse{
+		UnixTimestamp: time.Now().Unix(),
+		SerialNo:      req.SerialNo,
+		ErrCode:       errCode,
+		Message:       message,
+		OrigSerialNo:  req.OrigSerialNo,
+	}, nil
+}
+
+func handleBalance(ctx context.Context, msg simple.Message) (simple.Message, error) {
+	req, ok := msg.(*simple.BalanceRequest)
+	if !ok {
+		return nil, fmt.Errorf("unexpected balance request %T", msg)
+	}
+
+	rsp := &simple.BalanceResponse{
+		UnixTimestamp: time.Now().Unix(),
+		SerialNo:      req.SerialNo,
+		BankId:        req.BankId,
+		AccountId:     req.AccountId,
+	}
+
+	acc, err := Ledger.Account(req.BankId, req.AccountId)
+	errCode, ok := ledgerErrCode(err)
+	if !ok {
+		return nil, err
+	}
+	if err != nil {
+		rsp.ErrCode, rsp.Message = errCode, err.Error()
+		return rsp, nil
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	rsp.Message, rsp.Frozen = "ok", acc.Frozen
+	for _, b := range acc.Balances {
+		rsp.Balances = append(rsp.Balances, simple.Balance{Currency: b.Currency, Unit: b.Unit, Amount: b.Amount})
+	}
+	return rsp, nil
+}
 
-	var response *simple.Response
-	var ret []byte
-	var err error
+// StatementLimit caps the entries of a statement response, the client asks
+// for the rest with AfterId. Responses larger than PageSize are paged anyway.
+var StatementLimit = 200
 
-	// Make a buffer to hold incoming data.
-	buf := make([]byte, 1024*4)
-	// Read the incoming connection into the buffer.
-	if _, err := conn.Read(buf); err != nil {
-		fmt.Println("[SDBS] Error reading:", err.Error())
-		return
+func handleStatement(ctx context.Context, msg simple.Message) (simple.Message, error) {
+	req, ok := msg.(*simple.StatementRequest)
+	if !ok {
+		return nil, fmt.Errorf("unexpected statement request %T", msg)
 	}
 
-	request := &simple.Request{}
-	if err := request.Decode(ctx, buf); err != nil {
-		msg := fmt.Sprintf("Error Decode: %s", err.Error())
-		fmt.Println("[SDBS] Error Decode:", err.Error())
+	rsp := &simple.StatementResponse{
+		UnixTimestamp: time.Now().Unix(),
+		SerialNo:      req.SerialNo,
+	}
+	if req.From != 0 && req.To != 0 && req.To < req.From {
+		rsp.ErrCode, rsp.Message = ErrCodeIncorrectRequest, fmt.Sprintf("statement ends at %d before it starts at %d", req.To, req.From)
+		return rsp, nil
+	}
+
+	var from, to time.Time
+	if req.From != 0 {
+		from = time.Unix(req.From, 0)
+	}
+	if req.To != 0 {
+		to = time.Unix(req.To, 0)
+	}
+	entries, err := Ledger.Statement(req.BankId, req.AccountId, from, to)
+	errCode, ok := ledgerErrCode(err)
+	if !ok {
+		return nil, err
+	}
+	if err != nil {
+		rsp.ErrCode, rsp.Message = errCode, err.Error()
+		return rsp, nil
+	}
 
-		response = &simple.Response{
-			Header: simple.Header{
-				Type:        "RS",
-				PageMark:    request.PageMark,
-				ServiceCode: request.ServiceCode,
-			},
-			UnixTimestamp: time.Now().Unix(),
-			SerialNo:      request.SerialNo,
-			ErrCode:       ErrCodeIncorrectRequest,
-			Message:       msg,
+	rsp.Message = "ok"
+	for _, e := range entries {
+		if e.Id <= req.AfterId {
+			continue
+		}
+		if len(rsp.Entries) == StatementLimit {
+			rsp.More = true
+			break
 		}
 
-		ret, err = response.Encode(ctx)
-		if err != nil {
-			fmt.Println("[SDBS] Error Encode:", err.Error())
-			return
+		t := e.Transfer
+		entry := simple.StatementEntry{
+			Id:               e.Id,
+			BookedAt:         e.Time.Unix(),
+			SerialNo:         t.SerialNo,
+			Currency:         t.Currency,
+			Unit:             t.Unit,
+			Amount:           t.Amount,
+			CounterBankId:    t.OutBankId,
+			CounterAccountId: t.OutAccountId,
+			ReversalOf:       e.ReversalOf,
+		}
+		if t.OutBankId == req.BankId && t.OutAccountId == req.AccountId {
+			entry.Amount = -t.Amount
+			entry.CounterBankId, entry.CounterAccountId = t.InBankId, t.InAccountId
 		}
+		rsp.Entries = append(rsp.Entries, entry)
+	}
+	return rsp, nil
+}
 
-		goto WRITE
+// ledgerErrCode maps the refusals of the ledger to error codes, other
+// failures are not answered by the transfer itself.
+func ledgerErrCode(err error) (int, bool) {
+	switch {
+	case err == nil:
+		return ErrCodeNo, true
+	case errors.Is(err, ledger.ErrInsufficientFunds):
+		return ErrCodeInsufficientFunds, true
+	case errors.Is(err, ledger.ErrUnknownAccount):
+		return ErrCodeUnknownAccount, true
+	case errors.Is(err, ledger.ErrCurrencyMismatch):
+		return ErrCodeCurrencyMismatch, true
+	case errors.Is(err, ledger.ErrAccountFrozen):
+		return ErrCodeAccountFrozen, true
+	case errors.Is(err, ledger.ErrUnknownTransfer):
+		return ErrCodeUnknownTransfer, true
+	case errors.Is(err, ledger.ErrAlreadyReversed):
+		return ErrCodeAlreadyReversed, true
+	case errors.Is(err, ledger.ErrSerialNoReused):
+		return ErrCodeSerialNoReused, true
+	case errors.Is(err, ledger.ErrInvalidAmount), errors.Is(err, ledger.ErrSameAccount):
+		return ErrCodeIncorrectRequest, true
+	default:
+		return 0, false
 	}
+}
 
-	response, err = dispatch(ctx, request)
-	if err != nil {
-		fmt.Println("[SDBS] Handle req err:", err.Error())
-		return
+// PageSize is the largest response body sent in a single page, larger
+// responses are split into multiple pages.
+var PageSize = simple.DefaultPageSize
+
+// requestInfo is the part of any request needed to answer it, decoded even
+// from requests the server cannot handle.
+type requestInfo struct {
+	simple.Header
+	SerialNo int `xml:"serial_no"`
+}
+
+// errorResponse answers a request that failed with err, the error code
+// depending on where it failed.
+func errorResponse(request *requestInfo, err error) *simple.Response {
+	var errCode int
+	var message string
+	switch {
+	case errors.Is(err, simple.ErrUnauthorized):
+		errCode, message = ErrCodeUnauthorized, err.Error()
+	case errors.Is(err, simple.ErrChecksumMismatch):
+		errCode, message = ErrCodeChecksumMismatch, fmt.Sprintf("Error Decode: %s", err.Error())
+	case errors.Is(err, ErrUnknownService), errors.Is(err, simple.ErrUnknownService):
+		errCode, message = ErrCodeUnknownService, fmt.Sprintf("unknown service code %d", request.ServiceCode)
+	case errors.Is(err, errHandlerTimeout):
+		errCode, message = ErrCodeTimeout, err.Error()
+	case errors.Is(err, errHandler):
+		errCode, message = ErrCodeInternal, err.Error()
+	default:
+		errCode, message = ErrCodeIncorrectRequest, fmt.Sprintf("Error Decode: %s", err.Error())
 	}
 
-	ret, err = response.Encode(ctx)
-	if err != nil {
-		fmt.Println("[SDBS] Error Encode:", err.Error())
-		return
+	return &simple.Response{
+		Header: simple.Header{
+			Type:        "RS",
+			ServiceCode: request.ServiceCode,
+		},
+		UnixTimestamp: time.Now().Unix(),
+		SerialNo:      request.SerialNo,
+		ErrCode:       errCode,
+		Message:       message,
 	}
+}
 
-WRITE:
-	// Send a response back to person contacting us.
-	conn.Write(ret)
-	fmt.Println("[SDBS] Rsp: ", string(ret))
+var ErrServerClosed = errors.New("[SDBS] server closed")
+
+type clientIdKey struct{}
+
+// ClientIdFrom returns the id of the client that signed the request served
+// with ctx, empty when the server does not authenticate.
+func ClientIdFrom(ctx context.Context) string {
+	id, _ := ctx.Value(clientIdKey{}).(string)
+	return id
 }
 
-func Serve() {
-	l, err := net.Listen("tcp", ":9999")
+const (
+	DefaultAddr               = ":9999"
+	DefaultReadTimeout        = 10 * time.Second
+	DefaultWriteTimeout       = 10 * time.Second
+	DefaultHandlerTimeout     = 10 * time.Second
+	DefaultIdleTimeout        = 60 * time.Second
+	DefaultMaxConns           = 1024
+	DefaultMaxRequestsPerConn = 1000
+	DefaultMaxMessageSize     = simple.DefaultMaxMessageSize
+)
+
+// Server serves SDBS connections, zero fields take the defaults above.
+type Server struct {
+	// Addr is the tcp address to listen on for ListenAndServe.
+	Addr string
+
+	// ReadTimeout and WriteTimeout bound reading a request message and
+	// writing a response, HandlerTimeout bounds the context of a request.
+	ReadTimeout    time.Duration
+	WriteTimeout   time.Duration
+	HandlerTimeout time.Duration
+
+	// Connections are kept open for further requests until they stay idle
+	// for IdleTimeout or have served MaxRequestsPerConn requests, a negative
+	// MaxRequestsPerConn meaning no limit.
+	IdleTimeout        time.Duration
+	MaxRequestsPerConn int
+
+	// MaxConns caps the connections served at once, further ones wait in
+	// the listen backlog. Negative means no limit.
+	MaxConns int
+
+	// MaxMessageSize bounds a single message, page or whole.
+	MaxMessageSize int
+
+	// Handler serves the decoded requests, nil means DefaultRegistry.
+	Handler Handler
+
+	// TLSConfig, if set, makes Serve and ListenAndServe accept TLS
+	// connections only, see simple.TLSFiles for configs verifying clients
+	// and reloading certificates.
+	TLSConfig *tls.Config
+
+	// Auth authenticates requests before they are decoded and dispatched,
+	// unsigned or forged ones being answered ErrCodeUnauthorized. Nil
+	// serves anyone.
+	Auth *simple.Verifier
+
+	initOnce sync.Once
+	slots    chan struct{}
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[*serverConn]struct{}
+	wg        sync.WaitGroup
+
+	inShutdown int32
+	ctx        context.Context
+	cancel     context.CancelFunc
+}
+
+func (s *Server) init() {
+	s.initOnce.Do(func() {
+		if s.Addr == "" {
+			s.Addr = DefaultAddr
+		}
+		if s.ReadTimeout <= 0 {
+			s.ReadTimeout = DefaultReadTimeout
+		}
+		if s.WriteTimeout <= 0 {
+			s.WriteTimeout = DefaultWriteTimeout
+		}
+		if s.HandlerTimeout <= 0 {
+			s.HandlerTimeout = DefaultHandlerTimeout
+		}
+		if s.IdleTimeout <= 0 {
+			s.IdleTimeout = DefaultIdleTimeout
+		}
+		if s.MaxRequestsPerConn == 0 {
+			s.MaxRequestsPerConn = DefaultMaxRequestsPerConn
+		}
+		if s.MaxConns == 0 {
+			s.MaxConns = DefaultMaxConns
+		}
+		if s.MaxConns > 0 {
+			s.slots = make(chan struct{}, s.MaxConns)
+		}
+		if s.MaxMessageSize <= 0 {
+			s.MaxMessageSize = DefaultMaxMessageSize
+		}
+		if s.Handler == nil {
+			s.Handler = DefaultRegistry
+		}
+
+		s.mu.Lock()
+		s.ctx, s.cancel = context.WithCancel(context.Background())
+		s.mu.Unlock()
+	})
+}
+
+func (s *Server) ListenAndServe() error {
+	s.init()
+	if s.shuttingDown() {
+		return ErrServerClosed
+	}
+
+	l, err := net.Listen("tcp", s.Addr)
 	if err != nil {
-		fmt.Println("[SDBS] Error listening:", err.Error())
-		os.Exit(1)
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts connections on l until the server is shut down, in which
+// case ErrServerClosed is returned. l is closed on return. Connections are
+// TLS ones if TLSConfig is set.
+func (s *Server) Serve(l net.Listener) error {
+	s.init()
+	if s.TLSConfig != nil {
+		l = tls.NewListener(l, s.TLSConfig)
+	}
+	if !s.trackListener(l) {
+		l.Close()
+		return ErrServerClosed
 	}
-	// Close the listener when the application closes.
-	defer l.Close()
+	defer s.untrackListener(l)
 
+	var delay time.Duration
 	for {
-		// Listen for an incoming connection.
-		conn, err := l.Accept()
+		if !s.acquire() {
+			return ErrServerClosed
+		}
+
+		nc, err := l.Accept()
 		if err != nil {
-			fmt.Println("[SDBS] Error accepting: ", err.Error())
-			os.Exit(1)
+			s.release()
+			if s.shuttingDown() {
+				return ErrServerClosed
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				if delay == 0 {
+					delay = 5 * time.Millisecond
+				} else if delay *= 2; delay > time.Second {
+					delay = time.Second
+				}
+				fmt.Printf("[SDBS] Error accepting: %v, retrying in %v\n", err, delay)
+				time.Sleep(delay)
+				continue
+			}
+			return err
+		}
+		delay = 0
+
+		c := s.newConn(nc)
+		if !s.trackConn(c) {
+			nc.Close()
+			s.release()
+			return ErrServerClosed
 		}
 		// Handle connections in a new goroutine.
-		go handleRequest(conn)
+		go c.serve()
+	}
+}
+
+// Shutdown stops accepting connections and waits for the requests being
+// handled to be answered, closing each connection once it is idle. If ctx is
+// done first, the remaining connections are closed forcibly and the context
+// error is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.init()
+	atomic.StoreInt32(&s.inShutdown, 1)
+
+	s.mu.Lock()
+	for l := range s.listeners {
+		l.Close()
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		// wake up the connections waiting for a request, they re-check the
+		// shutdown flag before every request
+		s.mu.Lock()
+		for c := range s.conns {
+			if c.idle() {
+				c.SetReadDeadline(time.Now())
+			}
+		}
+		s.mu.Unlock()
+
+		select {
+		case <-done:
+			return nil
+		case <-ctx.Done():
+			s.Close()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Close closes all listeners and connections immediately and cancels the
+// contexts of the requests being handled.
+func (s *Server) Close() error {
+	s.init()
+	atomic.StoreInt32(&s.inShutdown, 1)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.cancel()
+	for l := range s.listeners {
+		l.Close()
+	}
+	for c := range s.conns {
+		c.Close()
+	}
+	return nil
+}
+
+func (s *Server) shuttingDown() bool {
+	return atomic.LoadInt32(&s.inShutdown) != 0
+}
+
+// acquire waits for a connection slot, it gives up when the server shuts down.
+func (s *Server) acquire() bool {
+	if s.slots == nil {
+		return true
+	}
+	for {
+		select {
+		case s.slots <- struct{}{}:
+			return true
+		case <-time.After(50 * time.Millisecond):
+			if s.shuttingDown() {
+				return false
+			}
+		}
+	}
+}
+
+func (s *Server) release() {
+	if s.slots != nil {
+		<-s.slots
+	}
+}
+
+func (s *Server) trackListener(l net.Listener) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.shuttingDown() {
+		return false
+	}
+	if s.listeners == nil {
+		s.listeners = make(map[net.Listener]struct{})
+	}
+	s.listeners[l] = struct{}{}
+	return true
+}
+
+func (s *Server) untrackListener(l net.Listener) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	l.Close()
+	delete(s.listeners, l)
+}
+
+func (s *Server) trackConn(c *serverConn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.shuttingDown() {
+		return false
+	}
+	if s.conns == nil {
+		s.conns = make(map[*serverConn]struct{})
+	}
+	s.conns[c] = struct{}{}
+	s.wg.Add(1)
+	return true
+}
+
+func (s *Server) untrackConn(c *serverConn) {
+	s.mu.Lock()
+	delete(s.conns, c)
+	s.mu.Unlock()
+
+	s.release()
+	s.wg.Done()
+}
+
+// Serve runs a server with the default settings until it fails.
+func Serve() {
+	if err := (&Server{}).ListenAndServe(); err != nil {
+		fmt.Println("[SDBS] Error serving:", err.Error())
 	}
 }
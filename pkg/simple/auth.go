@@ -0,0 +1,235 @@
+package simple
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Signed messages carry AuthSchemeHMAC in the reserved field of their header
+// and end their body with an auth block:
+//
+//	<auth><client_id>gateway-1</client_id><timestamp>1648811583</timestamp><nonce>…</nonce><signature>…</signature></auth>
+//
+// The signature is the hex HMAC-SHA256, under the key the client shares with
+// the server, of
+//
+//	type \n service code \n reserved \n client id \n timestamp \n nonce \n body
+//
+// body being the bytes on the wire before the auth block. Checksums cover the
+// auth block like the rest of the body. Peers that do not authenticate ignore
+// the block as any unknown element.
+
+// AuthSchemeHMAC is the reserved field of signed messages.
+const AuthSchemeHMAC = 1
+
+// DefaultAuthMaxSkew bounds the distance between the timestamp of a signed
+// message and the clock of the verifier.
+const DefaultAuthMaxSkew = 5 * time.Minute
+
+// ErrUnauthorized is wrapped by all the errors of Verify.
+var ErrUnauthorized = errors.New("[simple] unauthorized")
+
+// Keys maps client ids to the keys they share with the server.
+type Keys map[string][]byte
+
+// LoadKeys reads a JSON object mapping client ids to their keys, as in
+// {"gateway-1": "a long random secret"}.
+func LoadKeys(path string) (Keys, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var secrets map[string]string
+	if err := json.Unmarshal(data, &secrets); err != nil {
+		return nil, fmt.Errorf("keys %s: %w", path, err)
+	}
+
+	keys := make(Keys, len(secrets))
+	for id, secret := range secrets {
+		if err := checkClientId(id); err != nil {
+			return nil, fmt.Errorf("keys %s: %w", path, err)
+		}
+		if secret == "" {
+			return nil, fmt.Errorf("keys %s: empty key of client %q", path, id)
+		}
+		keys[id] = []byte(secret)
+	}
+	return keys, nil
+}
+
+func checkClientId(id string) error {
+	if id == "" || strings.ContainsAny(id, "\n<>&") {
+		return fmt.Errorf("invalid client id %q", id)
+	}
+	return nil
+}
+
+type authBlock struct {
+	XMLName   xml.Name `xml:"auth"`
+	ClientId  string   `xml:"client_id"`
+	Timestamp int64    `xml:"timestamp"`
+	Nonce     string   `xml:"nonce"`
+	Signature string   `xml:"signature"`
+}
+
+func (a *authBlock) sign(key []byte, h *Header, body []byte) string {
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "%s\n%d\n%d\n%s\n%d\n%s\n", h.Type, h.ServiceCode, h.Reserved, a.ClientId, a.Timestamp, a.Nonce)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Signer signs the messages of a client.
+type Signer struct {
+	ClientId string
+	Key      []byte
+}
+
+type signerKey struct{}
+
+// WithSigner returns a context making Encode sign messages with s, nil
+// disabling signing. Multi-page messages are signed whole, before they are
+// split into pages.
+func WithSigner(ctx context.Context, s *Signer) context.Context {
+	return context.WithValue(ctx, signerKey{}, s)
+}
+
+func signerFrom(ctx context.Context) *Signer {
+	if ctx == nil {
+		return nil
+	}
+	s, _ := ctx.Value(signerKey{}).(*Signer)
+	return s
+}
+
+// sign marks h signed and appends the auth block to the UTF-8 body, the
+// signature covering the body in the charset of ctx.
+func (s *Signer) sign(ctx context.Context, h *Header, body []byte) ([]byte, error) {
+	if err := checkClientId(s.ClientId); err != nil {
+		return nil, err
+	}
+
+	wire := body
+	if c := charsetFrom(ctx); c != nil {
+		var err error
+		if wire, err = c.Encode(body); err != nil {
+			return nil, err
+		}
+	}
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	a := &authBlock{ClientId: s.ClientId, Timestamp: time.Now().Unix(), Nonce: hex.EncodeToString(nonce)}
+	h.Reserved = AuthSchemeHMAC
+	a.Signature = a.sign(s.Key, h, wire)
+
+	block, err := xml.Marshal(a)
+	if err != nil {
+		return nil, err
+	}
+	return append(body[:len(body):len(body)], block...), nil
+}
+
+// Verifier authenticates signed messages, remembering their nonces as long
+// as their timestamp is acceptable so that none is accepted twice. It is
+// safe for concurrent use.
+type Verifier struct {
+	Keys Keys
+	// MaxSkew bounds the distance between the timestamp of a message and the
+	// clock of the verifier, zero meaning DefaultAuthMaxSkew.
+	MaxSkew time.Duration
+
+	mu     sync.Mutex
+	nonces map[nonceKey]time.Time
+	order  []nonceKey
+}
+
+type nonceKey struct {
+	clientId string
+	nonce    string
+}
+
+// Verify authenticates data, a whole message as on the wire, and returns the
+// id of the client that signed it. Errors wrap ErrUnauthorized.
+func (v *Verifier) Verify(data []byte) (string, error) {
+	var h Header
+	if len(data) < HeaderLen {
+		return "", fmt.Errorf("%w: incorrect data length", ErrUnauthorized)
+	}
+	if err := h.Decode(context.TODO(), data[:HeaderLen]); err != nil {
+		return "", fmt.Errorf("%w: %v", ErrUnauthorized, err)
+	}
+	if h.Reserved != AuthSchemeHMAC || len(data) < h.TotalLength {
+		return "", fmt.Errorf("%w: message not signed", ErrUnauthorized)
+	}
+
+	body := data[HeaderLen:h.TotalLength]
+	i := bytes.LastIndex(body, []byte("<auth>"))
+	if i < 0 || !bytes.HasSuffix(body, []byte("</auth>")) {
+		return "", fmt.Errorf("%w: message not signed", ErrUnauthorized)
+	}
+	a := &authBlock{}
+	if err := xml.Unmarshal(body[i:], a); err != nil {
+		return "", fmt.Errorf("%w: auth block: %v", ErrUnauthorized, err)
+	}
+
+	key, ok := v.Keys[a.ClientId]
+	if !ok {
+		return "", fmt.Errorf("%w: unknown client %q", ErrUnauthorized, a.ClientId)
+	}
+	if !hmac.Equal([]byte(a.sign(key, &h, body[:i])), []byte(a.Signature)) {
+		return "", fmt.Errorf("%w: signature mismatch, client %s", ErrUnauthorized, a.ClientId)
+	}
+
+	maxSkew := v.MaxSkew
+	if maxSkew <= 0 {
+		maxSkew = DefaultAuthMaxSkew
+	}
+	now := time.Now()
+	signedAt := time.Unix(a.Timestamp, 0)
+	if signedAt.Before(now.Add(-maxSkew)) || signedAt.After(now.Add(maxSkew)) {
+		return "", fmt.Errorf("%w: timestamp %d out of %s, client %s", ErrUnauthorized, a.Timestamp, maxSkew, a.ClientId)
+	}
+
+	if !v.remember(nonceKey{clientId: a.ClientId, nonce: a.Nonce}, signedAt.Add(maxSkew), now) {
+		return "", fmt.Errorf("%w: nonce reused, client %s", ErrUnauthorized, a.ClientId)
+	}
+	return a.ClientId, nil
+}
+
+// remember records key until expires, reporting false if it was already.
+func (v *Verifier) remember(key nonceKey, expires, now time.Time) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	// nonces are kept in arrival order, the front ones expire first but
+	// for the clock skew of their clients
+	for len(v.order) > 0 && v.nonces[v.order[0]].Before(now) {
+		delete(v.nonces, v.order[0])
+		v.order = v.order[1:]
+	}
+
+	if _, seen := v.nonces[key]; seen {
+		return false
+	}
+	if v.nonces == nil {
+		v.nonces = make(map[nonceKey]time.Time)
+	}
+	v.nonces[key] = expires
+	v.order = append(v.order, key)
+	return true
+}
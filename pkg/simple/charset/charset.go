@@ -0,0 +1,234 @@
+// Package charset transcodes SDBS message bodies between UTF-8 and the
+// character sets used by bank-side peers. The GBK and GB18030 tables are
+// built in, see maketables.py.
+package charset
+
+//go:generate sh -c "python3 maketables.py > tables.go"
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+var (
+	ErrInvalidSequence = errors.New("[charset] invalid byte sequence")
+	ErrUnencodable     = errors.New("[charset] character not representable")
+	ErrUnknownCharset  = errors.New("[charset] unknown charset")
+)
+
+// Charset converts bytes between UTF-8 and another encoding. Both directions
+// fail on the first byte sequence or character that cannot be converted,
+// errors wrap ErrInvalidSequence or ErrUnencodable and carry its offset.
+type Charset interface {
+	Name() string
+	// Encode converts UTF-8 text to the charset.
+	Encode(utf8 []byte) ([]byte, error)
+	// Decode converts text in the charset to UTF-8.
+	Decode(data []byte) ([]byte, error)
+}
+
+var (
+	// UTF8 passes text through, only checking that it is valid UTF-8.
+	UTF8 Charset = utf8Charset{}
+	// GBK is the Windows code page 936 flavour of GBK, where 0x80 is the euro
+	// sign.
+	GBK Charset = &gbCharset{name: "GBK"}
+	// GB18030 extends GBK with four-byte sequences covering all of Unicode.
+	GB18030 Charset = &gbCharset{name: "GB18030", fourByte: true}
+)
+
+// Lookup resolves a charset name as found in configuration, case insensitive:
+// utf-8, gbk (or cp936) and gb18030.
+func Lookup(name string) (Charset, error) {
+	switch strings.ToLower(name) {
+	case "utf-8", "utf8":
+		return UTF8, nil
+	case "gbk", "cp936":
+		return GBK, nil
+	case "gb18030":
+		return GB18030, nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownCharset, name)
+	}
+}
+
+type utf8Charset struct{}
+
+func (utf8Charset) Name() string {
+	return "UTF-8"
+}
+
+func (utf8Charset) Encode(data []byte) ([]byte, error) {
+	if i := invalidUTF8(data); i >= 0 {
+		return nil, fmt.Errorf("%w: UTF-8 at offset %d", ErrInvalidSequence, i)
+	}
+	return data, nil
+}
+
+func (c utf8Charset) Decode(data []byte) ([]byte, error) {
+	return c.Encode(data)
+}
+
+func invalidUTF8(data []byte) int {
+	for i := 0; i < len(data); {
+		r, n := utf8.DecodeRune(data[i:])
+		if r == utf8.RuneError && n <= 1 {
+			return i
+		}
+		i += n
+	}
+	return -1
+}
+
+type gbCharset struct {
+	name     string
+	fourByte bool
+}
+
+const (
+	euro     = 0x20ac
+	euroByte = 0x80
+
+	// linear indexes of four-byte sequences, supplementary planes start at
+	// 0x90 0x30 0x81 0x30.
+	bmpLinearEnd     = 39420
+	supplementaryLen = 0x100000
+)
+
+var (
+	reverseOnce sync.Once
+	reverse     map[rune]uint16
+)
+
+// reverseTable maps runes to their two-byte sequence, built on first use.
+func reverseTable() map[rune]uint16 {
+	reverseOnce.Do(func() {
+		reverse = make(map[rune]uint16, len(twoByte))
+		for i, r := range twoByte {
+			if r == 0 {
+				continue
+			}
+			if _, ok := reverse[rune(r)]; !ok {
+				reverse[rune(r)] = uint16((i/191+0x81)<<8 | (i%191 + 0x40))
+			}
+		}
+	})
+	return reverse
+}
+
+func (c *gbCharset) Name() string {
+	return c.name
+}
+
+func (c *gbCharset) Decode(data []byte) ([]byte, error) {
+	out := make([]byte, 0, len(data)+len(data)/2)
+	for i := 0; i < len(data); {
+		b1 := data[i]
+		switch {
+		case b1 < 0x80:
+			out = append(out, b1)
+			i++
+			continue
+		case b1 == euroByte && !c.fourByte:
+			out = utf8.AppendRune(out, euro)
+			i++
+			continue
+		case b1 == euroByte || b1 == 0xff || i+1 >= len(data):
+			return nil, c.invalid(i)
+		}
+
+		b2 := data[i+1]
+		if c.fourByte && b2 >= 0x30 && b2 <= 0x39 {
+			r, ok := decodeFourByte(data[i:])
+			if !ok {
+				return nil, c.invalid(i)
+			}
+			out = utf8.AppendRune(out, r)
+			i += 4
+			continue
+		}
+
+		if b2 < 0x40 || b2 == 0x7f || b2 == 0xff {
+			return nil, c.invalid(i)
+		}
+		r := twoByte[int(b1-0x81)*191+int(b2-0x40)]
+		if r == 0 {
+			return nil, c.invalid(i)
+		}
+		out = utf8.AppendRune(out, rune(r))
+		i += 2
+	}
+	return out, nil
+}
+
+func decodeFourByte(data []byte) (rune, bool) {
+	if len(data) < 4 || data[2] < 0x81 || data[2] > 0xfe || data[3] < 0x30 || data[3] > 0x39 {
+		return 0, false
+	}
+	linear := int(data[0]-0x81)*12600 + int(data[1]-0x30)*1260 + int(data[2]-0x81)*10 + int(data[3]-0x30)
+
+	if linear < bmpLinearEnd {
+		i := sort.Search(len(fourByteBMP), func(i int) bool { return int(fourByteBMP[i][0]) > linear }) - 1
+		r := rune(int(fourByteBMP[i][1]) + linear - int(fourByteBMP[i][0]))
+		// the last run ends with U+FFFF
+		return r, r <= 0xffff
+	}
+
+	supplementary := linear - (0x90-0x81)*12600
+	if supplementary < 0 || supplementary >= supplementaryLen {
+		return 0, false
+	}
+	return rune(0x10000 + supplementary), true
+}
+
+func (c *gbCharset) Encode(data []byte) ([]byte, error) {
+	table := reverseTable()
+	out := make([]byte, 0, len(data))
+	for i := 0; i < len(data); {
+		r, n := utf8.DecodeRune(data[i:])
+		if r == utf8.RuneError && n <= 1 {
+			return nil, fmt.Errorf("%w: UTF-8 at offset %d", ErrInvalidSequence, i)
+		}
+
+		switch seq, ok := table[r]; {
+		case r < 0x80:
+			out = append(out, byte(r))
+		case r == euro && !c.fourByte:
+			out = append(out, euroByte)
+		case ok:
+			out = append(out, byte(seq>>8), byte(seq))
+		case c.fourByte:
+			out = appendFourByte(out, r)
+		default:
+			return nil, fmt.Errorf("%w: %U in %s at offset %d", ErrUnencodable, r, c.name, i)
+		}
+		i += n
+	}
+	return out, nil
+}
+
+func appendFourByte(out []byte, r rune) []byte {
+	var linear int
+	if r >= 0x10000 {
+		linear = (0x90-0x81)*12600 + int(r-0x10000)
+	} else {
+		i := sort.Search(len(fourByteBMP), func(i int) bool { return rune(fourByteBMP[i][1]) > r }) - 1
+		linear = int(fourByteBMP[i][0]) + int(r) - int(fourByteBMP[i][1])
+	}
+
+	b4 := linear % 10
+	linear /= 10
+	b3 := linear % 126
+	linear /= 126
+	b2 := linear % 10
+	b1 := linear / 10
+	return append(out, byte(b1+0x81), byte(b2+0x30), byte(b3+0x81), byte(b4+0x30))
+}
+
+func (c *gbCharset) invalid(offset int) error {
+	return fmt.Errorf("%w: %s at offset %d", ErrInvalidSequence, c.name, offset)
+}
@@ -0,0 +1,61 @@
+#!/usr/bin/env python3
+# Generates tables.go from the gb18030 codec of the Python standard library.
+#
+#   python3 maketables.py > tables.go
+
+import sys
+
+
+def two_byte_table():
+    table = []
+    for b1 in range(0x81, 0xFF):
+        for b2 in range(0x40, 0xFF):
+            r = 0
+            if b2 != 0x7F:
+                try:
+                    r = ord(bytes([b1, b2]).decode("gb18030"))
+                except UnicodeDecodeError:
+                    pass
+            table.append(r)
+    return table
+
+
+def four_byte_ranges():
+    ranges = []
+    prev = None
+    for r in range(0x80, 0x10000):
+        if 0xD800 <= r < 0xE000:
+            continue
+        b = chr(r).encode("gb18030")
+        if len(b) != 4:
+            continue
+        linear = (b[0] - 0x81) * 12600 + (b[1] - 0x30) * 1260 + (b[2] - 0x81) * 10 + (b[3] - 0x30)
+        if prev is None or linear != prev[0] + 1 or r != prev[1] + 1:
+            ranges.append((linear, r))
+        prev = (linear, r)
+    return ranges
+
+
+def main():
+    out = sys.stdout
+    out.write("// Code generated by maketables.py. DO NOT EDIT.\n\n")
+    out.write("package charset\n\n")
+
+    out.write("// twoByte maps the two-byte sequence b1 b2 to\n")
+    out.write("// twoByte[(b1-0x81)*191+(b2-0x40)], zero when unassigned.\n")
+    out.write("var twoByte = [...]uint16{\n")
+    table = two_byte_table()
+    for i in range(0, len(table), 12):
+        out.write("\t" + " ".join("0x%04x," % r for r in table[i:i + 12]) + "\n")
+    out.write("}\n\n")
+
+    out.write("// fourByteBMP lists the starts of the runs of consecutive runes encoded\n")
+    out.write("// with consecutive four-byte sequences, as {linear index, rune} pairs.\n")
+    out.write("var fourByteBMP = [...][2]uint16{\n")
+    for linear, r in four_byte_ranges():
+        out.write("\t{%d, 0x%04x},\n" % (linear, r))
+    out.write("}\n")
+
+
+if __name__ == "__main__":
+    main()
@@ -0,0 +1,105 @@
+package simple
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+)
+
+var ErrChecksumMismatch = errors.New("[simple] checksum mismatch")
+
+// Checksummer computes the checksum carried in the header over the message
+// body. Sum must return exactly ChecksumLen printable characters.
+type Checksummer interface {
+	Sum(body []byte) string
+}
+
+type ChecksumFunc func(body []byte) string
+
+func (f ChecksumFunc) Sum(body []byte) string {
+	return f(body)
+}
+
+// MD5Checksum is the hex md5 digest of the body, the checksum the SDBS
+// server computes and verifies unless configured otherwise. The codec only
+// uses it when given through WithChecksum.
+var MD5Checksum Checksummer = ChecksumFunc(func(body []byte) string {
+	sum := md5.Sum(body)
+	return hex.EncodeToString(sum[:])
+})
+
+// SHA256Checksum is the hex sha256 digest of the body truncated to the
+// checksum width.
+var SHA256Checksum Checksummer = ChecksumFunc(func(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])[:ChecksumLen]
+})
+
+// HMACChecksum is a keyed checksum, the hex hmac of the body truncated to the
+// checksum width. Peers without the key can neither forge nor verify it.
+func HMACChecksum(h func() hash.Hash, key []byte) Checksummer {
+	return ChecksumFunc(func(body []byte) string {
+		mac := hmac.New(h, key)
+		mac.Write(body)
+		sum := hex.EncodeToString(mac.Sum(nil))
+		for len(sum) < ChecksumLen {
+			sum += "0"
+		}
+		return sum[:ChecksumLen]
+	})
+}
+
+// ChecksumByName resolves the algorithm names used in configuration: md5,
+// sha256, hmac-md5 and hmac-sha256. The hmac variants require a key.
+func ChecksumByName(name string, key []byte) (Checksummer, error) {
+	switch name {
+	case "md5":
+		return MD5Checksum, nil
+	case "sha256":
+		return SHA256Checksum, nil
+	case "hmac-md5", "hmac-sha256":
+		if len(key) == 0 {
+			return nil, fmt.Errorf("[simple] checksum %s requires a key", name)
+		}
+		if name == "hmac-md5" {
+			return HMACChecksum(md5.New, key), nil
+		}
+		return HMACChecksum(sha256.New, key), nil
+	default:
+		return nil, fmt.Errorf("[simple] unknown checksum algorithm %q", name)
+	}
+}
+
+type checksumKey struct{}
+
+// WithChecksum returns a context making Encode fill the header checksum with
+// c and Decode verify it. Without it, or with c nil, the codec neither
+// computes nor verifies checksums: Encode writes Header.Checksum as is, empty
+// unless set, and Decode accepts any.
+func WithChecksum(ctx context.Context, c Checksummer) context.Context {
+	return context.WithValue(ctx, checksumKey{}, c)
+}
+
+func checksumFrom(ctx context.Context) Checksummer {
+	if ctx == nil {
+		return nil
+	}
+	c, _ := ctx.Value(checksumKey{}).(Checksummer)
+	return c
+}
+
+func verifyChecksum(ctx context.Context, h *Header, body []byte) error {
+	c := checksumFrom(ctx)
+	if c == nil {
+		return nil
+	}
+	if want := c.Sum(body); !hmac.Equal([]byte(want), []byte(h.Checksum)) {
+		return fmt.Errorf("%w: got %q, want %q", ErrChecksumMismatch, h.Checksum, want)
+	}
+	return nil
+}
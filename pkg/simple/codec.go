@@ -0,0 +1,214 @@
+package simple
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/fdingiit/mpl/pkg/simple/charset"
+)
+
+var ErrInvalidMessage = errors.New("[simple] invalid message type")
+
+// Message is implemented by every type embedding Header.
+type Message interface {
+	header() *Header
+}
+
+func (h *Header) header() *Header {
+	return h
+}
+
+// HeaderOf returns the header embedded in msg.
+func HeaderOf(msg Message) *Header {
+	return msg.header()
+}
+
+// defaultRoot is the root element wrapping bodies on their way through
+// encoding/xml. It never appears on the wire.
+const defaultRoot = "body"
+
+var (
+	headerType  = reflect.TypeOf(Header{})
+	xmlNameType = reflect.TypeOf(xml.Name{})
+)
+
+// messageInfo is what Marshal and Unmarshal learn about a message type, cached
+// per type.
+type messageInfo struct {
+	root string
+}
+
+var messageInfos sync.Map // reflect.Type -> *messageInfo
+
+// infoOf checks that v is a non-nil pointer to a struct embedding Header by
+// value and returns its cached description.
+func infoOf(v Message) (*messageInfo, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("%w: %T is not a pointer to a struct", ErrInvalidMessage, v)
+	}
+
+	t := rv.Elem().Type()
+	if info, ok := messageInfos.Load(t); ok {
+		return info.(*messageInfo), nil
+	}
+
+	if f, ok := t.FieldByName("Header"); !ok || !f.Anonymous || f.Type != headerType || len(f.Index) != 1 {
+		return nil, fmt.Errorf("%w: %v does not embed simple.Header", ErrInvalidMessage, t)
+	}
+
+	info := &messageInfo{root: defaultRoot}
+	if f, ok := t.FieldByName("XMLName"); ok && f.Type == xmlNameType {
+		name := strings.Split(f.Tag.Get("xml"), ",")[0]
+		if strings.Contains(name, " ") {
+			return nil, fmt.Errorf("%w: %v has a namespaced root element %q", ErrInvalidMessage, t, name)
+		}
+		if name != "" {
+			info.root = name
+		}
+	}
+
+	actual, _ := messageInfos.LoadOrStore(t, info)
+	return actual.(*messageInfo), nil
+}
+
+// Marshal encodes v, a pointer to a struct embedding Header, as a complete
+// message: the header with its total length (and checksum, see WithChecksum)
+// computed, followed by the xml body of the other fields without their root
+// element. Fields are rendered following their xml tags.
+func Marshal(ctx context.Context, v Message) ([]byte, error) {
+	info, err := infoOf(v)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := encodeBody(v, info.root)
+	if err != nil {
+		return nil, err
+	}
+
+	return encodeMessage(ctx, v.header(), body)
+}
+
+// Unmarshal decodes a complete message into v, a pointer to a struct
+// embedding Header. Bytes past the total length are ignored. On a checksum
+// mismatch v is filled anyway and the error wraps ErrChecksumMismatch.
+func Unmarshal(ctx context.Context, data []byte, v Message) error {
+	info, err := infoOf(v)
+	if err != nil {
+		return err
+	}
+
+	return decodeMessage(ctx, v.header(), data, v, info.root)
+}
+
+// encodeBody marshals the xml body of v without its root element.
+func encodeBody(v interface{}, root string) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := xml.NewEncoder(&buf).EncodeElement(v, xml.StartElement{Name: xml.Name{Local: root}}); err != nil {
+		return nil, err
+	}
+
+	body := buf.Bytes()
+	start := bytes.IndexByte(body, '>') + 1
+	end := bytes.LastIndex(body, []byte("</"))
+	if start <= 0 || end < start {
+		// self-closing root, the message has no body field
+		return []byte{}, nil
+	}
+	return body[start:end], nil
+}
+
+type charsetKey struct{}
+
+// WithCharset returns a context making Encode convert the xml body from UTF-8
+// to c, and Decode convert it back. Total length and checksum are computed
+// over the converted bytes. Without it, bodies are sent as UTF-8 unchecked.
+func WithCharset(ctx context.Context, c charset.Charset) context.Context {
+	return context.WithValue(ctx, charsetKey{}, c)
+}
+
+func charsetFrom(ctx context.Context) charset.Charset {
+	if ctx == nil {
+		return nil
+	}
+	c, _ := ctx.Value(charsetKey{}).(charset.Charset)
+	return c
+}
+
+// encodeMessage signs the UTF-8 body if ctx has a signer, converts it to the
+// charset of ctx, fills the total length and the checksum of h and
+// concatenates header and body.
+func encodeMessage(ctx context.Context, h *Header, body []byte) ([]byte, error) {
+	if s := signerFrom(ctx); s != nil {
+		var err error
+		if body, err = s.sign(ctx, h, body); err != nil {
+			return nil, err
+		}
+	}
+	if c := charsetFrom(ctx); c != nil {
+		var err error
+		if body, err = c.Encode(body); err != nil {
+			return nil, err
+		}
+	}
+
+	h.TotalLength = HeaderLen + len(body)
+	if c := checksumFrom(ctx); c != nil {
+		h.Checksum = c.Sum(body)
+	}
+
+	head, err := h.Encode(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(head, body...), nil
+}
+
+// decodeMessage decodes the header of data into h and the xml body, converted
+// to UTF-8 and wrapped in root, into v, then verifies the checksum. v is
+// filled even when the checksum does not match.
+func decodeMessage(ctx context.Context, h *Header, data []byte, v interface{}, root string) error {
+	if len(data) < HeaderLen {
+		return errors.New("incorrect data length")
+	}
+
+	if err := h.Decode(ctx, data[:HeaderLen]); err != nil {
+		return err
+	}
+
+	if h.TotalLength < HeaderLen || len(data) < h.TotalLength {
+		return errors.New("incorrect data length")
+	}
+
+	body := data[HeaderLen:h.TotalLength]
+	text := body
+	if c := charsetFrom(ctx); c != nil {
+		var err error
+		if text, err = c.Decode(body); err != nil {
+			return err
+		}
+	}
+
+	xmlData := make([]byte, 0, len(text)+2*len(root)+5)
+	xmlData = append(xmlData, '<')
+	xmlData = append(xmlData, root...)
+	xmlData = append(xmlData, '>')
+	xmlData = append(xmlData, text...)
+	xmlData = append(xmlData, "</"...)
+	xmlData = append(xmlData, root...)
+	xmlData = append(xmlData, '>')
+
+	if err := xml.Unmarshal(xmlData, v); err != nil {
+		return err
+	}
+
+	return verifyChecksum(ctx, h, body)
+}
@@ -0,0 +1,243 @@
+package simple
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Error codes, the err_code of SDBS responses. The values are part of the
+// protocol and never change, new codes are appended.
+const (
+	ErrCodeNo = iota
+	ErrCodeIncorrectRequest
+	ErrCodeChecksumMismatch
+	ErrCodeUnknownService
+	ErrCodeInternal
+	ErrCodeInsufficientFunds
+	ErrCodeUnknownAccount
+	ErrCodeCurrencyMismatch
+	ErrCodeAccountFrozen
+	ErrCodeSerialNoReused
+	ErrCodeUnknownTransfer
+	ErrCodeAlreadyReversed
+	ErrCodeUnauthorized
+	ErrCodeTimeout
+)
+
+// ErrCategory groups error codes by what a client can do about them.
+type ErrCategory string
+
+const (
+	// CategoryNone is the category of ErrCodeNo.
+	CategoryNone ErrCategory = ""
+	// CategoryValidation is a request the server cannot accept as it is.
+	CategoryValidation ErrCategory = "validation"
+	// CategoryAuth is a request whose origin or integrity failed to verify.
+	CategoryAuth ErrCategory = "auth"
+	// CategoryFunds is a transfer refused by the state of an account.
+	CategoryFunds ErrCategory = "funds"
+	// CategoryDuplicate is a request conflicting with one already served.
+	CategoryDuplicate ErrCategory = "duplicate"
+	// CategoryUnknownService is a service code the server does not serve.
+	CategoryUnknownService ErrCategory = "unknown_service"
+	// CategoryInternal is a failure of the server.
+	CategoryInternal ErrCategory = "internal"
+	// CategoryTimeout is a request the server gave up on in time.
+	CategoryTimeout ErrCategory = "timeout"
+)
+
+// ErrCodeInfo documents an error code. Retryable codes may succeed when the
+// same request is sent again, transfers being idempotent by serial number;
+// others fail the same way until the request or the accounts change.
+type ErrCodeInfo struct {
+	Code        int
+	Name        string
+	Category    ErrCategory
+	Retryable   bool
+	Description string
+}
+
+// errCodes is the catalogue, indexed by code.
+var errCodes = []ErrCodeInfo{
+	{ErrCodeNo, "OK", CategoryNone, false, "the request was served"},
+	{ErrCodeIncorrectRequest, "INCORRECT_REQUEST", CategoryValidation, false, "the request is malformed or a field is out of range"},
+	{ErrCodeChecksumMismatch, "CHECKSUM_MISMATCH", CategoryAuth, false, "the checksum of the request does not match its body"},
+	{ErrCodeUnknownService, "UNKNOWN_SERVICE", CategoryUnknownService, false, "no service has the service code of the request"},
+	{ErrCodeInternal, "INTERNAL", CategoryInternal, true, "the server failed to serve the request"},
+	{ErrCodeInsufficientFunds, "INSUFFICIENT_FUNDS", CategoryFunds, false, "the paying account cannot cover the amount"},
+	{ErrCodeUnknownAccount, "UNKNOWN_ACCOUNT", CategoryValidation, false, "an account of the request does not exist"},
+	{ErrCodeCurrencyMismatch, "CURRENCY_MISMATCH", CategoryValidation, false, "an account holds no balance in the currency of the request"},
+	{ErrCodeAccountFrozen, "ACCOUNT_FROZEN", CategoryFunds, false, "an account of the request is frozen"},
+	{ErrCodeSerialNoReused, "SERIAL_NO_REUSED", CategoryDuplicate, false, "the serial number was used by a different request"},
+	{ErrCodeUnknownTransfer, "UNKNOWN_TRANSFER", CategoryValidation, false, "no transfer has the original serial number"},
+	{ErrCodeAlreadyReversed, "ALREADY_REVERSED", CategoryDuplicate, false, "the transfer was reversed already"},
+	{ErrCodeUnauthorized, "UNAUTHORIZED", CategoryAuth, false, "the client of the request failed to authenticate"},
+	{ErrCodeTimeout, "TIMEOUT", CategoryTimeout, true, "the request was not served in time"},
+}
+
+// LookupErrCode returns the catalogue entry of code. Codes out of the
+// catalogue, from a newer server, are returned as internal failures that are
+// not retryable, with ok false.
+func LookupErrCode(code int) (info ErrCodeInfo, ok bool) {
+	if code < 0 || code >= len(errCodes) {
+		return ErrCodeInfo{Code: code, Name: "UNKNOWN", Category: CategoryInternal}, false
+	}
+	return errCodes[code], true
+}
+
+// ErrCodes returns the whole catalogue, ordered by code.
+func ErrCodes() []ErrCodeInfo {
+	return append([]ErrCodeInfo(nil), errCodes...)
+}
+
+// ErrCodeOf returns the error code of a response, 0 for messages without one.
+func ErrCodeOf(msg Message) int {
+	f := reflect.ValueOf(msg).Elem().FieldByName("ErrCode")
+	if f.Kind() != reflect.Int {
+		return 0
+	}
+	return int(f.Int())
+}
+
+// DefaultStatuses maps the categories to the HTTP statuses the HTTP fronts of
+// SDBS answer with.
+var DefaultStatuses = map[ErrCategory]int{
+	CategoryNone:           http.StatusOK,
+	CategoryValidation:     http.StatusBadRequest,
+	CategoryAuth:           http.StatusUnauthorized,
+	CategoryFunds:          http.StatusUnprocessableEntity,
+	CategoryDuplicate:      http.StatusConflict,
+	CategoryUnknownService: http.StatusNotFound,
+	CategoryInternal:       http.StatusInternalServerError,
+	CategoryTimeout:        http.StatusGatewayTimeout,
+}
+
+// StatusMapping maps error codes to HTTP statuses: by code first, then by
+// category, then by DefaultStatuses. Codes out of the catalogue take Default,
+// zero meaning 500. The zero value and nil map as DefaultStatuses.
+type StatusMapping struct {
+	Codes      map[int]int
+	Categories map[ErrCategory]int
+	Default    int
+}
+
+// Status returns the HTTP status of code.
+func (m *StatusMapping) Status(code int) int {
+	if m == nil {
+		m = &StatusMapping{}
+	}
+	if status, ok := m.Codes[code]; ok {
+		return status
+	}
+	info, ok := LookupErrCode(code)
+	if !ok {
+		if m.Default != 0 {
+			return m.Default
+		}
+		return http.StatusInternalServerError
+	}
+	if status, ok := m.Categories[info.Category]; ok {
+		return status
+	}
+	return DefaultStatuses[info.Category]
+}
+
+// Set maps key, an error code or a category name, to status.
+func (m *StatusMapping) Set(key string, status int) error {
+	if status < 100 || status > 599 {
+		return fmt.Errorf("%s: invalid http status %d", key, status)
+	}
+	if code, err := strconv.Atoi(key); err == nil {
+		if m.Codes == nil {
+			m.Codes = make(map[int]int)
+		}
+		m.Codes[code] = status
+		return nil
+	}
+	category := ErrCategory(key)
+	if _, ok := DefaultStatuses[category]; !ok || category == CategoryNone {
+		return fmt.Errorf("%q is neither an error code nor a category", key)
+	}
+	if m.Categories == nil {
+		m.Categories = make(map[ErrCategory]int)
+	}
+	m.Categories[category] = status
+	return nil
+}
+
+// ParseStatusMapping parses a comma separated list of key=status, keys being
+// error codes or category names, as in "funds=409,5=402".
+func ParseStatusMapping(s string) (*StatusMapping, error) {
+	m := &StatusMapping{}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		i := strings.IndexByte(item, '=')
+		if i < 0 {
+			return nil, fmt.Errorf("status mapping %q: expected key=status", item)
+		}
+		status, err := strconv.Atoi(item[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("status mapping %q: %w", item, err)
+		}
+		if err := m.Set(item[:i], status); err != nil {
+			return nil, fmt.Errorf("status mapping: %w", err)
+		}
+	}
+	return m, nil
+}
+
+// ErrorBody is the JSON error body of the HTTP fronts of SDBS, for failures
+// with no SDBS response to answer. Errors lists the failing fields of an
+// invalid request.
+type ErrorBody struct {
+	ErrCode   int          `json:"err_code"`
+	Error     string       `json:"error"`
+	Category  ErrCategory  `json:"category"`
+	Retryable bool         `json:"retryable"`
+	Message   string       `json:"message"`
+	Errors    []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError is why a field of a request is invalid.
+type FieldError struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+// NewErrorBody returns the error body of code, message explaining it.
+func NewErrorBody(code int, message string) *ErrorBody {
+	info, _ := LookupErrCode(code)
+	return &ErrorBody{
+		ErrCode:   code,
+		Error:     info.Name,
+		Category:  info.Category,
+		Retryable: info.Retryable,
+		Message:   message,
+	}
+}
+
+// AddErrorFields adds the error, category and retryable fields of the error
+// body of code to the JSON object data, the JSON form of an SDBS response
+// failed with code.
+func AddErrorFields(data []byte, code int) ([]byte, error) {
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(data, &object); err != nil {
+		return nil, err
+	}
+	body := NewErrorBody(code, "")
+	for key, value := range map[string]interface{}{
+		"error":     body.Error,
+		"category":  body.Category,
+		"retryable": body.Retryable,
+	} {
+		object[key], _ = json.Marshal(value)
+	}
+	return json.Marshal(object)
+}
@@ -0,0 +1,146 @@
+package simple
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// DefaultMaxMessageSize bounds a single message read by a Reader.
+const DefaultMaxMessageSize = 1024 * 1024
+
+var (
+	ErrFrameTooLarge = errors.New("[simple] message exceeds the size limit")
+	ErrIdle          = errors.New("[simple] idle timeout waiting for a message")
+)
+
+// Reader reads whole messages off a stream such as a TCP connection, however
+// they were segmented on the way. Each message is framed by its 8-digit total
+// length.
+type Reader struct {
+	// MaxSize bounds the total length of a message, zero means
+	// DefaultMaxMessageSize.
+	MaxSize int
+	// Timeout bounds reading one message from its first byte on, when the
+	// stream supports read deadlines. Zero means no limit.
+	Timeout time.Duration
+	// IdleTimeout bounds the wait for the first byte of a message, on expiry
+	// ReadMessage returns ErrIdle. Zero leaves the wait to Timeout.
+	IdleTimeout time.Duration
+
+	r io.Reader
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: r}
+}
+
+// ReadMessage returns the next message, header included. It returns io.EOF
+// only when the stream ends cleanly between two messages, a stream ending
+// within a message gives io.ErrUnexpectedEOF.
+func (r *Reader) ReadMessage() ([]byte, error) {
+	d, _ := r.r.(interface{ SetReadDeadline(time.Time) error })
+	if d != nil && (r.Timeout > 0 || r.IdleTimeout > 0) {
+		wait := r.IdleTimeout
+		if wait <= 0 {
+			wait = r.Timeout
+		}
+		if err := d.SetReadDeadline(time.Now().Add(wait)); err != nil {
+			return nil, err
+		}
+		defer d.SetReadDeadline(time.Time{})
+	}
+
+	prefix := make([]byte, fieldTotalLength.width)
+	n, err := io.ReadAtLeast(r.r, prefix, 1)
+	if err != nil {
+		var ne net.Error
+		if r.IdleTimeout > 0 && errors.As(err, &ne) && ne.Timeout() {
+			return nil, ErrIdle
+		}
+		return nil, err
+	}
+
+	if d != nil && r.IdleTimeout > 0 {
+		var deadline time.Time
+		if r.Timeout > 0 {
+			deadline = time.Now().Add(r.Timeout)
+		}
+		if err := d.SetReadDeadline(deadline); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := io.ReadFull(r.r, prefix[n:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	totalLength, err := parseDigits(prefix, fieldTotalLength)
+	if err != nil {
+		return nil, err
+	}
+	if totalLength < HeaderLen {
+		return nil, fieldTotalLength.error(0, string(prefix), ErrFieldValue)
+	}
+	maxSize := r.MaxSize
+	if maxSize <= 0 {
+		maxSize = DefaultMaxMessageSize
+	}
+	if totalLength > maxSize {
+		return nil, fmt.Errorf("%w: %d bytes, limit is %d", ErrFrameTooLarge, totalLength, maxSize)
+	}
+
+	data := make([]byte, totalLength)
+	copy(data, prefix)
+	if _, err := io.ReadFull(r.r, data[len(prefix):]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+// Writer writes whole messages to a stream.
+type Writer struct {
+	// Timeout bounds one WriteMessage call when the stream supports write
+	// deadlines, zero means no limit.
+	Timeout time.Duration
+
+	w io.Writer
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// WriteMessage writes encoded messages, typically the pages of one message,
+// in a single call when the stream allows it. Each message must be exactly as
+// long as its total length says.
+func (w *Writer) WriteMessage(msgs ...[]byte) error {
+	for _, msg := range msgs {
+		if len(msg) < HeaderLen {
+			return fmt.Errorf("[simple] message of %d bytes is shorter than the header", len(msg))
+		}
+		if n, err := parseDigits(msg, fieldTotalLength); err != nil || n != len(msg) {
+			return fmt.Errorf("[simple] total length %q does not match the %d-byte message", fieldTotalLength.slice(msg), len(msg))
+		}
+	}
+
+	if d, ok := w.w.(interface{ SetWriteDeadline(time.Time) error }); ok && w.Timeout > 0 {
+		if err := d.SetWriteDeadline(time.Now().Add(w.Timeout)); err != nil {
+			return err
+		}
+		defer d.SetWriteDeadline(time.Time{})
+	}
+
+	// WriteTo consumes the slice it is called on, leave msgs to the caller
+	bufs := append(net.Buffers(nil), msgs...)
+	_, err := bufs.WriteTo(w.w)
+	return err
+}
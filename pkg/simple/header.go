@@ -0,0 +1,219 @@
+package simple
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// HeaderLen is the length of the fixed-width header preceding the xml body.
+const HeaderLen = 52
+
+const (
+	TypeRequest  = "RQ"
+	TypeResponse = "RS"
+)
+
+// ChecksumLen is the width of the checksum field. An empty checksum is
+// encoded as spaces.
+const ChecksumLen = 32
+
+/**
+ * Header
+ * 0          8     10   11                       43          51   52
+ * +----------+-----+----+------------------------+-----------+----+
+ * |totalLength|type|page|        checksum        |serviceCode|rsvd|
+ * +----------+-----+----+------------------------+-----------+----+
+ */
+type headerField struct {
+	name          string
+	offset, width int
+}
+
+var (
+	fieldTotalLength = headerField{"TotalLength", 0, 8}
+	fieldType        = headerField{"Type", 8, 2}
+	fieldPageMark    = headerField{"PageMark", 10, 1}
+	fieldChecksum    = headerField{"Checksum", 11, ChecksumLen}
+	fieldServiceCode = headerField{"ServiceCode", 43, 8}
+	fieldReserved    = headerField{"Reserved", 51, 1}
+)
+
+var (
+	ErrHeaderTooShort   = errors.New("header too short")
+	ErrFieldWidth       = errors.New("value does not fit the field width")
+	ErrFieldNotNumeric  = errors.New("non-digit character in numeric field")
+	ErrFieldNotPrinting = errors.New("non-printable character in field")
+	ErrFieldValue       = errors.New("value not allowed")
+)
+
+// HeaderError reports the header field that failed to encode or decode.
+type HeaderError struct {
+	Field string
+	// Offset of the offending byte in the header, or of the field start when
+	// the whole value is wrong.
+	Offset int
+	Value  string
+	Err    error
+}
+
+func (e *HeaderError) Error() string {
+	return fmt.Sprintf("[simple] header field %s at offset %d: %v: %q", e.Field, e.Offset, e.Err, e.Value)
+}
+
+func (e *HeaderError) Unwrap() error {
+	return e.Err
+}
+
+func (f headerField) error(offset int, value string, err error) *HeaderError {
+	return &HeaderError{Field: f.name, Offset: f.offset + offset, Value: value, Err: err}
+}
+
+type Header struct {
+	TotalLength int    `xml:"-"`
+	Type        string `xml:"-"`
+	PageMark    int    `xml:"-"`
+	Checksum    string `xml:"-"`
+	ServiceCode int    `xml:"-"`
+	Reserved    int    `xml:"-"`
+}
+
+// Encode renders the header in its 52-byte form. Every field is checked
+// against its width and allowed values, a *HeaderError names the first one
+// that does not comply.
+func (h *Header) Encode(ctx context.Context) ([]byte, error) {
+	if err := h.validate(); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 0, HeaderLen)
+	buf = appendDigits(buf, h.TotalLength, fieldTotalLength.width)
+	buf = append(buf, h.Type...)
+	buf = appendDigits(buf, h.PageMark, fieldPageMark.width)
+	if h.Checksum == "" {
+		buf = append(buf, blankChecksum...)
+	} else {
+		buf = append(buf, h.Checksum...)
+	}
+	buf = appendDigits(buf, h.ServiceCode, fieldServiceCode.width)
+	buf = appendDigits(buf, h.Reserved, fieldReserved.width)
+
+	return buf, nil
+}
+
+// Decode parses the first HeaderLen bytes of data with the same rules as
+// Encode. h is left untouched on error.
+func (h *Header) Decode(ctx context.Context, data []byte) error {
+	if len(data) < HeaderLen {
+		return &HeaderError{Field: "Header", Offset: len(data), Value: string(data), Err: ErrHeaderTooShort}
+	}
+
+	var d Header
+	var err error
+	if d.TotalLength, err = parseDigits(data, fieldTotalLength); err != nil {
+		return err
+	}
+	d.Type = string(fieldType.slice(data))
+	if d.PageMark, err = parseDigits(data, fieldPageMark); err != nil {
+		return err
+	}
+	checksum := fieldChecksum.slice(data)
+	for i, c := range checksum {
+		if c < ' ' || c > '~' {
+			return fieldChecksum.error(i, string(checksum), ErrFieldNotPrinting)
+		}
+	}
+	if string(checksum) != blankChecksum {
+		d.Checksum = string(checksum)
+	}
+	if d.ServiceCode, err = parseDigits(data, fieldServiceCode); err != nil {
+		return err
+	}
+	if d.Reserved, err = parseDigits(data, fieldReserved); err != nil {
+		return err
+	}
+
+	if err := d.validate(); err != nil {
+		return err
+	}
+
+	*h = d
+	return nil
+}
+
+var blankChecksum = strings.Repeat(" ", ChecksumLen)
+
+func (h *Header) validate() error {
+	if err := checkDigits(fieldTotalLength, h.TotalLength); err != nil {
+		return err
+	}
+	if h.TotalLength < HeaderLen {
+		return fieldTotalLength.error(0, strconv.Itoa(h.TotalLength), ErrFieldValue)
+	}
+
+	if len(h.Type) != fieldType.width {
+		return fieldType.error(0, h.Type, ErrFieldWidth)
+	}
+	if h.Type != TypeRequest && h.Type != TypeResponse {
+		return fieldType.error(0, h.Type, ErrFieldValue)
+	}
+
+	if err := checkDigits(fieldPageMark, h.PageMark); err != nil {
+		return err
+	}
+	if h.PageMark > PageLast {
+		return fieldPageMark.error(0, strconv.Itoa(h.PageMark), ErrFieldValue)
+	}
+
+	if h.Checksum != "" && len(h.Checksum) != ChecksumLen {
+		return fieldChecksum.error(0, h.Checksum, ErrFieldWidth)
+	}
+	for i := 0; i < len(h.Checksum); i++ {
+		if c := h.Checksum[i]; c < ' ' || c > '~' {
+			return fieldChecksum.error(i, h.Checksum, ErrFieldNotPrinting)
+		}
+	}
+
+	if err := checkDigits(fieldServiceCode, h.ServiceCode); err != nil {
+		return err
+	}
+
+	return checkDigits(fieldReserved, h.Reserved)
+}
+
+func (f headerField) slice(data []byte) []byte {
+	return data[f.offset : f.offset+f.width]
+}
+
+// checkDigits reports whether v is representable in the decimal field f.
+func checkDigits(f headerField, v int) error {
+	if v < 0 {
+		return f.error(0, strconv.Itoa(v), ErrFieldValue)
+	}
+	if len(strconv.Itoa(v)) > f.width {
+		return f.error(0, strconv.Itoa(v), ErrFieldWidth)
+	}
+	return nil
+}
+
+func appendDigits(buf []byte, v int, width int) []byte {
+	s := strconv.Itoa(v)
+	for i := len(s); i < width; i++ {
+		buf = append(buf, '0')
+	}
+	return append(buf, s...)
+}
+
+func parseDigits(data []byte, f headerField) (int, error) {
+	raw := f.slice(data)
+	v := 0
+	for i, c := range raw {
+		if c < '0' || c > '9' {
+			return 0, f.error(i, string(raw), ErrFieldNotNumeric)
+		}
+		v = v*10 + int(c-'0')
+	}
+	return v, nil
+}
@@ -0,0 +1,157 @@
+package simple
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+var ErrUnknownField = errors.New("[simple] unknown field")
+
+// MarshalJSON renders the body of v as a JSON object keyed by the xml element
+// names of its fields, in field order; the header is left out. Struct fields
+// and slices of structs become nested objects and arrays the same way. This is
+// the form the gateway speaks to HTTP clients.
+func MarshalJSON(v Message) ([]byte, error) {
+	if _, err := infoOf(v); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := marshalJSONStruct(&buf, reflect.ValueOf(v).Elem()); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalJSON fills the body of v from a JSON object keyed by the xml
+// element names of its fields, the header is left untouched. Keys matching no
+// field are refused with ErrUnknownField, missing keys leave their field as is.
+func UnmarshalJSON(data []byte, v Message) error {
+	if _, err := infoOf(v); err != nil {
+		return err
+	}
+	return unmarshalJSONStruct(data, reflect.ValueOf(v).Elem(), "")
+}
+
+// jsonFields lists the fields of a struct rendered in JSON along with their
+// names, skipping the header, XMLName and unexported or ignored fields.
+func jsonFields(t reflect.Type) (names []string, index []int) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" || f.Type == headerType || f.Type == xmlNameType {
+			continue
+		}
+		name := strings.Split(f.Tag.Get("xml"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		names, index = append(names, name), append(index, i)
+	}
+	return names, index
+}
+
+func isJSONObject(t reflect.Type) bool {
+	return t.Kind() == reflect.Struct
+}
+
+func isJSONArray(t reflect.Type) bool {
+	return t.Kind() == reflect.Slice && isJSONObject(t.Elem())
+}
+
+func marshalJSONStruct(buf *bytes.Buffer, v reflect.Value) error {
+	names, index := jsonFields(v.Type())
+
+	buf.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(name)
+		buf.Write(key)
+		buf.WriteByte(':')
+
+		f := v.Field(index[i])
+		switch {
+		case isJSONObject(f.Type()):
+			if err := marshalJSONStruct(buf, f); err != nil {
+				return err
+			}
+		case isJSONArray(f.Type()):
+			buf.WriteByte('[')
+			for j := 0; j < f.Len(); j++ {
+				if j > 0 {
+					buf.WriteByte(',')
+				}
+				if err := marshalJSONStruct(buf, f.Index(j)); err != nil {
+					return err
+				}
+			}
+			buf.WriteByte(']')
+		default:
+			data, err := json.Marshal(f.Interface())
+			if err != nil {
+				return fmt.Errorf("[simple] field %s: %w", name, err)
+			}
+			buf.Write(data)
+		}
+	}
+	buf.WriteByte('}')
+	return nil
+}
+
+// unmarshalJSONStruct decodes the object data into v, path naming v in
+// errors.
+func unmarshalJSONStruct(data []byte, v reflect.Value, path string) error {
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(data, &object); err != nil {
+		return fmt.Errorf("[simple] %s: %w", strings.TrimSuffix(path, "."), err)
+	}
+	if object == nil {
+		return fmt.Errorf("[simple] %s: expected an object", strings.TrimSuffix(path, "."))
+	}
+
+	names, index := jsonFields(v.Type())
+	fields := make(map[string]int, len(names))
+	for i, name := range names {
+		fields[name] = index[i]
+	}
+
+	for key, raw := range object {
+		i, ok := fields[key]
+		if !ok {
+			return fmt.Errorf("%w: %s%s", ErrUnknownField, path, key)
+		}
+
+		f := v.Field(i)
+		switch {
+		case isJSONObject(f.Type()):
+			if err := unmarshalJSONStruct(raw, f, path+key+"."); err != nil {
+				return err
+			}
+		case isJSONArray(f.Type()):
+			var items []json.RawMessage
+			if err := json.Unmarshal(raw, &items); err != nil {
+				return fmt.Errorf("[simple] %s%s: %w", path, key, err)
+			}
+			s := reflect.MakeSlice(f.Type(), len(items), len(items))
+			for j, item := range items {
+				if err := unmarshalJSONStruct(item, s.Index(j), fmt.Sprintf("%s%s[%d].", path, key, j)); err != nil {
+					return err
+				}
+			}
+			f.Set(s)
+		default:
+			if err := json.Unmarshal(raw, f.Addr().Interface()); err != nil {
+				return fmt.Errorf("[simple] %s%s: %w", path, key, err)
+			}
+		}
+	}
+	return nil
+}
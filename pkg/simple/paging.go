@@ -0,0 +1,331 @@
+package simple
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// PageMark values. A message small enough travels as a single page, larger
+// ones are split into a first page, any number of middle pages and a last page.
+const (
+	PageSingle = 0
+	PageFirst  = 1
+	PageMiddle = 2
+	PageLast   = 3
+)
+
+const (
+	DefaultPageSize     = 4 * 1024
+	DefaultMaxPages     = 64
+	DefaultMaxPagedSize = 1024 * 1024
+	DefaultPageTimeout  = 30 * time.Second
+	// DefaultMaxPending and DefaultMaxPendingSize bound the incomplete
+	// messages an Assembler holds at once.
+	DefaultMaxPending     = 16
+	DefaultMaxPendingSize = 4 * DefaultMaxPagedSize
+)
+
+var (
+	ErrTooManyPages    = errors.New("[simple] too many pages")
+	ErrMessageTooLarge = errors.New("[simple] paged message too large")
+	ErrPageSequence    = errors.New("[simple] invalid page sequence")
+	ErrTooManyPending  = errors.New("[simple] too many pending paged messages")
+)
+
+// Page is the body of one page of a multi-page message. Data holds a slice of
+// the xml body of the original message, pages of the same message share the
+// serial number and are numbered from 1.
+type Page struct {
+	Header
+	SerialNo int    `xml:"serial_no"`
+	PageNo   int    `xml:"page_no"`
+	Data     string `xml:"data"`
+}
+
+func (p *Page) Encode(ctx context.Context) ([]byte, error) {
+	return Marshal(ctx, p)
+}
+
+func (p *Page) Decode(ctx context.Context, data []byte) error {
+	return Unmarshal(ctx, data, p)
+}
+
+// EncodePages encodes msg, splitting its body into pages carrying at most
+// pageSize bytes of it, counted in UTF-8 before any charset conversion. A body
+// that fits in one page is encoded as a plain single-page message. Each page
+// gets its own total length and checksum.
+func EncodePages(ctx context.Context, msg Message, serialNo int, pageSize int) ([][]byte, error) {
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
+	}
+
+	info, err := infoOf(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	h := msg.header()
+	body, err := encodeBody(msg, info.root)
+	if err != nil {
+		return nil, err
+	}
+
+	// the message is signed whole, its pages are not
+	if s := signerFrom(ctx); s != nil {
+		if body, err = s.sign(ctx, h, body); err != nil {
+			return nil, err
+		}
+		ctx = WithSigner(ctx, nil)
+	}
+
+	if len(body) <= pageSize {
+		h.PageMark = PageSingle
+		data, err := encodeMessage(ctx, h, body)
+		if err != nil {
+			return nil, err
+		}
+		return [][]byte{data}, nil
+	}
+
+	chunks := splitBody(body, pageSize)
+	if len(chunks) > DefaultMaxPages {
+		return nil, fmt.Errorf("%w: %d pages of %d bytes, limit is %d", ErrTooManyPages, len(chunks), pageSize, DefaultMaxPages)
+	}
+
+	pages := make([][]byte, 0, len(chunks))
+	for i, chunk := range chunks {
+		mark := PageMiddle
+		switch i {
+		case 0:
+			mark = PageFirst
+		case len(chunks) - 1:
+			mark = PageLast
+		}
+
+		p := &Page{
+			Header: Header{
+				Type:        h.Type,
+				PageMark:    mark,
+				ServiceCode: h.ServiceCode,
+				Reserved:    h.Reserved,
+			},
+			SerialNo: serialNo,
+			PageNo:   i + 1,
+			Data:     string(chunk),
+		}
+		data, err := p.Encode(ctx)
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, data)
+	}
+
+	return pages, nil
+}
+
+// splitBody cuts body in chunks of at most size bytes without splitting a
+// multi-byte character.
+func splitBody(body []byte, size int) [][]byte {
+	var chunks [][]byte
+	for len(body) > 0 {
+		n := size
+		if n >= len(body) {
+			n = len(body)
+		} else {
+			for n > 0 && !utf8.RuneStart(body[n]) {
+				n--
+			}
+			if n == 0 {
+				n = size
+			}
+		}
+		chunks = append(chunks, body[:n])
+		body = body[n:]
+	}
+	return chunks
+}
+
+// Assembler rebuilds multi-page messages received one page at a time, keyed
+// by type, service code and serial number. It is safe for concurrent use.
+type Assembler struct {
+	// MaxPages and MaxSize bound a single message, zero means the defaults.
+	MaxPages int
+	MaxSize  int
+	// MaxPending and MaxPendingSize bound the incomplete messages held at
+	// once, in number and in bytes, zero means the defaults. Pages starting
+	// a message beyond them are refused. MaxPendingSize is raised to MaxSize
+	// if lower.
+	MaxPending     int
+	MaxPendingSize int
+	// Timeout drops incomplete messages whose last page arrived this long ago.
+	Timeout time.Duration
+
+	mu      sync.Mutex
+	pending map[pageKey]*pageSet
+	// size is the sum of the sizes of the pending messages
+	size int
+}
+
+type pageKey struct {
+	typ         string
+	serviceCode int
+	serialNo    int
+}
+
+type pageSet struct {
+	header  Header
+	pages   map[int]string
+	size    int
+	last    int
+	updated time.Time
+}
+
+// Add feeds one received message. Single-page messages are returned as they
+// are. Pages are kept until the message is complete, then the whole message
+// is returned encoded as a single page, ready for Request or Response Decode.
+// A nil result with a nil error means more pages are expected.
+func (a *Assembler) Add(ctx context.Context, data []byte) ([]byte, error) {
+	var h Header
+	if len(data) < HeaderLen {
+		return nil, errors.New("incorrect data length")
+	}
+	if err := h.Decode(ctx, data[:HeaderLen]); err != nil {
+		return nil, err
+	}
+	if h.PageMark == PageSingle {
+		return data, nil
+	}
+
+	p := &Page{}
+	if err := p.Decode(ctx, data); err != nil {
+		return nil, err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.expire()
+
+	key := pageKey{typ: p.Type, serviceCode: p.ServiceCode, serialNo: p.SerialNo}
+	set, err := a.add(key, p)
+	if err != nil {
+		a.drop(key)
+		return nil, fmt.Errorf("%w: serial no %d", err, p.SerialNo)
+	}
+	if set.last == 0 || len(set.pages) < set.last {
+		return nil, nil
+	}
+	a.drop(key)
+
+	nos := make([]int, 0, len(set.pages))
+	for no := range set.pages {
+		nos = append(nos, no)
+	}
+	sort.Ints(nos)
+
+	body := make([]byte, 0, set.size)
+	for _, no := range nos {
+		body = append(body, set.pages[no]...)
+	}
+
+	whole := set.header
+	whole.PageMark = PageSingle
+	return encodeMessage(WithSigner(ctx, nil), &whole, body)
+}
+
+func (a *Assembler) add(key pageKey, p *Page) (*pageSet, error) {
+	maxPages, maxSize := a.MaxPages, a.MaxSize
+	if maxPages <= 0 {
+		maxPages = DefaultMaxPages
+	}
+	if maxSize <= 0 {
+		maxSize = DefaultMaxPagedSize
+	}
+	maxPending, maxPendingSize := a.MaxPending, a.MaxPendingSize
+	if maxPending <= 0 {
+		maxPending = DefaultMaxPending
+	}
+	if maxPendingSize <= 0 {
+		maxPendingSize = DefaultMaxPendingSize
+	}
+	if maxPendingSize < maxSize {
+		maxPendingSize = maxSize
+	}
+
+	if a.pending == nil {
+		a.pending = make(map[pageKey]*pageSet)
+	}
+	set, ok := a.pending[key]
+	if !ok {
+		if len(a.pending) >= maxPending {
+			// the messages already pending are kept, this one is refused
+			return nil, fmt.Errorf("%w: %d incomplete messages", ErrTooManyPending, len(a.pending))
+		}
+		set = &pageSet{
+			header: Header{Type: p.Type, ServiceCode: p.ServiceCode, Reserved: p.Reserved},
+			pages:  make(map[int]string),
+		}
+		a.pending[key] = set
+	}
+	set.updated = time.Now()
+
+	switch {
+	case p.PageNo < 1 || p.PageNo > maxPages:
+		return nil, fmt.Errorf("%w: page %d, limit is %d", ErrTooManyPages, p.PageNo, maxPages)
+	case (p.PageMark == PageFirst) != (p.PageNo == 1):
+		return nil, fmt.Errorf("%w: page %d marked %d", ErrPageSequence, p.PageNo, p.PageMark)
+	case p.PageMark == PageLast && set.last != 0:
+		return nil, fmt.Errorf("%w: second last page %d", ErrPageSequence, p.PageNo)
+	case set.last != 0 && p.PageNo >= set.last:
+		return nil, fmt.Errorf("%w: page %d after last page %d", ErrPageSequence, p.PageNo, set.last)
+	}
+	if _, dup := set.pages[p.PageNo]; dup {
+		return nil, fmt.Errorf("%w: duplicate page %d", ErrPageSequence, p.PageNo)
+	}
+
+	if p.PageMark == PageLast {
+		for no := range set.pages {
+			if no > p.PageNo {
+				return nil, fmt.Errorf("%w: page %d after last page %d", ErrPageSequence, no, p.PageNo)
+			}
+		}
+		set.last = p.PageNo
+	}
+
+	set.size += len(p.Data)
+	a.size += len(p.Data)
+	if set.size > maxSize {
+		return nil, fmt.Errorf("%w: more than %d bytes", ErrMessageTooLarge, maxSize)
+	}
+	if a.size > maxPendingSize {
+		return nil, fmt.Errorf("%w: more than %d bytes in incomplete messages", ErrTooManyPending, maxPendingSize)
+	}
+	set.pages[p.PageNo] = p.Data
+
+	return set, nil
+}
+
+func (a *Assembler) expire() {
+	timeout := a.Timeout
+	if timeout <= 0 {
+		timeout = DefaultPageTimeout
+	}
+	for key, set := range a.pending {
+		if time.Since(set.updated) > timeout {
+			a.drop(key)
+		}
+	}
+}
+
+// drop forgets the pending message of key, if any.
+func (a *Assembler) drop(key pageKey) {
+	if set, ok := a.pending[key]; ok {
+		a.size -= set.size
+		delete(a.pending, key)
+	}
+}
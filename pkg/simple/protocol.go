@@ -1,29 +1,6 @@
 package simple
 
-import (
-	"context"
-	"encoding/xml"
-	"errors"
-	"fmt"
-)
-
-type Header struct {
-	TotalLength int
-	Type        string
-	PageMark    int
-	Checksum    string
-	ServiceCode int
-	Reserved    int
-}
-
-func (h *Header) Encode(ctx context.Context) ([]byte, error) {
-	return []byte(fmt.Sprintf("%08d%s%d%32s%08d%d", h.TotalLength, h.Type, h.PageMark, h.Checksum, h.ServiceCode, h.Reserved)), nil
-}
-
-func (h *Header) Decode(ctx context.Context, data []byte) error {
-	// todo lab1-task-a
-	panic("implement me")
-}
+import "context"
 
 type Request struct {
 	Header
@@ -40,13 +17,11 @@ type Request struct {
 }
 
 func (r *Request) Encode(ctx context.Context) ([]byte, error) {
-	// todo lab1-task-b
-	panic("implement me")
+	return Marshal(ctx, r)
 }
 
 func (r *Request) Decode(ctx context.Context, data []byte) error {
-	// todo lab1-task-b
-	panic("implement me")
+	return Unmarshal(ctx, data, r)
 }
 
 type Response struct {
@@ -58,30 +33,201 @@ type Response struct {
 }
 
 func (r *Response) Encode(ctx context.Context) ([]byte, error) {
-	// todo lab1-task-b
-	panic("implement me")
+	return Marshal(ctx, r)
 }
 
 func (r *Response) Decode(ctx context.Context, data []byte) error {
-	if len(data) < 52 {
-		return errors.New("incorrect response data length")
-	}
+	return Unmarshal(ctx, data, r)
+}
+
+// Status of a transfer in a QueryResponse.
+const (
+	TransferBooked   = "booked"
+	TransferReversed = "reversed"
+)
+
+// QueryRequest asks for the status of the transfer the out bank sent under
+// OrigSerialNo.
+type QueryRequest struct {
+	Header
+	UnixTimestamp int64 `xml:"timestamp"`
+	SerialNo      int   `xml:"serial_no"`
+	OutBankId     int   `xml:"out_bank_id"`
+	OrigSerialNo  int   `xml:"orig_serial_no"`
+}
+
+func (r *QueryRequest) Encode(ctx context.Context) ([]byte, error) {
+	return Marshal(ctx, r)
+}
+
+func (r *QueryRequest) Decode(ctx context.Context, data []byte) error {
+	return Unmarshal(ctx, data, r)
+}
+
+// QueryResponse describes the queried transfer when it was booked, BookedAt
+// being a unix timestamp.
+type QueryResponse struct {
+	Header
+	UnixTimestamp int64  `xml:"timestamp"`
+	SerialNo      int    `xml:"serial_no"`
+	ErrCode       int    `xml:"err_code"`
+	Message       string `xml:"message"`
+	OrigSerialNo  int    `xml:"orig_serial_no"`
+	Status        string `xml:"status"`
+	BookedAt      int64  `xml:"booked_at"`
+	Currency      int    `xml:"currency"`
+	Amount        int    `xml:"amount"`
+	Unit          int    `xml:"unit"`
+	OutBankId     int    `xml:"out_bank_id"`
+	OutAccountId  int    `xml:"out_account_id"`
+	InBankId      int    `xml:"in_bank_id"`
+	InAccountId   int    `xml:"in_account_id"`
+}
 
-	if err := r.Header.Decode(ctx, data[:52]); err != nil {
-		return err
-	}
+func (r *QueryResponse) Encode(ctx context.Context) ([]byte, error) {
+	return Marshal(ctx, r)
+}
 
-	if len(data) < r.TotalLength {
-		return errors.New("incorrect response data length")
-	}
+func (r *QueryResponse) Decode(ctx context.Context, data []byte) error {
+	return Unmarshal(ctx, data, r)
+}
+
+// ReversalRequest cancels the transfer the out bank sent under OrigSerialNo,
+// moving its amount back.
+type ReversalRequest struct {
+	Header
+	UnixTimestamp int64  `xml:"timestamp"`
+	SerialNo      int    `xml:"serial_no"`
+	OutBankId     int    `xml:"out_bank_id"`
+	OrigSerialNo  int    `xml:"orig_serial_no"`
+	Notes         string `xml:"notes"`
+}
 
-	var xmlData []byte
-	xmlData = append([]byte("<response>"), data[52:]...)
-	xmlData = append(xmlData, []byte("</response>")...)
+func (r *ReversalRequest) Encode(ctx context.Context) ([]byte, error) {
+	return Marshal(ctx, r)
+}
 
-	if err := xml.Unmarshal(xmlData, r); err != nil {
-		return err
-	}
+func (r *ReversalRequest) Decode(ctx context.Context, data []byte) error {
+	return Unmarshal(ctx, data, r)
+}
+
+type ReversalResponse struct {
+	Header
+	UnixTimestamp int64  `xml:"timestamp"`
+	SerialNo      int    `xml:"serial_no"`
+	ErrCode       int    `xml:"err_code"`
+	Message       string `xml:"message"`
+	OrigSerialNo  int    `xml:"orig_serial_no"`
+}
+
+func (r *ReversalResponse) Encode(ctx context.Context) ([]byte, error) {
+	return Marshal(ctx, r)
+}
+
+func (r *ReversalResponse) Decode(ctx context.Context, data []byte) error {
+	return Unmarshal(ctx, data, r)
+}
+
+type BalanceRequest struct {
+	Header
+	UnixTimestamp int64 `xml:"timestamp"`
+	SerialNo      int   `xml:"serial_no"`
+	BankId        int   `xml:"bank_id"`
+	AccountId     int   `xml:"account_id"`
+}
+
+func (r *BalanceRequest) Encode(ctx context.Context) ([]byte, error) {
+	return Marshal(ctx, r)
+}
+
+func (r *BalanceRequest) Decode(ctx context.Context, data []byte) error {
+	return Unmarshal(ctx, data, r)
+}
+
+// Balance is the amount an account holds in one currency and unit.
+type Balance struct {
+	Currency int   `xml:"currency"`
+	Unit     int   `xml:"unit"`
+	Amount   int64 `xml:"amount"`
+}
+
+// BalanceResponse lists every balance of the account, one balance element
+// each.
+type BalanceResponse struct {
+	Header
+	UnixTimestamp int64     `xml:"timestamp"`
+	SerialNo      int       `xml:"serial_no"`
+	ErrCode       int       `xml:"err_code"`
+	Message       string    `xml:"message"`
+	BankId        int       `xml:"bank_id"`
+	AccountId     int       `xml:"account_id"`
+	Frozen        bool      `xml:"frozen"`
+	Balances      []Balance `xml:"balance"`
+}
+
+func (r *BalanceResponse) Encode(ctx context.Context) ([]byte, error) {
+	return Marshal(ctx, r)
+}
+
+func (r *BalanceResponse) Decode(ctx context.Context, data []byte) error {
+	return Unmarshal(ctx, data, r)
+}
+
+// StatementRequest asks for the transfers of an account booked within
+// [From, To), unix timestamps, zero leaving that end open. AfterId skips the
+// entries up to that id, to continue a statement that had more entries.
+type StatementRequest struct {
+	Header
+	UnixTimestamp int64 `xml:"timestamp"`
+	SerialNo      int   `xml:"serial_no"`
+	BankId        int   `xml:"bank_id"`
+	AccountId     int   `xml:"account_id"`
+	From          int64 `xml:"from"`
+	To            int64 `xml:"to"`
+	AfterId       int   `xml:"after_id"`
+}
+
+func (r *StatementRequest) Encode(ctx context.Context) ([]byte, error) {
+	return Marshal(ctx, r)
+}
+
+func (r *StatementRequest) Decode(ctx context.Context, data []byte) error {
+	return Unmarshal(ctx, data, r)
+}
+
+// StatementEntry is one transfer seen from the account of the statement:
+// Amount is negative when the account paid, the counter account is the other
+// side. SerialNo was chosen by the paying bank. ReversalOf is the id of the
+// entry a reversal cancels.
+type StatementEntry struct {
+	Id               int   `xml:"id"`
+	BookedAt         int64 `xml:"booked_at"`
+	SerialNo         int   `xml:"serial_no"`
+	Currency         int   `xml:"currency"`
+	Unit             int   `xml:"unit"`
+	Amount           int64 `xml:"amount"`
+	CounterBankId    int   `xml:"counter_bank_id"`
+	CounterAccountId int   `xml:"counter_account_id"`
+	ReversalOf       int   `xml:"reversal_of"`
+}
+
+// StatementResponse lists the entries oldest first, one entry element each.
+// More is set when the statement was cut, the rest follows the last entry.
+// Long statements travel as multi-page messages.
+type StatementResponse struct {
+	Header
+	UnixTimestamp int64            `xml:"timestamp"`
+	SerialNo      int              `xml:"serial_no"`
+	ErrCode       int              `xml:"err_code"`
+	Message       string           `xml:"message"`
+	More          bool             `xml:"more"`
+	Entries       []StatementEntry `xml:"entry"`
+}
+
+func (r *StatementResponse) Encode(ctx context.Context) ([]byte, error) {
+	return Marshal(ctx, r)
+}
 
-	return nil
+func (r *StatementResponse) Decode(ctx context.Context, data []byte) error {
+	return Unmarshal(ctx, data, r)
 }
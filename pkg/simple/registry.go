@@ -0,0 +1,139 @@
+package simple
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// Service codes of the messages registered by this package.
+const (
+	// ServiceTransfer moves money, Request and Response.
+	ServiceTransfer = 1000501
+	// ServiceQuery asks for the status of a transfer, QueryRequest and
+	// QueryResponse.
+	ServiceQuery = 1000502
+	// ServiceReversal cancels a booked transfer, ReversalRequest and
+	// ReversalResponse.
+	ServiceReversal = 1000503
+	// ServiceBalance reads the balances of an account, BalanceRequest and
+	// BalanceResponse.
+	ServiceBalance = 1000504
+	// ServiceStatement lists the transfers of an account, StatementRequest
+	// and StatementResponse.
+	ServiceStatement = 1000505
+)
+
+var ErrUnknownService = errors.New("[simple] unknown service code")
+
+type serviceTypes struct {
+	request, response reflect.Type
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[int]serviceTypes)
+)
+
+func init() {
+	Register(ServiceTransfer, &Request{}, &Response{})
+	Register(ServiceQuery, &QueryRequest{}, &QueryResponse{})
+	Register(ServiceReversal, &ReversalRequest{}, &ReversalResponse{})
+	Register(ServiceBalance, &BalanceRequest{}, &BalanceResponse{})
+	Register(ServiceStatement, &StatementRequest{}, &StatementResponse{})
+}
+
+// Register makes the request and response types of a service code known to
+// NewMessage and DecodeMessage. request and response are pointers to structs
+// embedding Header, only their types are kept. Register panics if the service
+// code is already registered or a type is not a valid message.
+func Register(serviceCode int, request, response Message) {
+	if err := checkDigits(fieldServiceCode, serviceCode); err != nil {
+		panic(fmt.Sprintf("simple: Register service code %d: %v", serviceCode, err))
+	}
+	for _, v := range []Message{request, response} {
+		if _, err := infoOf(v); err != nil {
+			panic(fmt.Sprintf("simple: Register service code %d: %v", serviceCode, err))
+		}
+	}
+
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if _, dup := registry[serviceCode]; dup {
+		panic(fmt.Sprintf("simple: Register called twice for service code %d", serviceCode))
+	}
+	registry[serviceCode] = serviceTypes{
+		request:  reflect.TypeOf(request).Elem(),
+		response: reflect.TypeOf(response).Elem(),
+	}
+}
+
+// ServiceCodes returns the registered service codes in increasing order.
+func ServiceCodes() []int {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	codes := make([]int, 0, len(registry))
+	for code := range registry {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	return codes
+}
+
+// NewMessage allocates an empty message of the type registered for the service
+// code and message type, TypeRequest or TypeResponse. Its header carries both.
+func NewMessage(serviceCode int, typ string) (Message, error) {
+	registryMu.RLock()
+	types, ok := registry[serviceCode]
+	registryMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownService, serviceCode)
+	}
+
+	var t reflect.Type
+	switch typ {
+	case TypeRequest:
+		t = types.request
+	case TypeResponse:
+		t = types.response
+	default:
+		return nil, fieldType.error(0, typ, ErrFieldValue)
+	}
+
+	msg := reflect.New(t).Interface().(Message)
+	h := msg.header()
+	h.Type, h.ServiceCode = typ, serviceCode
+	return msg, nil
+}
+
+// DecodeMessage decodes a complete single-page message into a new value of the
+// type registered for its service code and type. Multi-page messages must go
+// through an Assembler first. As with Unmarshal, the message is returned along
+// with a checksum mismatch error.
+func DecodeMessage(ctx context.Context, data []byte) (Message, error) {
+	var h Header
+	if err := h.Decode(ctx, data); err != nil {
+		return nil, err
+	}
+	if h.PageMark != PageSingle {
+		return nil, fmt.Errorf("%w: page mark %d, assemble the pages first", ErrPageSequence, h.PageMark)
+	}
+
+	msg, err := NewMessage(h.ServiceCode, h.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := Unmarshal(ctx, data, msg); err != nil {
+		if errors.Is(err, ErrChecksumMismatch) {
+			return msg, err
+		}
+		return nil, err
+	}
+	return msg, nil
+}
@@ -0,0 +1,235 @@
+package simple
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// TLSFiles names the PEM files of one end of a TLS connection. The files are
+// read again on the handshakes following a change on disk, so certificates
+// are renewed without a restart. A change failing to load, a certificate
+// written before its key say, leaves the previous files in use until the
+// next change.
+type TLSFiles struct {
+	// CertFile and KeyFile hold the certificate chain presented to the peer
+	// and its key. Servers need them, clients present them when the server
+	// asks for a client certificate.
+	CertFile string
+	KeyFile  string
+
+	// CAFile holds the CA certificates verifying the peer. Servers then
+	// require client certificates signed by one of them, clients verify the
+	// server with them rather than with the system roots.
+	CAFile string
+}
+
+// ServerConfig returns the TLS config of a server presenting the certificate
+// of f, and verifying clients if f has a CA file.
+func (f TLSFiles) ServerConfig() (*tls.Config, error) {
+	if f.CertFile == "" || f.KeyFile == "" {
+		return nil, errors.New("[simple] tls: a server needs a certificate and a key")
+	}
+	certs, err := newReloader(loadKeyPair(f.CertFile, f.KeyFile), f.CertFile, f.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	base := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			cert, err := certs.get()
+			if err != nil {
+				return nil, err
+			}
+			return cert.(*tls.Certificate), nil
+		},
+	}
+	if f.CAFile == "" {
+		return base, nil
+	}
+
+	cas, err := newReloader(loadCAs(f.CAFile), f.CAFile)
+	if err != nil {
+		return nil, err
+	}
+	config := base.Clone()
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+	// the pool is looked up per handshake as ClientCAs cannot change
+	config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		pool, err := cas.get()
+		if err != nil {
+			return nil, err
+		}
+		c := base.Clone()
+		c.ClientAuth = tls.RequireAndVerifyClientCert
+		c.ClientCAs = pool.(*x509.CertPool)
+		return c, nil
+	}
+	return config, nil
+}
+
+// ClientConfig returns the TLS config of a client of the server named
+// serverName, usually the host of its address, presenting the certificate of
+// f if it has one. The name may only be left empty without a CA file.
+func (f TLSFiles) ClientConfig(serverName string) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12, ServerName: serverName}
+
+	if f.CertFile != "" || f.KeyFile != "" {
+		certs, err := newReloader(loadKeyPair(f.CertFile, f.KeyFile), f.CertFile, f.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, err := certs.get()
+			if err != nil {
+				return nil, err
+			}
+			return cert.(*tls.Certificate), nil
+		}
+	}
+	if f.CAFile == "" {
+		return config, nil
+	}
+	if serverName == "" {
+		return nil, errors.New("[simple] tls: no server name to verify")
+	}
+
+	cas, err := newReloader(loadCAs(f.CAFile), f.CAFile)
+	if err != nil {
+		return nil, err
+	}
+	// crypto/tls verifies against a fixed RootCAs, the server is verified
+	// here instead so that the pool can change
+	config.InsecureSkipVerify = true
+	config.VerifyConnection = func(cs tls.ConnectionState) error {
+		pool, err := cas.get()
+		if err != nil {
+			return err
+		}
+		if len(cs.PeerCertificates) == 0 {
+			return errors.New("[simple] tls: no server certificate")
+		}
+		opts := x509.VerifyOptions{
+			DNSName:       serverName,
+			Roots:         pool.(*x509.CertPool),
+			Intermediates: x509.NewCertPool(),
+		}
+		for _, cert := range cs.PeerCertificates[1:] {
+			opts.Intermediates.AddCert(cert)
+		}
+		_, err = cs.PeerCertificates[0].Verify(opts)
+		return err
+	}
+	return config, nil
+}
+
+func loadKeyPair(certFile, keyFile string) func() (interface{}, error) {
+	return func() (interface{}, error) {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("[simple] tls: %s: %w", certFile, err)
+		}
+		return &cert, nil
+	}
+}
+
+func loadCAs(caFile string) func() (interface{}, error) {
+	return func() (interface{}, error) {
+		data, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("[simple] tls: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("[simple] tls: no certificate in %s", caFile)
+		}
+		return pool, nil
+	}
+}
+
+// fileStamp tells whether a file changed since it was loaded.
+type fileStamp struct {
+	mod  time.Time
+	size int64
+}
+
+// reloader holds a value loaded from files, loading it again once they
+// change. It is safe for concurrent use.
+type reloader struct {
+	paths []string
+	load  func() (interface{}, error)
+
+	mu    sync.Mutex
+	value interface{}
+	// stamps of the files at the last load, successful or not
+	stamps []fileStamp
+}
+
+func newReloader(load func() (interface{}, error), paths ...string) (*reloader, error) {
+	r := &reloader{paths: paths, load: load}
+	if _, err := r.get(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// get returns the value, loaded again if the files changed. Failures are
+// returned only while no load succeeded, later ones are logged and the
+// previous value kept.
+func (r *reloader) get() (interface{}, error) {
+	stamps := make([]fileStamp, len(r.paths))
+	for i, path := range r.paths {
+		fi, err := os.Stat(path)
+		if err != nil {
+			// a file being replaced, retried on the next call
+			if value := r.current(); value != nil {
+				return value, nil
+			}
+			return nil, fmt.Errorf("[simple] tls: %w", err)
+		}
+		stamps[i] = fileStamp{mod: fi.ModTime(), size: fi.Size()}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.stamps != nil && sameStamps(stamps, r.stamps) {
+		if r.value == nil {
+			return nil, fmt.Errorf("[simple] tls: %v failed to load", r.paths)
+		}
+		return r.value, nil
+	}
+	r.stamps = stamps
+
+	value, err := r.load()
+	if err != nil {
+		if r.value == nil {
+			return nil, err
+		}
+		fmt.Println("[simple] Error reloading, keeping the previous files:", err.Error())
+		return r.value, nil
+	}
+	r.value = value
+	return value, nil
+}
+
+func (r *reloader) current() interface{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.value
+}
+
+func sameStamps(a, b []fileStamp) bool {
+	for i := range a {
+		if !a[i].mod.Equal(b[i].mod) || a[i].size != b[i].size {
+			return false
+		}
+	}
+	return true
+}
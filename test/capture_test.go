@@ -0,0 +1,161 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"testing"
+
+	"github.com/fdingiit/mpl/pkg/capture"
+	"github.com/fdingiit/mpl/pkg/plugin/demo/codec"
+	"github.com/fdingiit/mpl/pkg/simple"
+	"github.com/stretchr/testify/assert"
+	"mosn.io/pkg/buffer"
+)
+
+func captureStream(t *testing.T) []byte {
+	proto := codec.Proto{}
+	req, err := proto.Encode(context.TODO(), &codec.Request{
+		Type:      codec.TypeMessage,
+		RequestId: 7,
+		Payload:   buffer.NewIoBufferString("Hello World"),
+	})
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	rsp, err := proto.Encode(context.TODO(), &codec.Response{
+		Request: codec.Request{
+			Type:      codec.TypeMessage,
+			RequestId: 7,
+			Payload:   buffer.NewIoBufferString("Hello, I am server"),
+		},
+		Status: codec.ResponseStatusError,
+	})
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	body := "<serial_no>12345</serial_no><amount>100</amount>"
+	head, err := (&simple.Header{TotalLength: 52 + len(body), Type: "RQ", ServiceCode: 1000501}).Encode(context.TODO())
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	sdbs := append(head, body...)
+
+	var stream []byte
+	stream = append(stream, req.Bytes()...)
+	stream = append(stream, "garbage"...)
+	stream = append(stream, sdbs...)
+	stream = append(stream, rsp.Bytes()...)
+	stream = append(stream, codec.Magic, codec.TypeMessage, 7)
+	stream = append(stream, req.Bytes()[:5]...)
+	return stream
+}
+
+func Test_Capture_DecodeStream(t *testing.T) {
+	records := capture.DecodeStream(captureStream(t))
+	if !assert.Len(t, records, 6) {
+		for _, rec := range records {
+			t.Logf("@%d %s", rec.Offset, rec.Describe())
+		}
+		t.FailNow()
+	}
+
+	req, ok := records[0].Message.(*codec.Request)
+	if assert.True(t, ok) {
+		assert.Equal(t, uint32(7), req.RequestId)
+		assert.Equal(t, "Hello World", req.Payload.String())
+	}
+
+	assert.True(t, records[1].Malformed())
+	assert.Equal(t, 22, records[1].Offset)
+	assert.Equal(t, len("garbage"), records[1].Length)
+	assert.Contains(t, records[1].Err.Error(), "neither the demo magic")
+
+	sreq, ok := records[2].Message.(*simple.Request)
+	if assert.True(t, ok) {
+		assert.Equal(t, 1000501, sreq.ServiceCode)
+		assert.Equal(t, 12345, sreq.SerialNo)
+		assert.Equal(t, 100, sreq.Amount)
+	}
+
+	rsp, ok := records[3].Message.(*codec.Response)
+	if assert.True(t, ok) {
+		assert.Equal(t, codec.ResponseStatusError, rsp.Status)
+		assert.Equal(t, "Hello, I am server", rsp.Payload.String())
+	}
+
+	assert.True(t, records[4].Malformed())
+	assert.Contains(t, records[4].Err.Error(), "invalid demo direction 7")
+
+	assert.True(t, records[5].Malformed())
+	assert.Contains(t, records[5].Err.Error(), "truncated demo header")
+}
+
+func Test_Capture_ReadPcap(t *testing.T) {
+	stream := captureStream(t)
+	a, b, c := stream[:10], stream[10:40], stream[40:]
+
+	var pcap bytes.Buffer
+	hdr := make([]byte, 24)
+	binary.LittleEndian.PutUint32(hdr[0:4], 0xa1b2c3d4)
+	binary.LittleEndian.PutUint16(hdr[4:6], 2)
+	binary.LittleEndian.PutUint16(hdr[6:8], 4)
+	binary.LittleEndian.PutUint32(hdr[16:20], 65535)
+	binary.LittleEndian.PutUint32(hdr[20:24], capture.LinkTypeEthernet)
+	pcap.Write(hdr)
+
+	writePacket := func(srcPort, dstPort uint16, seq uint32, flags byte, payload []byte) {
+		tcp := make([]byte, 20)
+		binary.BigEndian.PutUint16(tcp[0:2], srcPort)
+		binary.BigEndian.PutUint16(tcp[2:4], dstPort)
+		binary.BigEndian.PutUint32(tcp[4:8], seq)
+		tcp[12] = 5 << 4
+		tcp[13] = flags
+		tcp = append(tcp, payload...)
+
+		ip := make([]byte, 20)
+		ip[0] = 0x45
+		binary.BigEndian.PutUint16(ip[2:4], uint16(20+len(tcp)))
+		ip[8] = 64
+		ip[9] = 6
+		copy(ip[12:16], []byte{127, 0, 0, 1})
+		copy(ip[16:20], []byte{127, 0, 0, 1})
+		ip = append(ip, tcp...)
+
+		eth := make([]byte, 14)
+		binary.BigEndian.PutUint16(eth[12:14], 0x0800)
+		eth = append(eth, ip...)
+
+		rec := make([]byte, 16)
+		binary.LittleEndian.PutUint32(rec[8:12], uint32(len(eth)))
+		binary.LittleEndian.PutUint32(rec[12:16], uint32(len(eth)))
+		pcap.Write(rec)
+		pcap.Write(eth)
+	}
+
+	// sequence numbers wrap around within the stream
+	var isn uint32 = 0xfffffff0
+	writePacket(50000, 2045, isn, 0x02, nil)
+	writePacket(2045, 50000, 1000, 0x12, nil)
+	writePacket(50000, 2045, isn+1, 0x18, a)
+	writePacket(50000, 2045, isn+1+40, 0x18, c) // out of order
+	writePacket(2045, 50000, 1001, 0x18, []byte("reply"))
+	writePacket(50000, 2045, isn+1+10, 0x18, b)
+	writePacket(50000, 2045, isn+1, 0x18, a) // retransmission
+
+	streams, err := capture.ReadPcap(&pcap)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	if !assert.Len(t, streams, 2) {
+		t.FailNow()
+	}
+
+	assert.Equal(t, "127.0.0.1:50000 -> 127.0.0.1:2045", streams[0].Flow())
+	assert.Equal(t, stream, streams[0].Data)
+	assert.Equal(t, 0, streams[0].Gaps)
+	assert.Equal(t, "reply", string(streams[1].Data))
+
+	_, err = capture.ReadPcap(bytes.NewReader(stream))
+	assert.Equal(t, capture.ErrNotPcap, err)
+}
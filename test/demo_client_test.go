@@ -0,0 +1,200 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fdingiit/mpl/pkg/plugin/demo/client"
+	"github.com/fdingiit/mpl/pkg/plugin/demo/codec"
+	"github.com/stretchr/testify/assert"
+	"mosn.io/pkg/buffer"
+)
+
+// typeSilent is a request type the echo server never answers.
+const typeSilent byte = 9
+
+// serveDemoEcho echoes message payloads and answers heartbeats. Responses are
+// written in reverse order of each read batch to exercise out-of-order
+// delivery on the client side.
+func serveDemoEcho(t *testing.T, addr string) net.Listener {
+	l, err := net.Listen("tcp", addr)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+
+				proto := codec.Proto{}
+				buf := buffer.NewIoBuffer(1024)
+				for {
+					if _, err := buf.ReadOnce(c); err != nil {
+						return
+					}
+					var rsps []*codec.Response
+					for {
+						frame, err := proto.Decode(context.TODO(), buf)
+						if err != nil {
+							return
+						}
+						if frame == nil {
+							break
+						}
+						req := frame.(*codec.Request)
+						if req.Type == typeSilent {
+							continue
+						}
+						rsps = append(rsps, &codec.Response{
+							Request: codec.Request{
+								Type:      req.Type,
+								RequestId: req.RequestId,
+								Payload:   req.Payload,
+							},
+							Status: codec.ResponseStatusSuccess,
+						})
+					}
+					for i := len(rsps) - 1; i >= 0; i-- {
+						out, _ := proto.Encode(context.TODO(), rsps[i])
+						if _, err := c.Write(out.Bytes()); err != nil {
+							return
+						}
+					}
+				}
+			}()
+		}
+	}()
+
+	return l
+}
+
+func Test_DemoClient_Call(t *testing.T) {
+	l := serveDemoEcho(t, "127.0.0.1:0")
+	defer l.Close()
+
+	cli, err := client.Dial(l.Addr().String(), client.Options{PoolSize: 1})
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	defer cli.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 64; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(context.TODO(), 3*time.Second)
+			defer cancel()
+
+			want := fmt.Sprintf("hello %d", i)
+			rsp, err := cli.Call(ctx, codec.TypeMessage, []byte(want))
+			if !assert.Nil(t, err) {
+				return
+			}
+			assert.Equal(t, codec.ResponseStatusSuccess, rsp.Status)
+			assert.Equal(t, want, rsp.Payload.String())
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Nil(t, cli.Ping(context.TODO()))
+}
+
+func Test_DemoClient_Deadline(t *testing.T) {
+	l := serveDemoEcho(t, "127.0.0.1:0")
+	defer l.Close()
+
+	cli, err := client.Dial(l.Addr().String(), client.Options{PoolSize: 1})
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	defer cli.Close()
+
+	ctx, cancel := context.WithTimeout(context.TODO(), 100*time.Millisecond)
+	defer cancel()
+
+	_, err = cli.Call(ctx, typeSilent, []byte("nobody answers"))
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	// the connection stays usable for the following calls
+	rsp, err := cli.Call(context.TODO(), codec.TypeMessage, []byte("still there"))
+	if assert.Nil(t, err) {
+		assert.Equal(t, "still there", rsp.Payload.String())
+	}
+}
+
+func Test_DemoClient_Reconnect(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	addr := l.Addr().String()
+
+	// the first server accepts and drops every connection
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+
+	cli, err := client.Dial(addr, client.Options{
+		PoolSize:          1,
+		HeartbeatInterval: 50 * time.Millisecond,
+		HeartbeatTimeout:  50 * time.Millisecond,
+		ReconnectInterval: 10 * time.Millisecond,
+	})
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	defer cli.Close()
+
+	_, err = cli.Call(context.TODO(), codec.TypeMessage, []byte("lost"))
+	assert.NotNil(t, err)
+
+	// bring a working server up on the same address
+	l.Close()
+	echo := serveDemoEcho(t, addr)
+	defer echo.Close()
+
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		rsp, err := cli.Call(context.TODO(), codec.TypeMessage, []byte("back"))
+		if err == nil {
+			assert.Equal(t, "back", rsp.Payload.String())
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Errorf("[failed] client did not reconnect: %+v", err)
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func Test_DemoClient_ClientClosed(t *testing.T) {
+	l := serveDemoEcho(t, "127.0.0.1:0")
+	defer l.Close()
+
+	cli, err := client.Dial(l.Addr().String(), client.Options{})
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	cli.Close()
+
+	_, err = cli.Call(context.TODO(), codec.TypeMessage, []byte("closed"))
+	assert.Equal(t, client.ErrClientClosed, err)
+}
@@ -0,0 +1,186 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"testing"
+
+	"github.com/fdingiit/mpl/pkg/plugin/demo/codec"
+	"mosn.io/api"
+	"mosn.io/pkg/buffer"
+	"mosn.io/pkg/header"
+)
+
+func demoFrame(dir byte, typ byte, requestId uint32, status uint16, payload string) []byte {
+	buf := []byte{codec.Magic, typ, dir}
+	buf = binary.BigEndian.AppendUint32(buf, requestId)
+	if dir == codec.DirResponse {
+		buf = binary.BigEndian.AppendUint16(buf, status)
+	}
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(payload)))
+	return append(buf, payload...)
+}
+
+func addDemoSeeds(f *testing.F) {
+	req := demoFrame(codec.DirRequest, codec.TypeMessage, 1, 0, "Hello World")
+	rsp := demoFrame(codec.DirResponse, codec.TypeMessage, 1, codec.ResponseStatusSuccess, "Hello, I am server")
+
+	f.Add(req)
+	f.Add(rsp)
+	f.Add(demoFrame(codec.DirRequest, codec.TypeHeartbeat, 2, 0, ""))
+	f.Add(demoFrame(codec.DirResponse, codec.TypeHeartbeat, 2, codec.ResponseStatusError, ""))
+	f.Add(append(append([]byte{}, req...), rsp...))
+	f.Add(req[:codec.RequestHeaderLen-1])
+	f.Add(rsp[:codec.RequestHeaderLen+1])
+	f.Add([]byte{})
+}
+
+// checkDemoFrame asserts that a frame decoded from data consumed exactly one
+// frame, carries a payload of the declared length that does not alias data,
+// and encodes back to the consumed bytes.
+func checkDemoFrame(t *testing.T, data []byte, frame interface{}, consumed int) {
+	var req *codec.Request
+	headerLen := codec.RequestHeaderLen
+	switch fr := frame.(type) {
+	case *codec.Request:
+		req = fr
+	case *codec.Response:
+		req = &fr.Request
+		headerLen = codec.ResponseHeaderLen
+	default:
+		t.Fatalf("unexpected frame %T", frame)
+	}
+
+	if req.Payload == nil {
+		t.Fatalf("nil payload")
+	}
+	if int(req.PayloadLen) != req.Payload.Len() {
+		t.Fatalf("payload length %d, declared %d", req.Payload.Len(), req.PayloadLen)
+	}
+	if consumed != headerLen+int(req.PayloadLen) {
+		t.Fatalf("consumed %d bytes for a %d-byte frame", consumed, headerLen+int(req.PayloadLen))
+	}
+
+	payload := append([]byte(nil), req.Payload.Bytes()...)
+	scratch := append([]byte(nil), data[:consumed]...)
+	for i := range data[:consumed] {
+		data[i] ^= 0xff
+	}
+	if !bytes.Equal(payload, req.Payload.Bytes()) {
+		t.Fatalf("payload aliases the input buffer")
+	}
+	copy(data, scratch)
+
+	out, err := (&codec.Proto{}).Encode(context.TODO(), frame)
+	if err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	if !bytes.Equal(out.Bytes(), data[:consumed]) {
+		t.Fatalf("round trip mismatch:\n got %x\nwant %x", out.Bytes(), data[:consumed])
+	}
+}
+
+func FuzzProtoDecode(f *testing.F) {
+	addDemoSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		data = append([]byte(nil), data...)
+		buf := buffer.NewIoBufferBytes(data)
+
+		frame, err := (&codec.Proto{}).Decode(context.TODO(), buf)
+		consumed := len(data) - buf.Len()
+		if err != nil || frame == nil {
+			if consumed != 0 {
+				t.Fatalf("consumed %d bytes without a frame, err = %v", consumed, err)
+			}
+			return
+		}
+
+		checkDemoFrame(t, data, frame, consumed)
+	})
+}
+
+func FuzzExampleMatcher(f *testing.F) {
+	addDemoSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		got := (&codec.Matcher{}).ExampleMatcher(data)
+
+		// the result follows from the magic, the direction and the length of
+		// the prefix alone
+		want := api.MatchSuccess
+		switch {
+		case len(data) > codec.MagicIdx && data[codec.MagicIdx] != codec.Magic,
+			len(data) > codec.DirIndex && data[codec.DirIndex] != codec.DirRequest && data[codec.DirIndex] != codec.DirResponse:
+			want = api.MatchFailed
+		case len(data) < codec.RequestHeaderLen:
+			want = api.MatchAgain
+		}
+		if got != want {
+			t.Fatalf("matched %x as %v, want %v", data, got, want)
+		}
+		if got != api.MatchSuccess {
+			return
+		}
+
+		// whatever matches is accepted by the decoder, unless its payload
+		// length is over the limit
+		headerLen, payloadIndex := codec.RequestHeaderLen, codec.RequestPayloadIndex
+		if data[codec.DirIndex] == codec.DirResponse {
+			headerLen, payloadIndex = codec.ResponseHeaderLen, codec.ResponsePayloadIndex
+		}
+		oversized := len(data) >= headerLen && binary.BigEndian.Uint32(data[payloadIndex:headerLen]) > codec.MaxPayloadLen
+		_, err := (&codec.Proto{}).Decode(context.TODO(), buffer.NewIoBufferBytes(append([]byte(nil), data...)))
+		if (err != nil) != oversized {
+			t.Fatalf("decoding matched %x: %v", data, err)
+		}
+	})
+}
+
+func FuzzNewRpcRequest(f *testing.F) {
+	addDemoSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		data = append([]byte(nil), data...)
+		buf := buffer.NewIoBufferBytes(data)
+
+		req := codec.NewRpcRequest(header.CommonHeader{"service": "demo"}, buf)
+		if req == nil {
+			return
+		}
+		if data[codec.DirIndex] != codec.DirRequest {
+			t.Fatalf("decoded a request from direction %d", data[codec.DirIndex])
+		}
+		if v, _ := req.Get("service"); v != "demo" {
+			t.Fatalf("headers not copied")
+		}
+		checkDemoFrame(t, data, req, len(data)-buf.Len())
+	})
+}
+
+func FuzzNewRpcResponse(f *testing.F) {
+	addDemoSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		data = append([]byte(nil), data...)
+		buf := buffer.NewIoBufferBytes(data)
+
+		rsp := codec.NewRpcResponse(nil, buf)
+		if rsp == nil {
+			return
+		}
+		if data[codec.DirIndex] != codec.DirResponse {
+			t.Fatalf("decoded a response from direction %d", data[codec.DirIndex])
+		}
+		checkDemoFrame(t, data, rsp, len(data)-buf.Len())
+	})
+}
+
+func Test_DemoCodec_NilBuffer(t *testing.T) {
+	if _, err := (&codec.Proto{}).Decode(context.TODO(), nil); err == nil {
+		t.Errorf("[failed] Decode(nil) error = nil")
+	}
+	if req := codec.NewRpcRequest(nil, nil); req != nil {
+		t.Errorf("[failed] NewRpcRequest(nil) = %+v", req)
+	}
+	if rsp := codec.NewRpcResponse(nil, nil); rsp != nil {
+		t.Errorf("[failed] NewRpcResponse(nil) = %+v", rsp)
+	}
+}
@@ -0,0 +1,64 @@
+package test
+
+import (
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// buildDemoCurl builds the democurl command into a temporary directory.
+func buildDemoCurl(t *testing.T) string {
+	bin := filepath.Join(t.TempDir(), "democurl")
+	out, err := exec.Command("go", "build", "-o", bin, "github.com/fdingiit/mpl/pkg/plugin/demo/democurl").CombinedOutput()
+	if !assert.Nil(t, err, "%s", out) {
+		t.FailNow()
+	}
+	return bin
+}
+
+func Test_DemoCurl(t *testing.T) {
+	bin := buildDemoCurl(t)
+	l := serveDemoEcho(t, "127.0.0.1:0")
+	defer l.Close()
+	addr := l.Addr().String()
+
+	out, err := exec.Command(bin, "-addr", addr, "-d", "Hello World").CombinedOutput()
+	if assert.Nil(t, err, "%s", out) {
+		assert.Contains(t, string(out), "#0 status: 0")
+		assert.Contains(t, string(out), "Hello World")
+	}
+
+	cmd := exec.Command(bin, "-addr", addr, "-f", "-", "-n", "10", "-c", "3", "-q")
+	cmd.Stdin = strings.NewReader("from stdin")
+	out, err = cmd.CombinedOutput()
+	if assert.Nil(t, err, "%s", out) {
+		assert.Contains(t, string(out), "10 requests, 10 responses (0 with error status), 0 failed")
+		assert.NotContains(t, string(out), "status:")
+	}
+
+	out, err = exec.Command(bin, "-addr", addr, "-H", "service: echo", "-H", "trace:1", "-d", "with headers").CombinedOutput()
+	if assert.Nil(t, err, "%s", out) {
+		assert.Contains(t, string(out), "with headers")
+	}
+
+	out, err = exec.Command(bin, "-addr", addr, "-type", "heartbeat", "-x").CombinedOutput()
+	assert.Nil(t, err, "%s", out)
+
+	// the echo server never answers silent requests
+	out, err = exec.Command(bin, "-addr", addr, "-type", "9", "-timeout", "100ms").CombinedOutput()
+	if assert.NotNil(t, err) {
+		assert.Contains(t, string(out), "1 of 1 requests failed")
+	}
+
+	for _, args := range [][]string{
+		{"-d", "x", "-f", "request.bin"},
+		{"-type", "nope"},
+		{"-H", "no separator"},
+	} {
+		err := exec.Command(bin, append([]string{"-addr", addr}, args...)...).Run()
+		assert.NotNil(t, err, "%v", args)
+	}
+}
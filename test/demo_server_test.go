@@ -0,0 +1,129 @@
+package test
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fdingiit/mpl/pkg/plugin/demo/client"
+	"github.com/fdingiit/mpl/pkg/plugin/demo/codec"
+	"github.com/fdingiit/mpl/pkg/plugin/demo/server"
+	"github.com/stretchr/testify/assert"
+	"mosn.io/pkg/buffer"
+)
+
+func startDemoServer(t *testing.T, srv *server.Server) (string, chan error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	served := make(chan error, 1)
+	go func() {
+		served <- srv.Serve(l)
+	}()
+	return l.Addr().String(), served
+}
+
+func Test_DemoServer_Dispatch(t *testing.T) {
+	srv := &server.Server{}
+	srv.HandleFunc(codec.TypeMessage, func(ctx context.Context, req *codec.Request) (*codec.Response, error) {
+		if req.Payload.String() == "fail" {
+			return nil, assert.AnError
+		}
+		return &codec.Response{
+			Request: codec.Request{
+				Payload: buffer.NewIoBufferString(strings.ToUpper(req.Payload.String())),
+			},
+		}, nil
+	})
+	addr, _ := startDemoServer(t, srv)
+	defer srv.Close()
+
+	cli, err := client.Dial(addr, client.Options{PoolSize: 2})
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	defer cli.Close()
+
+	tests := []struct {
+		name    string
+		typ     byte
+		payload string
+		status  uint16
+		want    string
+	}{
+		{name: "ok", typ: codec.TypeMessage, payload: "hello", status: codec.ResponseStatusSuccess, want: "HELLO"},
+		{name: "handler error", typ: codec.TypeMessage, payload: "fail", status: codec.ResponseStatusError, want: assert.AnError.Error()},
+		{name: "no handler", typ: 7, payload: "hello", status: codec.ResponseStatusError, want: "no handler for request type 7"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rsp, err := cli.Call(context.TODO(), tt.typ, []byte(tt.payload))
+			if !assert.Nil(t, err) {
+				return
+			}
+			assert.Equal(t, tt.typ, rsp.Type)
+			assert.Equal(t, tt.status, rsp.Status)
+			assert.Equal(t, tt.want, rsp.Payload.String())
+		})
+	}
+
+	assert.Nil(t, cli.Ping(context.TODO()))
+}
+
+func Test_DemoServer_Shutdown(t *testing.T) {
+	entered := make(chan struct{})
+	release := make(chan struct{})
+
+	srv := &server.Server{}
+	srv.HandleFunc(codec.TypeMessage, func(ctx context.Context, req *codec.Request) (*codec.Response, error) {
+		entered <- struct{}{}
+		<-release
+		return &codec.Response{
+			Request: codec.Request{Payload: req.Payload},
+		}, nil
+	})
+	addr, served := startDemoServer(t, srv)
+
+	cli, err := client.Dial(addr, client.Options{PoolSize: 1})
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	defer cli.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rsp, err := cli.Call(context.TODO(), codec.TypeMessage, []byte("in flight"))
+			if assert.Nil(t, err) {
+				assert.Equal(t, "in flight", rsp.Payload.String())
+			}
+		}()
+	}
+	for i := 0; i < 3; i++ {
+		<-entered
+	}
+
+	shutdown := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.TODO(), 3*time.Second)
+		defer cancel()
+		shutdown <- srv.Shutdown(ctx)
+	}()
+
+	assert.Equal(t, server.ErrServerClosed, <-served)
+
+	// new connections are refused while the in-flight calls drain
+	_, err = net.DialTimeout("tcp", addr, 100*time.Millisecond)
+	assert.NotNil(t, err)
+
+	close(release)
+	wg.Wait()
+	assert.Nil(t, <-shutdown)
+}
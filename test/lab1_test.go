@@ -15,6 +15,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/fdingiit/mpl/pkg/sdbs/ledger"
 	"github.com/fdingiit/mpl/pkg/sdbs/server/pkg"
 	"github.com/fdingiit/mpl/pkg/simple"
 	"github.com/stretchr/testify/assert"
@@ -230,17 +231,28 @@ func Test_Lab1_TaskC(t *testing.T) {
 	type args struct {
 		ctx context.Context
 	}
+	// the gateway answers failed transfers with a failure status, so the
+	// transfer is a valid one between accounts the ledger holds
+	outBank, inBank := newLedgerBank(), newLedgerBank()
+	for _, acc := range []ledger.Account{
+		{BankId: outBank, AccountId: 1, Balances: []ledger.Balance{{Currency: 2, Amount: 1000}}},
+		{BankId: inBank, AccountId: 2, Balances: []ledger.Balance{{Currency: 2, Amount: 0}}},
+	} {
+		if !assert.Nil(t, pkg.Ledger.AddAccount(acc)) {
+			return
+		}
+	}
 	reqs := []GWRequest{
 		{
 			Timestamp:    time.Now().Unix(),
 			SerialNo:     rand.Int63(),
-			Currency:     rand.Int31(),
-			Amount:       rand.Int63(),
-			Unit:         rand.Int31(),
-			OutBankId:    rand.Int63(),
-			OutAccountId: rand.Int63(),
-			InBankId:     rand.Int63(),
-			InAccountId:  rand.Int63(),
+			Currency:     2,
+			Amount:       1 + rand.Int63n(1000),
+			Unit:         0,
+			OutBankId:    int64(outBank),
+			OutAccountId: 1,
+			InBankId:     int64(inBank),
+			InAccountId:  2,
 			Notes:        "random ut",
 		},
 	}
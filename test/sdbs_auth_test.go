@@ -0,0 +1,112 @@
+package test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fdingiit/mpl/pkg/sdbs/client"
+	gateway "github.com/fdingiit/mpl/pkg/sdbs/gateway/pkg"
+	"github.com/fdingiit/mpl/pkg/sdbs/ledger"
+	"github.com/fdingiit/mpl/pkg/sdbs/server/pkg"
+	"github.com/fdingiit/mpl/pkg/simple"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_SDBSAuth_Server(t *testing.T) {
+	var mu sync.Mutex
+	var clientIds []string
+	reg := &pkg.Registry{}
+	reg.RegisterFunc(1000501, func(ctx context.Context, req simple.Message) (simple.Message, error) {
+		mu.Lock()
+		clientIds = append(clientIds, pkg.ClientIdFrom(ctx))
+		mu.Unlock()
+		return &simple.Response{SerialNo: req.(*simple.Request).SerialNo, Message: "ok"}, nil
+	})
+	l := startSDBSServer(t, &pkg.Server{Handler: reg, Auth: testVerifier()})
+
+	c := client.New(l.Addr().String(), client.Options{})
+	defer c.Close()
+	ctx, cancel := context.WithTimeout(simple.WithChecksum(context.TODO(), simple.MD5Checksum), 3*time.Second)
+	defer cancel()
+
+	for _, tc := range []struct {
+		name    string
+		signer  *simple.Signer
+		errCode int
+	}{
+		{"unsigned", nil, pkg.ErrCodeUnauthorized},
+		{"unknown client", &simple.Signer{ClientId: "intruder", Key: testSigner.Key}, pkg.ErrCodeUnauthorized},
+		{"signed", testSigner, pkg.ErrCodeNo},
+	} {
+		var rsp simple.Response
+		if assert.Nil(t, c.Call(simple.WithSigner(ctx, tc.signer), pagedRequest(7, tc.name), &rsp), tc.name) {
+			assert.Equal(t, tc.errCode, rsp.ErrCode, "%s: %s", tc.name, rsp.Message)
+			assert.Equal(t, 7, rsp.SerialNo, tc.name)
+			assert.Equal(t, 0, rsp.Reserved, tc.name)
+		}
+	}
+
+	// the pages of a request are verified once assembled
+	pages, err := simple.EncodePages(simple.WithSigner(ctx, testSigner), pagedRequest(8, strings.Repeat("paged ", 50)), 8, 100)
+	if !assert.Nil(t, err) {
+		return
+	}
+	data, err := c.RoundTrip(ctx, pages...)
+	if assert.Nil(t, err) {
+		var rsp simple.Response
+		assert.Nil(t, simple.Unmarshal(ctx, data, &rsp))
+		assert.Equal(t, pkg.ErrCodeNo, rsp.ErrCode, rsp.Message)
+	}
+
+	// a captured request cannot be replayed
+	data, err = c.RoundTrip(ctx, pages...)
+	if assert.Nil(t, err) {
+		var rsp simple.Response
+		assert.Nil(t, simple.Unmarshal(ctx, data, &rsp))
+		assert.Equal(t, pkg.ErrCodeUnauthorized, rsp.ErrCode)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{testSigner.ClientId, testSigner.ClientId}, clientIds)
+}
+
+func Test_SDBSAuth_Gateway(t *testing.T) {
+	bank := newLedgerBank()
+	if !assert.Nil(t, pkg.Ledger.AddAccount(ledger.Account{BankId: bank, AccountId: 1, Balances: []ledger.Balance{{Currency: 2, Amount: 10}}})) {
+		return
+	}
+	l := startSDBSServer(t, &pkg.Server{Auth: testVerifier()})
+
+	start := func(signer *simple.Signer) *httptest.Server {
+		c := client.New(l.Addr().String(), client.Options{})
+		gw := httptest.NewServer(&gateway.Gateway{Client: c, Timeout: 3 * time.Second, Signer: signer})
+		t.Cleanup(func() {
+			gw.Close()
+			c.Close()
+		})
+		return gw
+	}
+	balance := map[string]int{"serial_no": 1, "bank_id": bank, "account_id": 1}
+
+	status, rsp := postGateway(t, start(testSigner).URL+"/balance", balance, nil)
+	if assert.Equal(t, http.StatusOK, status, "%v", rsp) {
+		assert.Equal(t, []interface{}{map[string]interface{}{"currency": 2.0, "unit": 0.0, "amount": 10.0}}, rsp["balance"])
+	}
+
+	status, rsp = postGateway(t, start(nil).URL+"/balance", balance, nil)
+	if assert.Equal(t, http.StatusUnauthorized, status) {
+		assert.Equal(t, float64(pkg.ErrCodeUnauthorized), rsp["err_code"])
+		assert.Equal(t, "UNAUTHORIZED", rsp["error"])
+	}
+
+	// the checksum the gateway computes covers the auth block, not the one
+	// given by the client
+	status, rsp = postGateway(t, start(testSigner).URL+"/balance", balance, map[string]string{gateway.HeaderChecksum: testChecksum})
+	assert.Equal(t, http.StatusOK, status, "%v", rsp)
+}
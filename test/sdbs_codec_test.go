@@ -0,0 +1,255 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	sdbscodec "github.com/fdingiit/mpl/pkg/plugin/sdbs/codec"
+	"github.com/fdingiit/mpl/pkg/sdbs/server/pkg"
+	"github.com/fdingiit/mpl/pkg/simple"
+	"github.com/stretchr/testify/assert"
+	"mosn.io/api"
+	"mosn.io/pkg/buffer"
+)
+
+func sdbsCodecRequest(t *testing.T, notes string) []byte {
+	data, err := simple.Marshal(simple.WithChecksum(context.TODO(), simple.MD5Checksum), &simple.Request{
+		Header:        simple.Header{Type: simple.TypeRequest, ServiceCode: simple.ServiceTransfer},
+		UnixTimestamp: 1648811583,
+		SerialNo:      4242,
+		Amount:        100,
+		Notes:         notes,
+	})
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	return data
+}
+
+func Test_SDBSCodec_Matcher(t *testing.T) {
+	req := sdbsCodecRequest(t, "")
+	tests := []struct {
+		name string
+		data []byte
+		want api.MatchResult
+	}{
+		{name: "request", data: req, want: api.MatchSuccess},
+		{name: "response type", data: []byte("00000052RS"), want: api.MatchSuccess},
+		{name: "empty", data: nil, want: api.MatchAgain},
+		{name: "length only", data: req[:8], want: api.MatchAgain},
+		{name: "half type", data: req[:9], want: api.MatchAgain},
+		{name: "letter in length", data: []byte("0000a052RQ"), want: api.MatchFailed},
+		{name: "http", data: []byte("POST /transfer HTTP/1.1"), want: api.MatchFailed},
+		{name: "unknown type", data: []byte("00000052RX"), want: api.MatchFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, (&sdbscodec.Matcher{}).SDBSMatcher(tt.data))
+		})
+	}
+}
+
+func Test_SDBSCodec_Decode(t *testing.T) {
+	req := sdbsCodecRequest(t, "备注")
+	rsp, err := simple.Marshal(simple.WithChecksum(context.TODO(), simple.MD5Checksum), &simple.Response{
+		Header:   simple.Header{Type: simple.TypeResponse, ServiceCode: simple.ServiceTransfer},
+		SerialNo: 4242,
+		ErrCode:  pkg.ErrCodeInsufficientFunds,
+		Message:  "insufficient funds",
+	})
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	proto := &sdbscodec.Proto{}
+	buf := buffer.NewIoBufferBytes(append([]byte(nil), req[:30]...))
+
+	// a partial header waits for more data
+	frame, err := proto.Decode(context.TODO(), buf)
+	assert.Nil(t, err)
+	assert.Nil(t, frame)
+	assert.Equal(t, 30, buf.Len())
+
+	buf.Write(req[30:])
+	buf.Write(rsp)
+	frame, err = proto.Decode(context.TODO(), buf)
+	if assert.Nil(t, err) && assert.IsType(t, &sdbscodec.Request{}, frame) {
+		r := frame.(*sdbscodec.Request)
+		assert.Equal(t, simple.ServiceTransfer, r.ServiceCode)
+		assert.Equal(t, 4242, r.SerialNo)
+		assert.Equal(t, api.Request, r.GetStreamType())
+		assert.Equal(t, "1000501", r.GetServiceName())
+		v, _ := r.Get(sdbscodec.HeaderSerialNo)
+		assert.Equal(t, "4242", v)
+		assert.Equal(t, req[simple.HeaderLen:], r.GetData().Bytes())
+
+		// unchanged frames are written back as they were received
+		out, err := proto.Encode(context.TODO(), r)
+		if assert.Nil(t, err) {
+			assert.Equal(t, req, out.Bytes())
+		}
+	}
+
+	frame, err = proto.Decode(context.TODO(), buf)
+	if assert.Nil(t, err) && assert.IsType(t, &sdbscodec.Response{}, frame) {
+		r := frame.(*sdbscodec.Response)
+		assert.Equal(t, uint32(pkg.ErrCodeInsufficientFunds), r.GetStatusCode())
+		assert.Equal(t, api.Response, r.GetStreamType())
+
+		code, err := (&sdbscodec.StatusMapping{}).MappingHeaderStatusCode(context.TODO(), r)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusUnprocessableEntity, code)
+
+		out, err := proto.Encode(context.TODO(), r)
+		if assert.Nil(t, err) {
+			assert.Equal(t, rsp, out.Bytes())
+		}
+	}
+	assert.Equal(t, 0, buf.Len())
+}
+
+func Test_SDBSCodec_DecodeErrors(t *testing.T) {
+	req := sdbsCodecRequest(t, "")
+
+	_, err := (&sdbscodec.Proto{}).Decode(context.TODO(), nil)
+	assert.NotNil(t, err)
+
+	_, err = (&sdbscodec.Proto{}).Decode(context.TODO(), buffer.NewIoBufferString("GET / HTTP/1.1\r\n\r\n"))
+	assert.NotNil(t, err)
+
+	bad := append([]byte(nil), req...)
+	bad[10] = 'x'
+	_, err = (&sdbscodec.Proto{}).Decode(context.TODO(), buffer.NewIoBufferBytes(bad))
+	assert.NotNil(t, err)
+
+	huge := append([]byte("99999999"), req[8:]...)
+	_, err = (&sdbscodec.Proto{}).Decode(context.TODO(), buffer.NewIoBufferBytes(huge))
+	assert.NotNil(t, err)
+}
+
+func Test_SDBSCodec_DecodePages(t *testing.T) {
+	notes := strings.Repeat("n", 3*simple.DefaultPageSize)
+	pages, err := simple.EncodePages(simple.WithChecksum(context.TODO(), simple.MD5Checksum), &simple.Request{
+		Header:   simple.Header{Type: simple.TypeRequest, ServiceCode: simple.ServiceTransfer},
+		SerialNo: 4242,
+		Notes:    notes,
+	}, 4242, 0)
+	if !assert.Nil(t, err) || !assert.True(t, len(pages) > 1) {
+		t.FailNow()
+	}
+
+	proto := &sdbscodec.Proto{}
+	buf := buffer.NewIoBufferBytes(nil)
+	for i, page := range pages {
+		buf.Write(page)
+		frame, err := proto.Decode(context.TODO(), buf)
+		assert.Nil(t, err)
+		assert.Equal(t, 0, buf.Len())
+		if i < len(pages)-1 {
+			assert.Nil(t, frame)
+			continue
+		}
+
+		// the whole message comes out as a single page
+		if assert.IsType(t, &sdbscodec.Request{}, frame) {
+			r := frame.(*sdbscodec.Request)
+			assert.Equal(t, simple.PageSingle, r.PageMark)
+			out, err := proto.Encode(context.TODO(), r)
+			if !assert.Nil(t, err) {
+				return
+			}
+			var got simple.Request
+			assert.Nil(t, got.Decode(simple.WithChecksum(context.TODO(), simple.MD5Checksum), out.Bytes()))
+			assert.Equal(t, notes, got.Notes)
+			assert.Equal(t, 4242, got.SerialNo)
+		}
+	}
+}
+
+func Test_SDBSCodec_SetData(t *testing.T) {
+	proto := &sdbscodec.Proto{}
+	frame, err := proto.Decode(context.TODO(), buffer.NewIoBufferBytes(sdbsCodecRequest(t, "")))
+	if !assert.Nil(t, err) || !assert.NotNil(t, frame) {
+		t.FailNow()
+	}
+	r := frame.(*sdbscodec.Request)
+
+	body := bytes.Replace(r.GetData().Bytes(), []byte("<amount>100</amount>"), []byte("<amount>2500</amount>"), 1)
+	r.SetData(buffer.NewIoBufferBytes(body))
+
+	// the length and checksum follow the new body
+	out, err := proto.Encode(context.TODO(), r)
+	if !assert.Nil(t, err) {
+		return
+	}
+	var got simple.Request
+	assert.Nil(t, got.Decode(simple.WithChecksum(context.TODO(), simple.MD5Checksum), out.Bytes()))
+	assert.Equal(t, 2500, got.Amount)
+}
+
+func Test_SDBSCodec_Hijack(t *testing.T) {
+	proto := &sdbscodec.Proto{}
+	frame, err := proto.Decode(context.TODO(), buffer.NewIoBufferBytes(sdbsCodecRequest(t, "")))
+	if !assert.Nil(t, err) || !assert.NotNil(t, frame) {
+		t.FailNow()
+	}
+	req := frame.(*sdbscodec.Request)
+	req.SetRequestId(proto.GenerateRequestID(new(uint64)))
+
+	tests := []struct {
+		status uint32
+		want   int
+	}{
+		{status: api.RouterUnavailableCode, want: pkg.ErrCodeUnknownService},
+		{status: api.CodecExceptionCode, want: pkg.ErrCodeIncorrectRequest},
+		{status: api.NoHealthUpstreamCode, want: pkg.ErrCodeInternal},
+		{status: api.TimeoutExceptionCode, want: pkg.ErrCodeTimeout},
+	}
+	for _, tt := range tests {
+		rsp := proto.Hijack(context.TODO(), req, tt.status)
+		if !assert.NotNil(t, rsp) {
+			continue
+		}
+		assert.Equal(t, req.GetRequestId(), rsp.GetRequestId())
+		assert.Equal(t, uint32(tt.want), rsp.GetStatusCode())
+
+		out, err := proto.Encode(context.TODO(), rsp)
+		if !assert.Nil(t, err) {
+			continue
+		}
+		var got simple.Response
+		assert.Nil(t, got.Decode(simple.WithChecksum(context.TODO(), simple.MD5Checksum), out.Bytes()))
+		assert.Equal(t, tt.want, got.ErrCode)
+		assert.Equal(t, 4242, got.SerialNo)
+		assert.Equal(t, simple.ServiceTransfer, got.ServiceCode)
+	}
+
+	assert.Equal(t, api.PingPong, proto.PoolMode())
+	assert.Nil(t, proto.Trigger(context.TODO(), 1))
+}
+
+func FuzzSDBSProtoDecode(f *testing.F) {
+	f.Add([]byte("00000052RQ0" + strings.Repeat(" ", 32) + "010005010"))
+	f.Add([]byte("00000070RS0" + strings.Repeat(" ", 32) + "010005010<err_code>5</err_code>"))
+	f.Add([]byte("0000"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		data = append([]byte(nil), data...)
+		buf := buffer.NewIoBufferBytes(data)
+
+		frame, err := (&sdbscodec.Proto{}).Decode(context.TODO(), buf)
+		if err != nil || frame == nil {
+			return
+		}
+		out, err := (&sdbscodec.Proto{}).Encode(context.TODO(), frame)
+		if err != nil {
+			t.Fatalf("Encode() error = %v", err)
+		}
+		consumed := len(data) - buf.Len()
+		if !bytes.Equal(out.Bytes(), data[:consumed]) {
+			t.Fatalf("round trip mismatch:\n got %q\nwant %q", out.Bytes(), data[:consumed])
+		}
+	})
+}
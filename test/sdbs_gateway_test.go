@@ -0,0 +1,311 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fdingiit/mpl/pkg/sdbs/client"
+	gateway "github.com/fdingiit/mpl/pkg/sdbs/gateway/pkg"
+	"github.com/fdingiit/mpl/pkg/sdbs/ledger"
+	"github.com/fdingiit/mpl/pkg/sdbs/server/pkg"
+	"github.com/fdingiit/mpl/pkg/simple"
+	"github.com/fdingiit/mpl/pkg/simple/charset"
+	"github.com/stretchr/testify/assert"
+)
+
+// startGateway serves a gateway to the SDBS server at sdbsAddr.
+func startGateway(t *testing.T, sdbsAddr string) *httptest.Server {
+	c := client.New(sdbsAddr, client.Options{})
+	gw := httptest.NewServer(&gateway.Gateway{Client: c, PageSize: 64, Timeout: 3 * time.Second})
+	t.Cleanup(func() {
+		gw.Close()
+		c.Close()
+	})
+	return gw
+}
+
+func postGateway(t *testing.T, url string, body interface{}, header map[string]string) (int, map[string]interface{}) {
+	data, _ := json.Marshal(body)
+	req, _ := http.NewRequest(http.MethodPost, url, bytes.NewReader(data))
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	rsp, err := http.DefaultClient.Do(req)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	defer rsp.Body.Close()
+
+	assert.Equal(t, "application/json", rsp.Header.Get("Content-Type"))
+	var got map[string]interface{}
+	assert.Nil(t, json.NewDecoder(rsp.Body).Decode(&got))
+	return rsp.StatusCode, got
+}
+
+func Test_SDBSGateway(t *testing.T) {
+	outBank, inBank := newLedgerBank(), newLedgerBank()
+	for _, acc := range []ledger.Account{
+		{BankId: outBank, AccountId: 1, Balances: []ledger.Balance{{Currency: 2, Amount: 100}}},
+		{BankId: inBank, AccountId: 2, Balances: []ledger.Balance{{Currency: 2, Amount: 0}}},
+	} {
+		if !assert.Nil(t, pkg.Ledger.AddAccount(acc)) {
+			return
+		}
+	}
+
+	l := startSDBSServer(t, &pkg.Server{})
+	gw := startGateway(t, l.Addr().String())
+
+	transfer := GWRequest{
+		Timestamp:    time.Now().Unix(),
+		SerialNo:     1,
+		Currency:     2,
+		Amount:       30,
+		OutBankId:    int64(outBank),
+		OutAccountId: 1,
+		InBankId:     int64(inBank),
+		InAccountId:  2,
+		Notes:        strings.Repeat("long notes ", 20),
+	}
+
+	// the notes do not fit in a page of the gateway
+	status, rsp := postGateway(t, gw.URL+"/transfer", transfer, map[string]string{gateway.HeaderPagingMask: "1"})
+	if assert.Equal(t, http.StatusOK, status, "%v", rsp) {
+		var got GWResponse
+		data, _ := json.Marshal(rsp)
+		assert.Nil(t, json.Unmarshal(data, &got))
+		assert.Equal(t, int64(1), got.SerialNo)
+		assert.Equal(t, int32(pkg.ErrCodeNo), got.ErrCode)
+		assert.Equal(t, "ok", got.Message)
+	}
+
+	status, rsp = postGateway(t, gw.URL+"/balance", map[string]int{"serial_no": 6, "bank_id": outBank, "account_id": 1}, nil)
+	if assert.Equal(t, http.StatusOK, status) {
+		assert.Equal(t, []interface{}{map[string]interface{}{"currency": 2.0, "unit": 0.0, "amount": 70.0}}, rsp["balance"])
+	}
+
+	status, rsp = postGateway(t, gw.URL+"/query", map[string]int{"serial_no": 2, "out_bank_id": outBank, "orig_serial_no": 1}, nil)
+	if assert.Equal(t, http.StatusOK, status) {
+		assert.Equal(t, simple.TransferBooked, rsp["status"])
+	}
+	status, rsp = postGateway(t, gw.URL+"/reversal", map[string]int{"serial_no": 3, "out_bank_id": outBank, "orig_serial_no": 1}, nil)
+	if assert.Equal(t, http.StatusOK, status) {
+		assert.Equal(t, float64(pkg.ErrCodeNo), rsp["err_code"])
+	}
+	status, rsp = postGateway(t, gw.URL+"/statement", map[string]int{"serial_no": 4, "bank_id": inBank, "account_id": 2}, nil)
+	if assert.Equal(t, http.StatusOK, status) {
+		assert.Len(t, rsp["entry"], 2)
+	}
+
+	// the gateway computes the checksum of what it sends, whatever the
+	// client gives
+	for i, header := range []map[string]string{
+		{gateway.HeaderPagingMask: "0", gateway.HeaderChecksum: testChecksum},
+		{gateway.HeaderPagingMask: "1", gateway.HeaderChecksum: testChecksum},
+		{gateway.HeaderChecksum: "abc"},
+	} {
+		transfer.SerialNo = int64(5 + i)
+		status, rsp = postGateway(t, gw.URL+"/transfer", transfer, header)
+		if assert.Equal(t, http.StatusOK, status, "%v", rsp) {
+			assert.Equal(t, float64(pkg.ErrCodeNo), rsp["err_code"])
+			assert.Equal(t, float64(transfer.SerialNo), rsp["serial_no"])
+		}
+	}
+
+	for _, tt := range []struct {
+		name   string
+		path   string
+		body   interface{}
+		header map[string]string
+		want   int
+	}{
+		{name: "unknown path", path: "/deposit", body: transfer, want: http.StatusNotFound},
+		{name: "unknown field", path: "/transfer", body: map[string]int{"serial": 1}, want: http.StatusBadRequest},
+		{name: "not json", path: "/transfer", body: "serial_no=1", want: http.StatusBadRequest},
+		{name: "bad paging mask", path: "/transfer", body: transfer, header: map[string]string{gateway.HeaderPagingMask: "3"}, want: http.StatusBadRequest},
+		{name: "body too large", path: "/transfer", body: map[string]string{"notes": strings.Repeat("x", gateway.DefaultMaxBodySize)}, want: http.StatusRequestEntityTooLarge},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			status, rsp := postGateway(t, gw.URL+tt.path, tt.body, tt.header)
+			assert.Equal(t, tt.want, status)
+			assert.NotEmpty(t, rsp["message"])
+			assert.NotEmpty(t, rsp["category"])
+			assert.Equal(t, false, rsp["retryable"])
+		})
+	}
+
+	rsp2, err := http.Get(gw.URL + "/transfer")
+	if assert.Nil(t, err) {
+		rsp2.Body.Close()
+		assert.Equal(t, http.StatusMethodNotAllowed, rsp2.StatusCode)
+		assert.Equal(t, http.MethodPost, rsp2.Header.Get("Allow"))
+	}
+}
+
+func Test_SDBSGateway_ServerDown(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.Nil(t, err) {
+		return
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	gw := startGateway(t, addr)
+	status, rsp := postGateway(t, gw.URL+"/balance", map[string]int{"serial_no": 1, "bank_id": 1, "account_id": 1}, nil)
+	assert.Equal(t, http.StatusBadGateway, status)
+	assert.Contains(t, rsp["message"], "sdbs server")
+	assert.Equal(t, float64(simple.ErrCodeInternal), rsp["err_code"])
+	assert.Equal(t, true, rsp["retryable"])
+}
+
+func Test_SDBSGateway_Statuses(t *testing.T) {
+	outBank, inBank := newLedgerBank(), newLedgerBank()
+	for _, acc := range []ledger.Account{
+		{BankId: outBank, AccountId: 1, Balances: []ledger.Balance{{Currency: 2, Amount: 10}}},
+		{BankId: inBank, AccountId: 2, Balances: []ledger.Balance{{Currency: 2, Amount: 0}}},
+	} {
+		if !assert.Nil(t, pkg.Ledger.AddAccount(acc)) {
+			return
+		}
+	}
+
+	l := startSDBSServer(t, &pkg.Server{})
+	c := client.New(l.Addr().String(), client.Options{})
+	defer c.Close()
+	statuses, err := simple.ParseStatusMapping("funds=402")
+	if !assert.Nil(t, err) {
+		return
+	}
+	gw := httptest.NewServer(&gateway.Gateway{Client: c, Statuses: statuses})
+	defer gw.Close()
+
+	transfer := GWRequest{
+		Timestamp:    time.Now().Unix(),
+		SerialNo:     1,
+		Currency:     2,
+		Amount:       30,
+		OutBankId:    int64(outBank),
+		OutAccountId: 1,
+		InBankId:     int64(inBank),
+		InAccountId:  2,
+	}
+	status, rsp := postGateway(t, gw.URL+"/transfer", transfer, nil)
+	assert.Equal(t, http.StatusPaymentRequired, status)
+	assert.Equal(t, float64(pkg.ErrCodeInsufficientFunds), rsp["err_code"])
+	assert.Equal(t, "INSUFFICIENT_FUNDS", rsp["error"])
+	assert.Equal(t, "funds", rsp["category"])
+	assert.Equal(t, false, rsp["retryable"])
+	assert.NotEmpty(t, rsp["message"])
+
+	// codes not configured keep their default status
+	status, rsp = postGateway(t, gw.URL+"/query", map[string]int{"serial_no": 2, "out_bank_id": outBank, "orig_serial_no": 99}, nil)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, "UNKNOWN_TRANSFER", rsp["error"])
+}
+
+func Test_SDBSGateway_Validation(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.Nil(t, err) {
+		return
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	// requests passing validation reach the server, down here
+	c := client.New(addr, client.Options{})
+	defer c.Close()
+	gw := httptest.NewServer(&gateway.Gateway{Client: c, Charset: charset.GBK})
+	defer gw.Close()
+
+	valid := func() map[string]interface{} {
+		return map[string]interface{}{
+			"timestamp":      time.Now().Unix(),
+			"serial_no":      1,
+			"currency":       2,
+			"amount":         30,
+			"unit":           0,
+			"out_bank_id":    1,
+			"out_account_id": 1,
+			"in_bank_id":     2,
+			"in_account_id":  2,
+		}
+	}
+
+	tests := []struct {
+		name   string
+		modify func(req map[string]interface{})
+		want   map[string]string
+	}{
+		{name: "valid", modify: func(req map[string]interface{}) {}},
+		{name: "notes fitting in gbk", modify: func(req map[string]interface{}) {
+			req["notes"] = strings.Repeat("汇", gateway.DefaultMaxNotesBytes/2)
+		}},
+		{name: "missing fields", modify: func(req map[string]interface{}) {
+			delete(req, "timestamp")
+			delete(req, "amount")
+			req["in_bank_id"] = nil
+		}, want: map[string]string{"timestamp": "is required", "amount": "is required", "in_bank_id": "is required"}},
+		{name: "ranges", modify: func(req map[string]interface{}) {
+			req["amount"], req["out_account_id"], req["serial_no"] = -5, 0, 0
+		}, want: map[string]string{"amount": "must be at least 1", "out_account_id": "must be at least 1", "serial_no": "must be at least 1"}},
+		{name: "enums", modify: func(req map[string]interface{}) {
+			req["currency"], req["unit"] = 1043570, 7
+		}, want: map[string]string{"currency": "must be one of [1 2 3]", "unit": "must be one of [0 1 2]"}},
+		{name: "types", modify: func(req map[string]interface{}) {
+			req["amount"], req["notes"], req["in_account_id"] = "30", 42, 1.5
+		}, want: map[string]string{"amount": "must be an integer", "notes": "must be a string", "in_account_id": "must be an integer"}},
+		{name: "overflow", modify: func(req map[string]interface{}) {
+			req["amount"] = json.Number("99999999999999999999")
+		}, want: map[string]string{"amount": "is out of range"}},
+		{name: "notes too long in gbk", modify: func(req map[string]interface{}) {
+			req["notes"] = strings.Repeat("汇", gateway.DefaultMaxNotesBytes/2+1)
+		}, want: map[string]string{"notes": "is 514 bytes in GBK, longer than 512"}},
+		{name: "notes not in gbk", modify: func(req map[string]interface{}) {
+			req["notes"] = "😀"
+		}, want: map[string]string{"notes": "is not representable in GBK"}},
+		{name: "stale timestamp", modify: func(req map[string]interface{}) {
+			req["timestamp"] = time.Now().Add(-time.Hour).Unix()
+		}, want: map[string]string{"timestamp": "away from the gateway clock, more than 5m0s"}},
+		{name: "future timestamp", modify: func(req map[string]interface{}) {
+			req["timestamp"] = time.Now().Add(10 * time.Minute).Unix()
+		}, want: map[string]string{"timestamp": "away from the gateway clock, more than 5m0s"}},
+		{name: "unknown field", modify: func(req map[string]interface{}) {
+			req["memo"] = "x"
+		}, want: map[string]string{"memo": "is not a field of the request"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := valid()
+			tt.modify(req)
+			status, rsp := postGateway(t, gw.URL+"/transfer", req, nil)
+			if tt.want == nil {
+				assert.Equal(t, http.StatusBadGateway, status, "%v", rsp)
+				return
+			}
+
+			assert.Equal(t, http.StatusBadRequest, status)
+			assert.Equal(t, float64(simple.ErrCodeIncorrectRequest), rsp["err_code"])
+			assert.Equal(t, "validation", rsp["category"])
+			errs, _ := rsp["errors"].([]interface{})
+			assert.Len(t, errs, len(tt.want))
+			for _, e := range errs {
+				e := e.(map[string]interface{})
+				assert.Contains(t, e["reason"], tt.want[e["field"].(string)], "field %s", e["field"])
+			}
+		})
+	}
+
+	// the skew is configurable, negative accepting any timestamp
+	lenient := httptest.NewServer(&gateway.Gateway{Client: c, MaxSkew: -1})
+	defer lenient.Close()
+	req := valid()
+	req["timestamp"] = 0
+	status, rsp := postGateway(t, lenient.URL+"/transfer", req, nil)
+	assert.Equal(t, http.StatusBadGateway, status, "%v", rsp)
+}
@@ -0,0 +1,158 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fdingiit/mpl/pkg/sdbs/client"
+	"github.com/fdingiit/mpl/pkg/sdbs/server/pkg"
+	"github.com/fdingiit/mpl/pkg/simple"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_SDBSHandler_Default(t *testing.T) {
+	l := startSDBSServer(t, &pkg.Server{})
+	c := client.New(l.Addr().String(), client.Options{})
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(simple.WithChecksum(context.TODO(), simple.MD5Checksum), 3*time.Second)
+	defer cancel()
+
+	var rsp simple.Response
+	req := pagedRequest(1, "default")
+	req.OutBankId = newLedgerBank()
+	if assert.Nil(t, c.Call(ctx, req, &rsp)) {
+		// booked on the ledger, which does not know these accounts
+		assert.Equal(t, pkg.ErrCodeUnknownAccount, rsp.ErrCode)
+		assert.Equal(t, simple.ServiceTransfer, rsp.ServiceCode)
+		assert.Equal(t, simple.TypeResponse, rsp.Type)
+	}
+
+	req = pagedRequest(2, "unknown")
+	req.ServiceCode = 100501
+	if assert.Nil(t, c.Call(ctx, req, &rsp)) {
+		assert.Equal(t, pkg.ErrCodeUnknownService, rsp.ErrCode)
+		assert.Equal(t, 2, rsp.SerialNo)
+		assert.Equal(t, 100501, rsp.ServiceCode)
+	}
+}
+
+func Test_SDBSHandler_Registry(t *testing.T) {
+	var mu sync.Mutex
+	var calls []string
+	trace := func(name string) pkg.Middleware {
+		return func(next pkg.Handler) pkg.Handler {
+			return pkg.HandlerFunc(func(ctx context.Context, req simple.Message) (simple.Message, error) {
+				mu.Lock()
+				calls = append(calls, name)
+				mu.Unlock()
+				return next.ServeSDBS(ctx, req)
+			})
+		}
+	}
+
+	reg := &pkg.Registry{}
+	reg.Use(trace("outer"), trace("inner"), pkg.Recover)
+	reg.RegisterFunc(2000001, func(ctx context.Context, msg simple.Message) (simple.Message, error) {
+		q := msg.(*balanceQuery)
+		switch q.AccountId {
+		case 1:
+			return nil, errors.New("ledger unavailable")
+		case 2:
+			panic("boom")
+		case 3:
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return &balanceResult{SerialNo: q.SerialNo, Balances: []int{q.AccountId}}, nil
+	})
+
+	l := startSDBSServer(t, &pkg.Server{Handler: reg, HandlerTimeout: 500 * time.Millisecond})
+	c := client.New(l.Addr().String(), client.Options{})
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(simple.WithChecksum(context.TODO(), simple.MD5Checksum), 3*time.Second)
+	defer cancel()
+
+	query := func(accountId int) *balanceQuery {
+		return &balanceQuery{
+			Header:    simple.Header{Type: simple.TypeRequest, ServiceCode: 2000001},
+			SerialNo:  accountId + 100,
+			AccountId: accountId,
+		}
+	}
+
+	var result balanceResult
+	if assert.Nil(t, c.Call(ctx, query(42), &result)) {
+		assert.Equal(t, 142, result.SerialNo)
+		assert.Equal(t, []int{42}, result.Balances)
+		assert.Equal(t, 2000001, result.ServiceCode)
+		assert.Equal(t, simple.TypeResponse, result.Type)
+	}
+	mu.Lock()
+	assert.Equal(t, []string{"outer", "inner"}, calls)
+	mu.Unlock()
+
+	var rsp simple.Response
+	for _, accountId := range []int{1, 2} {
+		if assert.Nil(t, c.Call(ctx, query(accountId), &rsp)) {
+			assert.Equal(t, pkg.ErrCodeInternal, rsp.ErrCode, rsp.Message)
+			assert.Equal(t, accountId+100, rsp.SerialNo)
+		}
+	}
+
+	// a handler past its deadline times out
+	if assert.Nil(t, c.Call(ctx, query(3), &rsp)) {
+		assert.Equal(t, pkg.ErrCodeTimeout, rsp.ErrCode, rsp.Message)
+	}
+
+	// known to the codec, but without a handler here
+	if assert.Nil(t, c.Call(ctx, pagedRequest(3, "no transfer"), &rsp)) {
+		assert.Equal(t, pkg.ErrCodeUnknownService, rsp.ErrCode)
+	}
+
+	// a response is not a request
+	if assert.Nil(t, c.Call(ctx, &balanceResult{Header: simple.Header{Type: simple.TypeResponse, ServiceCode: 2000001}, SerialNo: 4}, &rsp)) {
+		assert.Equal(t, pkg.ErrCodeIncorrectRequest, rsp.ErrCode)
+		assert.Equal(t, 4, rsp.SerialNo)
+	}
+}
+
+func Test_SDBSHandler_TimeoutAfterIdle(t *testing.T) {
+	reg := &pkg.Registry{}
+	reg.RegisterFunc(2000001, func(ctx context.Context, msg simple.Message) (simple.Message, error) {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(50 * time.Millisecond):
+		}
+		q := msg.(*balanceQuery)
+		return &balanceResult{SerialNo: q.SerialNo}, nil
+	})
+
+	l := startSDBSServer(t, &pkg.Server{Handler: reg, HandlerTimeout: 200 * time.Millisecond})
+	c := client.New(l.Addr().String(), client.Options{})
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(simple.WithChecksum(context.TODO(), simple.MD5Checksum), 3*time.Second)
+	defer cancel()
+
+	// the deadline of a request runs from its arrival, not from the end of
+	// the previous request on the connection
+	for serialNo := 1; serialNo <= 2; serialNo++ {
+		if serialNo > 1 {
+			time.Sleep(300 * time.Millisecond)
+		}
+		var rsp simple.Response
+		q := &balanceQuery{Header: simple.Header{Type: simple.TypeRequest, ServiceCode: 2000001}, SerialNo: serialNo}
+		if assert.Nil(t, c.Call(ctx, q, &rsp)) {
+			assert.Equal(t, pkg.ErrCodeNo, rsp.ErrCode, rsp.Message)
+			assert.Equal(t, serialNo, rsp.SerialNo)
+		}
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&l.accepted))
+}
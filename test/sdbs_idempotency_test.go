@@ -0,0 +1,183 @@
+package test
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fdingiit/mpl/pkg/sdbs/client"
+	"github.com/fdingiit/mpl/pkg/sdbs/ledger"
+	"github.com/fdingiit/mpl/pkg/sdbs/server/pkg"
+	"github.com/fdingiit/mpl/pkg/simple"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_SDBSReplayCache(t *testing.T) {
+	cache := &pkg.ReplayCache{Window: 50 * time.Millisecond}
+
+	var calls int32
+	release := make(chan struct{})
+	h := cache.Middleware(pkg.HandlerFunc(func(ctx context.Context, msg simple.Message) (simple.Message, error) {
+		req := msg.(*simple.Request)
+		if atomic.AddInt32(&calls, 1) == 1 {
+			<-release
+		}
+		if req.Amount < 0 {
+			return nil, assert.AnError
+		}
+		return &simple.Response{SerialNo: req.SerialNo, Message: req.Notes}, nil
+	}))
+
+	serve := func(outBank, serialNo, amount int, notes string) (*simple.Response, error) {
+		req := pagedRequest(serialNo, notes)
+		req.OutBankId, req.Amount = outBank, amount
+		req.UnixTimestamp = int(time.Now().UnixNano())
+		rsp, err := h.ServeSDBS(context.TODO(), req)
+		if err != nil {
+			return nil, err
+		}
+		return rsp.(*simple.Response), nil
+	}
+
+	// a replay arriving while the original is served waits for its response
+	var wg sync.WaitGroup
+	rsps := make([]*simple.Response, 2)
+	for i := range rsps {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			rsps[i], _ = serve(1, 1, 10, "first")
+		}(i)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	for _, rsp := range rsps {
+		if assert.NotNil(t, rsp) {
+			assert.Equal(t, "first", rsp.Message)
+		}
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	assert.NotSame(t, rsps[0], rsps[1])
+
+	rsp, err := serve(1, 1, 10, "first")
+	if assert.Nil(t, err) {
+		assert.Equal(t, pkg.ErrCodeNo, rsp.ErrCode)
+		assert.Equal(t, "first", rsp.Message)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	rsp, err = serve(1, 1, 20, "first")
+	if assert.Nil(t, err) {
+		assert.Equal(t, pkg.ErrCodeSerialNoReused, rsp.ErrCode)
+	}
+
+	// serial numbers are per out bank
+	rsp, err = serve(2, 1, 20, "second")
+	if assert.Nil(t, err) {
+		assert.Equal(t, "second", rsp.Message)
+	}
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+	// failures are not remembered
+	_, err = serve(1, 2, -1, "failed")
+	assert.NotNil(t, err)
+	rsp, err = serve(1, 2, 5, "retried")
+	if assert.Nil(t, err) {
+		assert.Equal(t, "retried", rsp.Message)
+	}
+	assert.Equal(t, int32(4), atomic.LoadInt32(&calls))
+
+	// the cache forgets the serial number once the window passed, the ledger
+	// refuses it from then on
+	time.Sleep(60 * time.Millisecond)
+	rsp, err = serve(1, 1, 20, "reused")
+	if assert.Nil(t, err) {
+		assert.Equal(t, pkg.ErrCodeNo, rsp.ErrCode)
+		assert.Equal(t, "reused", rsp.Message)
+	}
+	assert.Equal(t, int32(5), atomic.LoadInt32(&calls))
+}
+
+func Test_SDBSReplayCache_MaxEntries(t *testing.T) {
+	cache := &pkg.ReplayCache{MaxEntries: 2}
+
+	var calls int32
+	h := cache.Middleware(pkg.HandlerFunc(func(ctx context.Context, msg simple.Message) (simple.Message, error) {
+		atomic.AddInt32(&calls, 1)
+		return &simple.Response{SerialNo: msg.(*simple.Request).SerialNo}, nil
+	}))
+	serve := func(serialNo int) {
+		_, err := h.ServeSDBS(context.TODO(), pagedRequest(serialNo, "max entries"))
+		assert.Nil(t, err)
+	}
+
+	serve(1)
+	serve(2)
+	serve(1)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+	// the oldest serial number makes room for the third
+	serve(3)
+	serve(2)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+	serve(1)
+	assert.Equal(t, int32(4), atomic.LoadInt32(&calls))
+}
+
+func Test_SDBSIdempotency_Transfer(t *testing.T) {
+	outBank, inBank := newLedgerBank(), newLedgerBank()
+	for _, acc := range []ledger.Account{
+		{BankId: outBank, AccountId: 1, Balances: []ledger.Balance{{Currency: 2, Amount: 100}}},
+		{BankId: inBank, AccountId: 2, Balances: []ledger.Balance{{Currency: 2, Amount: 0}}},
+	} {
+		if !assert.Nil(t, pkg.Ledger.AddAccount(acc)) {
+			return
+		}
+	}
+
+	l := startSDBSServer(t, &pkg.Server{})
+	c := client.New(l.Addr().String(), client.Options{})
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(simple.WithChecksum(context.TODO(), simple.MD5Checksum), 3*time.Second)
+	defer cancel()
+
+	transfer := func(serialNo, amount int) *simple.Response {
+		req := pagedRequest(serialNo, "idempotency")
+		req.OutBankId, req.OutAccountId, req.InBankId, req.InAccountId = outBank, 1, inBank, 2
+		req.Amount = amount
+
+		var rsp simple.Response
+		if !assert.Nil(t, c.Call(ctx, req, &rsp)) {
+			t.FailNow()
+		}
+		return &rsp
+	}
+
+	first := transfer(1, 30)
+	assert.Equal(t, pkg.ErrCodeNo, first.ErrCode)
+	replay := transfer(1, 30)
+	assert.Equal(t, first.UnixTimestamp, replay.UnixTimestamp)
+	assert.Equal(t, first.Message, replay.Message)
+	assert.Equal(t, pkg.ErrCodeNo, replay.ErrCode)
+
+	rsp := transfer(1, 40)
+	assert.Equal(t, pkg.ErrCodeSerialNoReused, rsp.ErrCode)
+	assert.Equal(t, 1, rsp.SerialNo)
+
+	// a retry the cache forgot, as after a restart, is checked against the
+	// ledger and not booked again
+	booked := ledger.Transfer{SerialNo: 2, Currency: 2, Amount: 20, OutBankId: outBank, OutAccountId: 1, InBankId: inBank, InAccountId: 2}
+	if !assert.Nil(t, pkg.Ledger.Transfer(booked)) {
+		return
+	}
+	assert.Equal(t, pkg.ErrCodeNo, transfer(2, 20).ErrCode)
+	assert.Equal(t, pkg.ErrCodeSerialNoReused, transfer(2, 25).ErrCode)
+
+	out, _ := pkg.Ledger.Account(outBank, 1)
+	balance, _ := out.Balance(2, 0)
+	assert.Equal(t, int64(50), balance)
+}
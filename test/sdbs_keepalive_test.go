@@ -0,0 +1,139 @@
+package test
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fdingiit/mpl/pkg/sdbs/client"
+	"github.com/fdingiit/mpl/pkg/sdbs/server/pkg"
+	"github.com/fdingiit/mpl/pkg/simple"
+	"github.com/stretchr/testify/assert"
+)
+
+// countingListener counts accepted connections.
+type countingListener struct {
+	net.Listener
+	accepted int32
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err == nil {
+		atomic.AddInt32(&l.accepted, 1)
+	}
+	return c, err
+}
+
+func startSDBSServer(t *testing.T, srv *pkg.Server) *countingListener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	cl := &countingListener{Listener: l}
+	go srv.Serve(cl)
+	t.Cleanup(func() { l.Close() })
+	return cl
+}
+
+func sdbsCall(t *testing.T, c *client.Client, serialNo int) bool {
+	ctx, cancel := context.WithTimeout(simple.WithChecksum(context.TODO(), simple.MD5Checksum), 3*time.Second)
+	defer cancel()
+
+	var rsp simple.Response
+	if !assert.Nil(t, c.Call(ctx, pagedRequest(serialNo, "keep-alive"), &rsp)) {
+		return false
+	}
+	return assert.Equal(t, serialNo, rsp.SerialNo)
+}
+
+func Test_SDBS_KeepAlive(t *testing.T) {
+	l := startSDBSServer(t, &pkg.Server{})
+
+	c := client.New(l.Addr().String(), client.Options{})
+	defer c.Close()
+
+	for i := 1; i <= 5; i++ {
+		if !sdbsCall(t, c, i) {
+			return
+		}
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&l.accepted))
+}
+
+func Test_SDBS_MaxRequestsPerConn(t *testing.T) {
+	l := startSDBSServer(t, &pkg.Server{MaxRequestsPerConn: 2})
+
+	c := client.New(l.Addr().String(), client.Options{})
+	defer c.Close()
+
+	// the client finds out about closed connections on reuse and retries
+	for i := 1; i <= 5; i++ {
+		if !sdbsCall(t, c, i) {
+			return
+		}
+	}
+	assert.Equal(t, int32(3), atomic.LoadInt32(&l.accepted))
+}
+
+func Test_SDBS_IdleTimeout(t *testing.T) {
+	l := startSDBSServer(t, &pkg.Server{IdleTimeout: 50 * time.Millisecond})
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err)
+
+	c := client.New(l.Addr().String(), client.Options{})
+	defer c.Close()
+	if sdbsCall(t, c, 1) {
+		time.Sleep(100 * time.Millisecond)
+		sdbsCall(t, c, 2)
+	}
+}
+
+func Test_SDBS_HalfClose(t *testing.T) {
+	l := startSDBSServer(t, &pkg.Server{})
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(3 * time.Second))
+
+	// two pipelined requests, then no more
+	w := simple.NewWriter(conn)
+	ctx := simple.WithChecksum(context.TODO(), simple.MD5Checksum)
+	for i := 1; i <= 2; i++ {
+		data, err := pagedRequest(i, "half-close").Encode(ctx)
+		if !assert.Nil(t, err) || !assert.Nil(t, w.WriteMessage(data)) {
+			return
+		}
+	}
+	if !assert.Nil(t, conn.(*net.TCPConn).CloseWrite()) {
+		return
+	}
+
+	r := simple.NewReader(conn)
+	for i := 1; i <= 2; i++ {
+		data, err := r.ReadMessage()
+		if !assert.Nil(t, err) {
+			return
+		}
+		var rsp simple.Response
+		if assert.Nil(t, rsp.Decode(ctx, data)) {
+			assert.Equal(t, i, rsp.SerialNo)
+		}
+	}
+	_, err = r.ReadMessage()
+	assert.Equal(t, io.EOF, err)
+}
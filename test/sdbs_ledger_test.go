@@ -0,0 +1,197 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fdingiit/mpl/pkg/sdbs/client"
+	"github.com/fdingiit/mpl/pkg/sdbs/ledger"
+	"github.com/fdingiit/mpl/pkg/sdbs/server/pkg"
+	"github.com/fdingiit/mpl/pkg/simple"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestLedger(t *testing.T, l *ledger.Ledger) {
+	for _, acc := range []ledger.Account{
+		{BankId: 1, AccountId: 100, Balances: []ledger.Balance{{Currency: 2, Unit: 0, Amount: 1000}, {Currency: 3, Amount: 50}}},
+		{BankId: 2, AccountId: 200, Balances: []ledger.Balance{{Currency: 2, Unit: 0, Amount: 0}}},
+		{BankId: 2, AccountId: 201, Frozen: true, Balances: []ledger.Balance{{Currency: 2, Unit: 0, Amount: 500}}},
+	} {
+		if !assert.Nil(t, l.AddAccount(acc)) {
+			t.FailNow()
+		}
+	}
+}
+
+func Test_Ledger_Transfer(t *testing.T) {
+	tests := []struct {
+		name     string
+		transfer ledger.Transfer
+		wantErr  error
+		wantOut  int64
+		wantIn   int64
+	}{
+		{name: "ok", transfer: ledger.Transfer{Currency: 2, Amount: 300, OutBankId: 1, OutAccountId: 100, InBankId: 2, InAccountId: 200}, wantOut: 700, wantIn: 300},
+		{name: "all funds", transfer: ledger.Transfer{Currency: 2, Amount: 1000, OutBankId: 1, OutAccountId: 100, InBankId: 2, InAccountId: 200}, wantOut: 0, wantIn: 1000},
+		{name: "insufficient funds", transfer: ledger.Transfer{Currency: 2, Amount: 1001, OutBankId: 1, OutAccountId: 100, InBankId: 2, InAccountId: 200}, wantErr: ledger.ErrInsufficientFunds},
+		{name: "unknown out account", transfer: ledger.Transfer{Currency: 2, Amount: 1, OutBankId: 1, OutAccountId: 999, InBankId: 2, InAccountId: 200}, wantErr: ledger.ErrUnknownAccount},
+		{name: "unknown in bank", transfer: ledger.Transfer{Currency: 2, Amount: 1, OutBankId: 1, OutAccountId: 100, InBankId: 9, InAccountId: 200}, wantErr: ledger.ErrUnknownAccount},
+		{name: "currency not held by in account", transfer: ledger.Transfer{Currency: 3, Amount: 1, OutBankId: 1, OutAccountId: 100, InBankId: 2, InAccountId: 200}, wantErr: ledger.ErrCurrencyMismatch},
+		{name: "unit not held", transfer: ledger.Transfer{Currency: 2, Unit: 2, Amount: 1, OutBankId: 1, OutAccountId: 100, InBankId: 2, InAccountId: 200}, wantErr: ledger.ErrCurrencyMismatch},
+		{name: "frozen in account", transfer: ledger.Transfer{Currency: 2, Amount: 1, OutBankId: 1, OutAccountId: 100, InBankId: 2, InAccountId: 201}, wantErr: ledger.ErrAccountFrozen},
+		{name: "frozen out account", transfer: ledger.Transfer{Currency: 2, Amount: 1, OutBankId: 2, OutAccountId: 201, InBankId: 1, InAccountId: 100}, wantErr: ledger.ErrAccountFrozen},
+		{name: "zero amount", transfer: ledger.Transfer{Currency: 2, Amount: 0, OutBankId: 1, OutAccountId: 100, InBankId: 2, InAccountId: 200}, wantErr: ledger.ErrInvalidAmount},
+		{name: "negative amount", transfer: ledger.Transfer{Currency: 2, Amount: -5, OutBankId: 1, OutAccountId: 100, InBankId: 2, InAccountId: 200}, wantErr: ledger.ErrInvalidAmount},
+		{name: "same account", transfer: ledger.Transfer{Currency: 2, Amount: 1, OutBankId: 1, OutAccountId: 100, InBankId: 1, InAccountId: 100}, wantErr: ledger.ErrSameAccount},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := ledger.New()
+			newTestLedger(t, l)
+
+			err := l.Transfer(tt.transfer)
+
+			out, _ := l.Account(1, 100)
+			in, _ := l.Account(2, 200)
+			outBalance, _ := out.Balance(2, 0)
+			inBalance, _ := in.Balance(2, 0)
+			if tt.wantErr != nil {
+				assert.True(t, errors.Is(err, tt.wantErr), "error %v", err)
+				// nothing moved
+				assert.Equal(t, int64(1000), outBalance)
+				assert.Equal(t, int64(0), inBalance)
+				return
+			}
+			if assert.Nil(t, err) {
+				assert.Equal(t, tt.wantOut, outBalance)
+				assert.Equal(t, tt.wantIn, inBalance)
+			}
+		})
+	}
+}
+
+func Test_Ledger_File(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "accounts.json")
+
+	l, err := ledger.Open(path)
+	if !assert.Nil(t, err) {
+		return
+	}
+	newTestLedger(t, l)
+	assert.True(t, errors.Is(l.AddAccount(ledger.Account{BankId: 1, AccountId: 100}), ledger.ErrAccountExists))
+	assert.Nil(t, l.Transfer(ledger.Transfer{Currency: 2, Amount: 250, OutBankId: 1, OutAccountId: 100, InBankId: 2, InAccountId: 200}))
+	assert.Nil(t, l.SetFrozen(2, 201, false))
+
+	reopened, err := ledger.Open(path)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, l.Accounts(), reopened.Accounts())
+
+	acc, err := reopened.Account(2, 201)
+	if assert.Nil(t, err) {
+		assert.False(t, acc.Frozen)
+	}
+	acc, _ = reopened.Account(1, 100)
+	balance, _ := acc.Balance(2, 0)
+	assert.Equal(t, int64(750), balance)
+}
+
+func Test_Ledger_SerialNoReused(t *testing.T) {
+	l := ledger.New()
+	newTestLedger(t, l)
+
+	first := ledger.Transfer{SerialNo: 7, Currency: 2, Amount: 300, OutBankId: 1, OutAccountId: 100, InBankId: 2, InAccountId: 200}
+	if !assert.Nil(t, l.Transfer(first)) {
+		return
+	}
+	second := first
+	second.Amount = 100
+	assert.True(t, errors.Is(l.Transfer(second), ledger.ErrSerialNoReused))
+	assert.True(t, errors.Is(l.Transfer(first), ledger.ErrSerialNoReused))
+
+	// the serial number of another bank is its own
+	other := ledger.Transfer{SerialNo: 7, Currency: 2, Amount: 100, OutBankId: 2, OutAccountId: 200, InBankId: 1, InAccountId: 100}
+	assert.Nil(t, l.Transfer(other))
+
+	e, err := l.Lookup(1, 7)
+	if assert.Nil(t, err) {
+		assert.Equal(t, first, e.Transfer)
+	}
+	acc, _ := l.Account(1, 100)
+	balance, _ := acc.Balance(2, 0)
+	assert.Equal(t, int64(800), balance)
+}
+
+// ledgerBanks hands out bank ids no other test uses on the package ledger of
+// the server.
+var ledgerBanks = 9000
+
+func newLedgerBank() int {
+	ledgerBanks++
+	return ledgerBanks
+}
+
+func Test_SDBSLedger_Transfer(t *testing.T) {
+	outBank, inBank := newLedgerBank(), newLedgerBank()
+	for _, acc := range []ledger.Account{
+		{BankId: outBank, AccountId: 1, Balances: []ledger.Balance{{Currency: 2, Amount: 100}}},
+		{BankId: inBank, AccountId: 2, Balances: []ledger.Balance{{Currency: 2, Amount: 0}}},
+	} {
+		if !assert.Nil(t, pkg.Ledger.AddAccount(acc)) {
+			return
+		}
+	}
+
+	l := startSDBSServer(t, &pkg.Server{})
+	c := client.New(l.Addr().String(), client.Options{})
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(simple.WithChecksum(context.TODO(), simple.MD5Checksum), 3*time.Second)
+	defer cancel()
+
+	transfer := func(serialNo, amount int) *simple.Response {
+		req := pagedRequest(serialNo, "ledger")
+		req.OutBankId, req.OutAccountId, req.InBankId, req.InAccountId = outBank, 1, inBank, 2
+		req.Amount = amount
+
+		var rsp simple.Response
+		if !assert.Nil(t, c.Call(ctx, req, &rsp)) {
+			t.FailNow()
+		}
+		return &rsp
+	}
+
+	assert.Equal(t, pkg.ErrCodeNo, transfer(1, 60).ErrCode)
+	rsp := transfer(2, 60)
+	assert.Equal(t, pkg.ErrCodeInsufficientFunds, rsp.ErrCode)
+	assert.Contains(t, rsp.Message, "insufficient funds")
+
+	assert.Nil(t, pkg.Ledger.SetFrozen(inBank, 2, true))
+	assert.Equal(t, pkg.ErrCodeAccountFrozen, transfer(3, 10).ErrCode)
+
+	out, _ := pkg.Ledger.Account(outBank, 1)
+	in, _ := pkg.Ledger.Account(inBank, 2)
+	outBalance, _ := out.Balance(2, 0)
+	inBalance, _ := in.Balance(2, 0)
+	assert.Equal(t, int64(40), outBalance)
+	assert.Equal(t, int64(60), inBalance)
+
+	// the ledger refuses a booked serial number the replay cache never saw
+	assert.Nil(t, pkg.Ledger.SetFrozen(inBank, 2, false))
+	assert.Nil(t, pkg.Ledger.Transfer(ledger.Transfer{SerialNo: 5, Currency: 2, Amount: 10, OutBankId: outBank, OutAccountId: 1, InBankId: inBank, InAccountId: 2}))
+	assert.Equal(t, pkg.ErrCodeSerialNoReused, transfer(5, 20).ErrCode)
+	out, _ = pkg.Ledger.Account(outBank, 1)
+	outBalance, _ = out.Balance(2, 0)
+	assert.Equal(t, int64(30), outBalance)
+
+	req := pagedRequest(4, "unknown account")
+	req.OutBankId = outBank
+	var unknown simple.Response
+	if assert.Nil(t, c.Call(ctx, req, &unknown)) {
+		assert.Equal(t, pkg.ErrCodeUnknownAccount, unknown.ErrCode)
+	}
+}
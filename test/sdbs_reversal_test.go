@@ -0,0 +1,160 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fdingiit/mpl/pkg/sdbs/client"
+	"github.com/fdingiit/mpl/pkg/sdbs/ledger"
+	"github.com/fdingiit/mpl/pkg/sdbs/server/pkg"
+	"github.com/fdingiit/mpl/pkg/simple"
+	"github.com/stretchr/testify/assert"
+)
+
+func balanceOf(t *testing.T, l *ledger.Ledger, bankId, accountId int) int64 {
+	acc, err := l.Account(bankId, accountId)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	balance, _ := acc.Balance(2, 0)
+	return balance
+}
+
+func Test_Ledger_Reverse(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "accounts.json")
+	l, err := ledger.Open(path)
+	if !assert.Nil(t, err) {
+		return
+	}
+	newTestLedger(t, l)
+
+	transfer := ledger.Transfer{SerialNo: 7, Currency: 2, Amount: 300, OutBankId: 1, OutAccountId: 100, InBankId: 2, InAccountId: 200}
+	if !assert.Nil(t, l.Transfer(transfer)) {
+		return
+	}
+
+	_, err = l.Lookup(1, 8)
+	assert.True(t, errors.Is(err, ledger.ErrUnknownTransfer), "error %v", err)
+	_, err = l.Lookup(2, 7)
+	assert.True(t, errors.Is(err, ledger.ErrUnknownTransfer), "error %v", err)
+
+	e, err := l.Lookup(1, 7)
+	if assert.Nil(t, err) {
+		assert.Equal(t, transfer, e.Transfer)
+		assert.Equal(t, 0, e.ReversedBy)
+		assert.False(t, e.Time.IsZero())
+	}
+
+	// the in account must be able to pay the amount back
+	assert.Nil(t, l.SetFrozen(2, 200, true))
+	_, err = l.Reverse(1, 7, 70)
+	assert.True(t, errors.Is(err, ledger.ErrAccountFrozen), "error %v", err)
+	assert.Nil(t, l.SetFrozen(2, 200, false))
+
+	rev, err := l.Reverse(1, 7, 70)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, e.Id, rev.ReversalOf)
+	assert.Equal(t, 70, rev.Transfer.SerialNo)
+	assert.Equal(t, 2, rev.Transfer.OutBankId)
+	assert.Equal(t, int64(1000), balanceOf(t, l, 1, 100))
+	assert.Equal(t, int64(0), balanceOf(t, l, 2, 200))
+
+	_, err = l.Reverse(1, 7, 71)
+	assert.True(t, errors.Is(err, ledger.ErrAlreadyReversed), "error %v", err)
+	_, err = l.Reverse(1, 70, 72)
+	assert.True(t, errors.Is(err, ledger.ErrUnknownTransfer), "reversals cannot be reversed, error %v", err)
+
+	reopened, err := ledger.Open(path)
+	if !assert.Nil(t, err) {
+		return
+	}
+	e, err = reopened.Lookup(1, 7)
+	if assert.Nil(t, err) {
+		assert.Equal(t, rev.Id, e.ReversedBy)
+	}
+	_, err = reopened.Reverse(1, 7, 73)
+	assert.True(t, errors.Is(err, ledger.ErrAlreadyReversed), "error %v", err)
+}
+
+func Test_SDBSReversal(t *testing.T) {
+	outBank, inBank := newLedgerBank(), newLedgerBank()
+	for _, acc := range []ledger.Account{
+		{BankId: outBank, AccountId: 1, Balances: []ledger.Balance{{Currency: 2, Amount: 100}}},
+		{BankId: inBank, AccountId: 2, Balances: []ledger.Balance{{Currency: 2, Amount: 0}}},
+	} {
+		if !assert.Nil(t, pkg.Ledger.AddAccount(acc)) {
+			return
+		}
+	}
+
+	l := startSDBSServer(t, &pkg.Server{})
+	c := client.New(l.Addr().String(), client.Options{})
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(simple.WithChecksum(context.TODO(), simple.MD5Checksum), 3*time.Second)
+	defer cancel()
+
+	query := func(serialNo, origSerialNo int) *simple.QueryResponse {
+		req := &simple.QueryRequest{
+			Header:        simple.Header{Type: simple.TypeRequest, ServiceCode: simple.ServiceQuery},
+			UnixTimestamp: time.Now().Unix(),
+			SerialNo:      serialNo,
+			OutBankId:     outBank,
+			OrigSerialNo:  origSerialNo,
+		}
+		var rsp simple.QueryResponse
+		if !assert.Nil(t, c.Call(ctx, req, &rsp)) {
+			t.FailNow()
+		}
+		assert.Equal(t, simple.ServiceQuery, rsp.ServiceCode)
+		assert.Equal(t, serialNo, rsp.SerialNo)
+		return &rsp
+	}
+	reverse := func(serialNo, origSerialNo int) *simple.ReversalResponse {
+		req := &simple.ReversalRequest{
+			Header:        simple.Header{Type: simple.TypeRequest, ServiceCode: simple.ServiceReversal},
+			UnixTimestamp: time.Now().Unix(),
+			SerialNo:      serialNo,
+			OutBankId:     outBank,
+			OrigSerialNo:  origSerialNo,
+			Notes:         "gateway timeout",
+		}
+		var rsp simple.ReversalResponse
+		if !assert.Nil(t, c.Call(ctx, req, &rsp)) {
+			t.FailNow()
+		}
+		assert.Equal(t, origSerialNo, rsp.OrigSerialNo)
+		return &rsp
+	}
+
+	assert.Equal(t, pkg.ErrCodeUnknownTransfer, query(10, 1).ErrCode)
+	assert.Equal(t, pkg.ErrCodeUnknownTransfer, reverse(11, 1).ErrCode)
+
+	req := pagedRequest(1, "to be reversed")
+	req.OutBankId, req.OutAccountId, req.InBankId, req.InAccountId = outBank, 1, inBank, 2
+	req.Amount = 40
+	var rsp simple.Response
+	if !assert.Nil(t, c.Call(ctx, req, &rsp)) || !assert.Equal(t, pkg.ErrCodeNo, rsp.ErrCode) {
+		return
+	}
+
+	q := query(12, 1)
+	assert.Equal(t, pkg.ErrCodeNo, q.ErrCode)
+	assert.Equal(t, simple.TransferBooked, q.Status)
+	assert.Equal(t, 40, q.Amount)
+	assert.Equal(t, inBank, q.InBankId)
+	assert.Equal(t, 2, q.InAccountId)
+	assert.NotZero(t, q.BookedAt)
+
+	assert.Equal(t, pkg.ErrCodeNo, reverse(13, 1).ErrCode)
+	assert.Equal(t, pkg.ErrCodeAlreadyReversed, reverse(14, 1).ErrCode)
+	assert.Equal(t, simple.TransferReversed, query(15, 1).Status)
+
+	assert.Equal(t, int64(100), balanceOf(t, pkg.Ledger, outBank, 1))
+	assert.Equal(t, int64(0), balanceOf(t, pkg.Ledger, inBank, 2))
+}
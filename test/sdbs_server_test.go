@@ -0,0 +1,169 @@
+package test
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fdingiit/mpl/pkg/sdbs/server/pkg"
+	"github.com/fdingiit/mpl/pkg/simple"
+	"github.com/stretchr/testify/assert"
+)
+
+func dialSDBS(t *testing.T, addr string) (net.Conn, *simple.Reader, *simple.Writer) {
+	conn, err := net.Dial("tcp", addr)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return conn, simple.NewReader(conn), simple.NewWriter(conn)
+}
+
+func readSDBSResponse(t *testing.T, r *simple.Reader) *simple.Response {
+	ctx := simple.WithChecksum(context.TODO(), simple.MD5Checksum)
+	asm := &simple.Assembler{}
+	for {
+		msg, err := r.ReadMessage()
+		if !assert.Nil(t, err) {
+			return nil
+		}
+		data, err := asm.Add(ctx, msg)
+		if !assert.Nil(t, err) {
+			return nil
+		}
+		if data != nil {
+			var rsp simple.Response
+			if !assert.Nil(t, rsp.Decode(ctx, data)) {
+				return nil
+			}
+			return &rsp
+		}
+	}
+}
+
+func Test_SDBSServer_Shutdown(t *testing.T) {
+	srv := &pkg.Server{}
+	l := startSDBSServer(t, srv)
+
+	// an idle connection and one in the middle of a two-page request
+	idle, _, _ := dialSDBS(t, l.Addr().String())
+	_, r, w := dialSDBS(t, l.Addr().String())
+
+	ctx := simple.WithChecksum(context.TODO(), simple.MD5Checksum)
+	req := pagedRequest(1, strings.Repeat("x", 300))
+	pages, err := simple.EncodePages(ctx, req, req.SerialNo, 400)
+	if !assert.Nil(t, err) || !assert.Len(t, pages, 2) {
+		return
+	}
+	if !assert.Nil(t, w.WriteMessage(pages[0])) {
+		return
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- srv.Shutdown(context.TODO())
+	}()
+
+	// the idle connection is closed right away
+	_, err = idle.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err)
+
+	select {
+	case err := <-shutdown:
+		t.Fatalf("Shutdown() returned %v with a request in flight", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if !assert.Nil(t, w.WriteMessage(pages[1])) {
+		return
+	}
+	if rsp := readSDBSResponse(t, r); rsp != nil {
+		assert.Equal(t, 1, rsp.SerialNo)
+	}
+
+	select {
+	case err := <-shutdown:
+		assert.Nil(t, err)
+	case <-time.After(time.Second):
+		t.Fatalf("Shutdown() did not return")
+	}
+	_, err = r.ReadMessage()
+	assert.Equal(t, io.EOF, err)
+
+	assert.Equal(t, pkg.ErrServerClosed, srv.Serve(l))
+	assert.Equal(t, pkg.ErrServerClosed, srv.ListenAndServe())
+}
+
+func Test_SDBSServer_ShutdownTimeout(t *testing.T) {
+	srv := &pkg.Server{}
+	l := startSDBSServer(t, srv)
+
+	_, r, w := dialSDBS(t, l.Addr().String())
+	ctx := simple.WithChecksum(context.TODO(), simple.MD5Checksum)
+	req := pagedRequest(1, strings.Repeat("x", 300))
+	pages, err := simple.EncodePages(ctx, req, req.SerialNo, 400)
+	if !assert.Nil(t, err) || !assert.Nil(t, w.WriteMessage(pages[0])) {
+		return
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	sctx, cancel := context.WithTimeout(context.TODO(), 100*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, srv.Shutdown(sctx))
+
+	_, err = r.ReadMessage()
+	assert.NotNil(t, err)
+}
+
+func Test_SDBSServer_MaxConns(t *testing.T) {
+	l := startSDBSServer(t, &pkg.Server{MaxConns: 1})
+	ctx := simple.WithChecksum(context.TODO(), simple.MD5Checksum)
+
+	first, r1, w1 := dialSDBS(t, l.Addr().String())
+	data, _ := pagedRequest(1, "first").Encode(ctx)
+	if !assert.Nil(t, w1.WriteMessage(data)) || readSDBSResponse(t, r1) == nil {
+		return
+	}
+
+	// the second connection waits for the first one to go away
+	second, r2, w2 := dialSDBS(t, l.Addr().String())
+	data, _ = pagedRequest(2, "second").Encode(ctx)
+	if !assert.Nil(t, w2.WriteMessage(data)) {
+		return
+	}
+	second.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, err := second.Read(make([]byte, 1))
+	if ne, ok := err.(net.Error); !assert.True(t, ok && ne.Timeout(), "error %v", err) {
+		return
+	}
+
+	first.Close()
+	second.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if rsp := readSDBSResponse(t, r2); rsp != nil {
+		assert.Equal(t, 2, rsp.SerialNo)
+	}
+}
+
+func Test_SDBSServer_PageError(t *testing.T) {
+	l := startSDBSServer(t, &pkg.Server{})
+	_, r, w := dialSDBS(t, l.Addr().String())
+
+	// a page sent twice is refused, the error response carrying the serial
+	// number of the page
+	ctx := simple.WithChecksum(context.TODO(), simple.MD5Checksum)
+	pages, err := simple.EncodePages(ctx, pagedRequest(42, strings.Repeat("x", 300)), 42, 100)
+	if !assert.Nil(t, err) || !assert.True(t, len(pages) > 2) {
+		return
+	}
+	assert.Nil(t, w.WriteMessage(pages[1], pages[1]))
+
+	if rsp := readSDBSResponse(t, r); assert.NotNil(t, rsp) {
+		assert.Equal(t, pkg.ErrCodeIncorrectRequest, rsp.ErrCode, rsp.Message)
+		assert.Equal(t, 42, rsp.SerialNo)
+	}
+}
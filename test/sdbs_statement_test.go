@@ -0,0 +1,143 @@
+package test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/fdingiit/mpl/pkg/sdbs/client"
+	"github.com/fdingiit/mpl/pkg/sdbs/ledger"
+	"github.com/fdingiit/mpl/pkg/sdbs/server/pkg"
+	"github.com/fdingiit/mpl/pkg/simple"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Ledger_Statement(t *testing.T) {
+	l := ledger.New()
+	newTestLedger(t, l)
+
+	start := time.Now()
+	for i := 1; i <= 3; i++ {
+		if !assert.Nil(t, l.Transfer(ledger.Transfer{SerialNo: i, Currency: 2, Amount: 10, OutBankId: 1, OutAccountId: 100, InBankId: 2, InAccountId: 200})) {
+			return
+		}
+	}
+	_, err := l.Reverse(1, 2, 20)
+	assert.Nil(t, err)
+
+	entries, err := l.Statement(2, 200, time.Time{}, time.Time{})
+	if assert.Nil(t, err) && assert.Len(t, entries, 4) {
+		assert.Equal(t, 2, entries[3].ReversalOf)
+	}
+	entries, err = l.Statement(2, 201, time.Time{}, time.Time{})
+	assert.Nil(t, err)
+	assert.Empty(t, entries)
+
+	entries, err = l.Statement(1, 100, start, time.Now().Add(time.Second))
+	assert.Nil(t, err)
+	assert.Len(t, entries, 4)
+	entries, err = l.Statement(1, 100, time.Time{}, start)
+	assert.Nil(t, err)
+	assert.Empty(t, entries)
+
+	_, err = l.Statement(1, 999, time.Time{}, time.Time{})
+	assert.ErrorIs(t, err, ledger.ErrUnknownAccount)
+}
+
+func Test_SDBSStatement(t *testing.T) {
+	outBank, inBank := newLedgerBank(), newLedgerBank()
+	for _, acc := range []ledger.Account{
+		{BankId: outBank, AccountId: 1, Balances: []ledger.Balance{{Currency: 2, Amount: 1000}, {Currency: 3, Unit: 1, Amount: 5}}},
+		{BankId: inBank, AccountId: 2, Balances: []ledger.Balance{{Currency: 2, Amount: 0}}},
+	} {
+		if !assert.Nil(t, pkg.Ledger.AddAccount(acc)) {
+			return
+		}
+	}
+
+	n := pkg.StatementLimit + 5
+	for i := 1; i <= n; i++ {
+		if !assert.Nil(t, pkg.Ledger.Transfer(ledger.Transfer{SerialNo: i, Currency: 2, Amount: 1, OutBankId: outBank, OutAccountId: 1, InBankId: inBank, InAccountId: 2})) {
+			return
+		}
+	}
+	if _, err := pkg.Ledger.Reverse(outBank, n, 1); !assert.Nil(t, err) {
+		return
+	}
+
+	l := startSDBSServer(t, &pkg.Server{})
+	c := client.New(l.Addr().String(), client.Options{})
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(simple.WithChecksum(context.TODO(), simple.MD5Checksum), 3*time.Second)
+	defer cancel()
+
+	var balance simple.BalanceResponse
+	if assert.Nil(t, c.Call(ctx, &simple.BalanceRequest{
+		Header:    simple.Header{Type: simple.TypeRequest, ServiceCode: simple.ServiceBalance},
+		SerialNo:  1,
+		BankId:    outBank,
+		AccountId: 1,
+	}, &balance)) {
+		assert.Equal(t, pkg.ErrCodeNo, balance.ErrCode)
+		assert.Equal(t, []simple.Balance{{Currency: 2, Amount: int64(1000 - n + 1)}, {Currency: 3, Unit: 1, Amount: 5}}, balance.Balances)
+	}
+	var unknown simple.BalanceResponse
+	if assert.Nil(t, c.Call(ctx, &simple.BalanceRequest{
+		Header:    simple.Header{Type: simple.TypeRequest, ServiceCode: simple.ServiceBalance},
+		SerialNo:  2,
+		BankId:    outBank,
+		AccountId: 2,
+	}, &unknown)) {
+		assert.Equal(t, pkg.ErrCodeUnknownAccount, unknown.ErrCode)
+		assert.Empty(t, unknown.Balances)
+	}
+
+	statement := func(bankId, accountId int, from, to int64, afterId int) *simple.StatementResponse {
+		var rsp simple.StatementResponse
+		if !assert.Nil(t, c.Call(ctx, &simple.StatementRequest{
+			Header:    simple.Header{Type: simple.TypeRequest, ServiceCode: simple.ServiceStatement},
+			SerialNo:  3,
+			BankId:    bankId,
+			AccountId: accountId,
+			From:      from,
+			To:        to,
+			AfterId:   afterId,
+		}, &rsp)) {
+			t.FailNow()
+		}
+		return &rsp
+	}
+
+	// the statement does not fit in a page
+	first := statement(inBank, 2, 0, 0, 0)
+	assert.Equal(t, pkg.ErrCodeNo, first.ErrCode)
+	assert.True(t, first.More)
+	if !assert.Len(t, first.Entries, pkg.StatementLimit) {
+		return
+	}
+	e := first.Entries[0]
+	assert.Equal(t, 1, e.SerialNo)
+	assert.Equal(t, int64(1), e.Amount)
+	assert.Equal(t, outBank, e.CounterBankId)
+	assert.Equal(t, 1, e.CounterAccountId)
+	assert.NotZero(t, e.BookedAt)
+
+	rest := statement(inBank, 2, 0, 0, first.Entries[len(first.Entries)-1].Id)
+	assert.False(t, rest.More)
+	if assert.Len(t, rest.Entries, 6) {
+		reversal := rest.Entries[5]
+		assert.Equal(t, int64(-1), reversal.Amount)
+		assert.Equal(t, rest.Entries[4].Id, reversal.ReversalOf)
+	}
+
+	paid := statement(outBank, 1, time.Now().Add(-time.Hour).Unix(), time.Now().Add(time.Hour).Unix(), rest.Entries[0].Id)
+	if assert.Len(t, paid.Entries, 5) {
+		assert.Equal(t, int64(-1), paid.Entries[0].Amount)
+		assert.Equal(t, inBank, paid.Entries[0].CounterBankId)
+	}
+
+	assert.Empty(t, statement(inBank, 2, time.Now().Add(time.Hour).Unix(), 0, 0).Entries)
+	assert.Equal(t, pkg.ErrCodeIncorrectRequest, statement(inBank, 2, 10, 5, 0).ErrCode)
+	assert.Equal(t, pkg.ErrCodeUnknownAccount, statement(inBank, 3, 0, 0, 0).ErrCode)
+}
@@ -0,0 +1,257 @@
+package test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fdingiit/mpl/pkg/sdbs/client"
+	gateway "github.com/fdingiit/mpl/pkg/sdbs/gateway/pkg"
+	"github.com/fdingiit/mpl/pkg/sdbs/server/pkg"
+	"github.com/fdingiit/mpl/pkg/simple"
+	"github.com/stretchr/testify/assert"
+)
+
+// testCA issues the certificates of a test, written as PEM files to dir.
+type testCA struct {
+	t    *testing.T
+	dir  string
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+var testSerial int64
+
+func newTestCA(t *testing.T, dir, name string) *testCA {
+	ca := &testCA{t: t, dir: dir}
+	ca.cert, ca.key = ca.issue(name, nil)
+	return ca
+}
+
+// issue returns a certificate of name signed by ca, a CA certificate if ca
+// has none yet. Names that are IPs go in the IP SANs.
+func (ca *testCA) issue(name string, usage []x509.ExtKeyUsage) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if !assert.Nil(ca.t, err) {
+		ca.t.FailNow()
+	}
+	testSerial++
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(testSerial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  usage,
+	}
+	if ip := net.ParseIP(name); ip != nil {
+		tmpl.IPAddresses = []net.IP{ip}
+	} else {
+		tmpl.DNSNames = []string{name}
+	}
+
+	parent, signer := ca.cert, ca.key
+	if parent == nil {
+		tmpl.IsCA, tmpl.BasicConstraintsValid = true, true
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+		parent, signer = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, signer)
+	if !assert.Nil(ca.t, err) {
+		ca.t.FailNow()
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return cert, key
+}
+
+// writeCA writes the CA certificate to file.
+func (ca *testCA) writeCA(file string) string {
+	return ca.write(file, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}))
+}
+
+// writePair issues a certificate of name and writes it to certFile and its
+// key to keyFile.
+func (ca *testCA) writePair(name string, usage x509.ExtKeyUsage, certFile, keyFile string) (*x509.Certificate, simple.TLSFiles) {
+	cert, key := ca.issue(name, []x509.ExtKeyUsage{usage})
+	der, _ := x509.MarshalECPrivateKey(key)
+	files := simple.TLSFiles{
+		CertFile: ca.write(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})),
+		KeyFile:  ca.write(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})),
+	}
+	return cert, files
+}
+
+// write replaces file, its modification time moved forward so that the
+// change is seen whatever the resolution of the file system clock.
+func (ca *testCA) write(file string, data []byte) string {
+	path := filepath.Join(ca.dir, file)
+	mod := time.Now()
+	if fi, err := os.Stat(path); err == nil {
+		mod = fi.ModTime().Add(time.Second)
+	}
+	assert.Nil(ca.t, ioutil.WriteFile(path, data, 0600))
+	assert.Nil(ca.t, os.Chtimes(path, mod, mod))
+	return path
+}
+
+func startTLSServer(t *testing.T, files simple.TLSFiles) string {
+	config, err := files.ServerConfig()
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	return startSDBSServer(t, &pkg.Server{TLSConfig: config}).Addr().String()
+}
+
+func tlsCall(t *testing.T, addr string, files simple.TLSFiles) error {
+	config, err := files.ClientConfig("127.0.0.1")
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	c := client.New(addr, client.Options{TLSConfig: config})
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(simple.WithChecksum(context.TODO(), simple.MD5Checksum), 3*time.Second)
+	defer cancel()
+	var rsp simple.Response
+	if err := c.Call(ctx, pagedRequest(1, "tls"), &rsp); err != nil {
+		return err
+	}
+	assert.Equal(t, 1, rsp.SerialNo)
+	return nil
+}
+
+// peerCertificate returns the certificate the server at addr presents.
+func peerCertificate(t *testing.T, addr string, config *tls.Config) *x509.Certificate {
+	conn, err := tls.Dial("tcp", addr, config)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	defer conn.Close()
+	return conn.ConnectionState().PeerCertificates[0]
+}
+
+func Test_SDBSTLS_Server(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, dir, "test ca")
+	_, serverFiles := ca.writePair("127.0.0.1", x509.ExtKeyUsageServerAuth, "server.pem", "server-key.pem")
+	addr := startTLSServer(t, serverFiles)
+
+	assert.Nil(t, tlsCall(t, addr, simple.TLSFiles{CAFile: ca.writeCA("ca.pem")}))
+
+	// the server certificate must come from the CA and name the server
+	other := newTestCA(t, dir, "other ca")
+	assert.NotNil(t, tlsCall(t, addr, simple.TLSFiles{CAFile: other.writeCA("other-ca.pem")}))
+	config, _ := simple.TLSFiles{CAFile: filepath.Join(dir, "ca.pem")}.ClientConfig("sdbs.example")
+	c := client.New(addr, client.Options{TLSConfig: config})
+	defer c.Close()
+	assert.NotNil(t, c.Call(context.TODO(), pagedRequest(1, "tls"), &simple.Response{}))
+
+	// plain TCP clients get no answer
+	plain := client.New(addr, client.Options{})
+	defer plain.Close()
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Second)
+	defer cancel()
+	assert.NotNil(t, plain.Call(ctx, pagedRequest(1, "plain"), &simple.Response{}))
+
+	_, err := simple.TLSFiles{CertFile: serverFiles.CertFile}.ServerConfig()
+	assert.NotNil(t, err)
+	_, err = simple.TLSFiles{CAFile: filepath.Join(dir, "ca.pem")}.ClientConfig("")
+	assert.NotNil(t, err)
+	_, err = simple.TLSFiles{CAFile: serverFiles.KeyFile}.ClientConfig("127.0.0.1")
+	assert.NotNil(t, err)
+}
+
+func Test_SDBSTLS_Mutual(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, dir, "test ca")
+	_, serverFiles := ca.writePair("127.0.0.1", x509.ExtKeyUsageServerAuth, "server.pem", "server-key.pem")
+	serverFiles.CAFile = ca.writeCA("ca.pem")
+	addr := startTLSServer(t, serverFiles)
+
+	_, clientFiles := ca.writePair("gateway-1", x509.ExtKeyUsageClientAuth, "client.pem", "client-key.pem")
+	clientFiles.CAFile = serverFiles.CAFile
+	assert.Nil(t, tlsCall(t, addr, clientFiles))
+
+	// clients without a certificate of the CA are refused
+	assert.NotNil(t, tlsCall(t, addr, simple.TLSFiles{CAFile: serverFiles.CAFile}))
+	other := newTestCA(t, dir, "other ca")
+	_, otherFiles := other.writePair("intruder", x509.ExtKeyUsageClientAuth, "intruder.pem", "intruder-key.pem")
+	otherFiles.CAFile = serverFiles.CAFile
+	assert.NotNil(t, tlsCall(t, addr, otherFiles))
+}
+
+func Test_SDBSTLS_Reload(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, dir, "test ca")
+	first, serverFiles := ca.writePair("127.0.0.1", x509.ExtKeyUsageServerAuth, "server.pem", "server-key.pem")
+	serverFiles.CAFile = ca.writeCA("ca.pem")
+	addr := startTLSServer(t, serverFiles)
+
+	_, clientFiles := ca.writePair("gateway-1", x509.ExtKeyUsageClientAuth, "client.pem", "client-key.pem")
+	clientFiles.CAFile = serverFiles.CAFile
+	config, err := clientFiles.ClientConfig("127.0.0.1")
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, first.SerialNumber, peerCertificate(t, addr, config).SerialNumber)
+
+	// renewed certificates are served from the next handshake on
+	second, _ := ca.writePair("127.0.0.1", x509.ExtKeyUsageServerAuth, "server.pem", "server-key.pem")
+	assert.Equal(t, second.SerialNumber, peerCertificate(t, addr, config).SerialNumber)
+
+	// a certificate not matching its key yet keeps the previous pair in use
+	ca.writePair("127.0.0.1", x509.ExtKeyUsageServerAuth, "server.pem", "stale-key.pem")
+	assert.Equal(t, second.SerialNumber, peerCertificate(t, addr, config).SerialNumber)
+
+	// so does a file that fails to parse
+	ca.write("server-key.pem", []byte("not a key"))
+	assert.Equal(t, second.SerialNumber, peerCertificate(t, addr, config).SerialNumber)
+
+	// a new CA, on both sides, replaces the old one for servers and clients
+	next := newTestCA(t, dir, "next ca")
+	next.writePair("127.0.0.1", x509.ExtKeyUsageServerAuth, "server.pem", "server-key.pem")
+	assert.NotNil(t, tlsCall(t, addr, clientFiles))
+	next.writeCA("ca.pem")
+	assert.NotNil(t, tlsCall(t, addr, clientFiles))
+	next.writePair("gateway-1", x509.ExtKeyUsageClientAuth, "client.pem", "client-key.pem")
+	assert.Nil(t, tlsCall(t, addr, clientFiles))
+}
+
+func Test_SDBSTLS_Gateway(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, dir, "test ca")
+	_, serverFiles := ca.writePair("127.0.0.1", x509.ExtKeyUsageServerAuth, "server.pem", "server-key.pem")
+	serverFiles.CAFile = ca.writeCA("ca.pem")
+	addr := startTLSServer(t, serverFiles)
+
+	_, clientFiles := ca.writePair("gateway-1", x509.ExtKeyUsageClientAuth, "client.pem", "client-key.pem")
+	clientFiles.CAFile = serverFiles.CAFile
+	config, err := clientFiles.ClientConfig("127.0.0.1")
+	if !assert.Nil(t, err) {
+		return
+	}
+	c := client.New(addr, client.Options{TLSConfig: config})
+	gw := httptest.NewServer(&gateway.Gateway{Client: c, Timeout: 3 * time.Second})
+	defer func() {
+		gw.Close()
+		c.Close()
+	}()
+
+	status, rsp := postGateway(t, gw.URL+"/balance", map[string]int{"serial_no": 1, "bank_id": newLedgerBank(), "account_id": 1}, nil)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, float64(pkg.ErrCodeUnknownAccount), rsp["err_code"])
+}
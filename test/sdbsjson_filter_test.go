@@ -0,0 +1,361 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fdingiit/mpl/pkg/plugin/sdbs/codec"
+	"github.com/fdingiit/mpl/pkg/plugin/sdbsjson/filter"
+	"github.com/fdingiit/mpl/pkg/sdbs/ledger"
+	"github.com/fdingiit/mpl/pkg/sdbs/server/pkg"
+	"github.com/fdingiit/mpl/pkg/simple"
+	"github.com/stretchr/testify/assert"
+	"mosn.io/api"
+	"mosn.io/pkg/buffer"
+	"mosn.io/pkg/header"
+)
+
+type filterPath string
+
+func (p filterPath) MatchType() api.PathMatchType { return api.Exact }
+func (p filterPath) Matcher() string              { return string(p) }
+
+type filterRoute struct {
+	api.Route
+	rule *filterRule
+}
+
+func (r *filterRoute) RouteRule() api.RouteRule { return r.rule }
+
+type filterRule struct {
+	api.RouteRule
+	path string
+}
+
+func (r *filterRule) PathMatchCriterion() api.PathMatchCriterion { return filterPath(r.path) }
+
+type filterReceiver struct {
+	api.StreamReceiverFilterHandler
+	route      api.Route
+	headers    api.HeaderMap
+	data       api.IoBuffer
+	hijackCode int
+	hijackBody string
+}
+
+func (h *filterReceiver) Route() api.Route                        { return h.route }
+func (h *filterReceiver) SetRequestHeaders(headers api.HeaderMap) { h.headers = headers }
+func (h *filterReceiver) SetRequestData(buf api.IoBuffer)         { h.data = buf }
+func (h *filterReceiver) SendHijackReplyWithBody(code int, headers api.HeaderMap, body string) {
+	h.hijackCode, h.hijackBody = code, body
+}
+
+type filterSender struct {
+	api.StreamSenderFilterHandler
+	data api.IoBuffer
+}
+
+func (h *filterSender) SetResponseData(buf api.IoBuffer) { h.data = buf }
+
+type filterCallbacks struct {
+	receivers []api.StreamReceiverFilter
+	senders   []api.StreamSenderFilter
+}
+
+func (c *filterCallbacks) AddStreamSenderFilter(f api.StreamSenderFilter, p api.SenderFilterPhase) {
+	c.senders = append(c.senders, f)
+}
+
+func (c *filterCallbacks) AddStreamReceiverFilter(f api.StreamReceiverFilter, p api.ReceiverFilterPhase) {
+	c.receivers = append(c.receivers, f)
+}
+
+func (c *filterCallbacks) AddStreamAccessLog(log api.AccessLog) {}
+
+// newTranscoder returns the filter of one stream on a route to path.
+func newTranscoder(t *testing.T, path string) (api.StreamReceiverFilter, api.StreamSenderFilter, *filterReceiver, *filterSender) {
+	var conf map[string]interface{}
+	json.Unmarshal([]byte(`{
+		"routes": {
+			"/transfer": {"service_code": 1000501},
+			"/balance": {"service_code": 1000504, "fields": {"bank": "bank_id", "account": "account_id", "balances": "balance"}},
+			"/statement": {"service_code": 1000505}
+		},
+		"statuses": {"5": 409}
+	}`), &conf)
+	factory, err := api.CreateStreamFilterChainFactory(filter.FilterName, conf)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	var callbacks filterCallbacks
+	factory.CreateFilterChain(context.TODO(), &callbacks)
+	if !assert.Len(t, callbacks.receivers, 1) || !assert.Len(t, callbacks.senders, 1) {
+		t.FailNow()
+	}
+
+	receiver := &filterReceiver{route: &filterRoute{rule: &filterRule{path: path}}}
+	sender := &filterSender{}
+	callbacks.receivers[0].SetReceiveFilterHandler(receiver)
+	callbacks.senders[0].SetSenderFilterHandler(sender)
+	return callbacks.receivers[0], callbacks.senders[0], receiver, sender
+}
+
+// sdbsRequest encodes the request the filter handed over as the codec would
+// on its way to the server.
+func sdbsRequest(t *testing.T, receiver *filterReceiver) []byte {
+	frame, ok := receiver.headers.(*codec.Request)
+	if !assert.True(t, ok, "%T", receiver.headers) || !assert.NotNil(t, receiver.data) {
+		t.FailNow()
+	}
+	frame.SetData(receiver.data)
+	out, err := (&codec.Proto{}).Encode(context.TODO(), frame)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	return out.Bytes()
+}
+
+// sdbsResponse decodes the pages of a response in data as the codec would on
+// its way back from the server.
+func sdbsResponse(t *testing.T, data []byte) *codec.Response {
+	frame, err := (&codec.Proto{}).Decode(context.TODO(), buffer.NewIoBufferBytes(data))
+	if !assert.Nil(t, err) || !assert.IsType(t, &codec.Response{}, frame) {
+		t.FailNow()
+	}
+	return frame.(*codec.Response)
+}
+
+// appendResponse runs the response in data through the codec and the filter,
+// returning the headers the filter set.
+func appendResponse(t *testing.T, sf api.StreamSenderFilter, data []byte) api.HeaderMap {
+	frame := sdbsResponse(t, data)
+	sf.Append(context.TODO(), frame, frame.GetData(), nil)
+	return frame
+}
+
+func filterStatus(headers api.HeaderMap) string {
+	s, _ := headers.Get(filter.HeaderStatus)
+	return s
+}
+
+func Test_SDBSJSONFilter(t *testing.T) {
+	ctx := simple.WithChecksum(context.TODO(), simple.MD5Checksum)
+
+	rf, sf, receiver, sender := newTranscoder(t, "/balance")
+	headers := header.CommonHeader{}
+	status := rf.OnReceive(context.TODO(), headers, buffer.NewIoBufferString(`{"serial_no":3,"bank":1,"account":100}`), nil)
+	if !assert.Equal(t, api.StreamFilterContinue, status) {
+		return
+	}
+
+	// the filter hands over the body alone, the codec heads it
+	assert.NotContains(t, receiver.data.String(), simple.TypeRequest)
+	var req simple.BalanceRequest
+	if assert.Nil(t, req.Decode(ctx, sdbsRequest(t, receiver))) {
+		assert.Equal(t, simple.ServiceBalance, req.ServiceCode)
+		assert.Equal(t, simple.TypeRequest, req.Type)
+		assert.Equal(t, 3, req.SerialNo)
+		assert.Equal(t, 1, req.BankId)
+		assert.Equal(t, 100, req.AccountId)
+	}
+
+	rsp := &simple.BalanceResponse{
+		Header:   simple.Header{Type: simple.TypeResponse, ServiceCode: simple.ServiceBalance},
+		SerialNo: 3,
+		Message:  "ok",
+		BankId:   1,
+		Balances: []simple.Balance{{Currency: 2, Amount: 1000}},
+	}
+	data, _ := rsp.Encode(ctx)
+	rspHeaders := appendResponse(t, sf, data)
+	if assert.NotNil(t, sender.data) {
+		var got map[string]interface{}
+		assert.Nil(t, json.Unmarshal(sender.data.Bytes(), &got))
+		assert.Equal(t, 1.0, got["bank"])
+		assert.Equal(t, []interface{}{map[string]interface{}{"currency": 2.0, "unit": 0.0, "amount": 1000.0}}, got["balances"])
+		assert.NotContains(t, got, "bank_id")
+	}
+	assert.Equal(t, "200", filterStatus(rspHeaders))
+	contentType, _ := rspHeaders.Get("Content-Type")
+	assert.Equal(t, "application/json", contentType)
+}
+
+func Test_SDBSJSONFilter_Statuses(t *testing.T) {
+	ctx := simple.WithChecksum(context.TODO(), simple.MD5Checksum)
+
+	tests := []struct {
+		name    string
+		errCode int
+		tamper  bool
+		want    string
+	}{
+		{name: "ok", errCode: 0, want: "200"},
+		{name: "incorrect request", errCode: 1, want: "400"},
+		{name: "configured", errCode: 5, want: "409"},
+		{name: "funds", errCode: 8, want: "422"},
+		{name: "duplicate", errCode: 9, want: "409"},
+		{name: "out of the catalogue", errCode: 42, want: "500"},
+		{name: "checksum mismatch", tamper: true, want: "502"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rf, sf, _, sender := newTranscoder(t, "/transfer")
+			rf.OnReceive(context.TODO(), header.CommonHeader{}, buffer.NewIoBufferString(`{"serial_no":1,"amount":5}`), nil)
+
+			rsp := &simple.Response{Header: simple.Header{Type: simple.TypeResponse, ServiceCode: simple.ServiceTransfer}, SerialNo: 1, ErrCode: tt.errCode, Message: "done"}
+			data, _ := rsp.Encode(ctx)
+			if tt.tamper {
+				data = []byte(strings.Replace(string(data), "done", "DONE", 1))
+			}
+
+			headers := appendResponse(t, sf, data)
+			assert.Equal(t, tt.want, filterStatus(headers))
+			assert.Contains(t, sender.data.String(), map[bool]string{false: `"serial_no":1`, true: `checksum`}[tt.tamper])
+		})
+	}
+}
+
+func Test_SDBSJSONFilter_Paged(t *testing.T) {
+	ctx := simple.WithChecksum(context.TODO(), simple.MD5Checksum)
+
+	rf, sf, _, sender := newTranscoder(t, "/statement")
+	rf.OnReceive(context.TODO(), header.CommonHeader{}, buffer.NewIoBufferString(`{"serial_no":9,"bank_id":1,"account_id":100}`), nil)
+
+	rsp := &simple.StatementResponse{Header: simple.Header{Type: simple.TypeResponse, ServiceCode: simple.ServiceStatement}, SerialNo: 9}
+	for i := 1; i <= 20; i++ {
+		rsp.Entries = append(rsp.Entries, simple.StatementEntry{Id: i, Amount: int64(i)})
+	}
+	pages, err := simple.EncodePages(ctx, rsp, rsp.SerialNo, 256)
+	if !assert.Nil(t, err) || !assert.True(t, len(pages) > 1) {
+		return
+	}
+	var data []byte
+	for _, page := range pages {
+		data = append(data, page...)
+	}
+
+	// the codec assembles the pages, the filter gets the whole body
+	headers := appendResponse(t, sf, data)
+	assert.Equal(t, "200", filterStatus(headers))
+	var got map[string]interface{}
+	if assert.Nil(t, json.Unmarshal(sender.data.Bytes(), &got)) {
+		assert.Len(t, got["entry"], 20)
+	}
+
+	// a response that is no sdbs frame is answered with a bad gateway
+	rf, sf, _, sender = newTranscoder(t, "/statement")
+	rf.OnReceive(context.TODO(), header.CommonHeader{}, buffer.NewIoBufferString(`{"serial_no":9}`), nil)
+	plain := header.CommonHeader{}
+	sf.Append(context.TODO(), plain, buffer.NewIoBufferBytes(data), nil)
+	assert.Equal(t, "502", filterStatus(plain))
+	assert.Contains(t, sender.data.String(), "not an sdbs response")
+}
+
+func Test_SDBSJSONFilter_Passthrough(t *testing.T) {
+	rf, sf, receiver, sender := newTranscoder(t, "/other")
+	body := buffer.NewIoBufferString(`{"hello":"world"}`)
+	assert.Equal(t, api.StreamFilterContinue, rf.OnReceive(context.TODO(), header.CommonHeader{}, body, nil))
+	assert.Nil(t, receiver.headers)
+	assert.Nil(t, receiver.data)
+
+	headers := header.CommonHeader{}
+	assert.Equal(t, api.StreamFilterContinue, sf.Append(context.TODO(), headers, buffer.NewIoBufferString("plain"), nil))
+	assert.Nil(t, sender.data)
+	assert.Empty(t, headers)
+}
+
+// Test_SDBSJSONFilter_Server runs requests through the filter and the codec
+// to an SDBS server and back.
+func Test_SDBSJSONFilter_Server(t *testing.T) {
+	bank := newLedgerBank()
+	for _, acc := range []ledger.Account{
+		{BankId: bank, AccountId: 1, Balances: []ledger.Balance{{Currency: 2, Amount: 10}}},
+		{BankId: bank, AccountId: 2, Balances: []ledger.Balance{{Currency: 2, Amount: 0}}},
+	} {
+		if !assert.Nil(t, pkg.Ledger.AddAccount(acc)) {
+			return
+		}
+	}
+	l := startSDBSServer(t, &pkg.Server{})
+
+	call := func(path, body string) (api.HeaderMap, map[string]interface{}) {
+		rf, sf, receiver, sender := newTranscoder(t, path)
+		if !assert.Equal(t, api.StreamFilterContinue, rf.OnReceive(context.TODO(), header.CommonHeader{}, buffer.NewIoBufferString(body), nil)) {
+			t.FailNow()
+		}
+
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if !assert.Nil(t, err) {
+			t.FailNow()
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(3 * time.Second))
+		if _, err := conn.Write(sdbsRequest(t, receiver)); !assert.Nil(t, err) {
+			t.FailNow()
+		}
+
+		proto := &codec.Proto{}
+		in := buffer.NewIoBuffer(1024)
+		var rsp *codec.Response
+		for rsp == nil {
+			if _, err := in.ReadOnce(conn); !assert.Nil(t, err) {
+				t.FailNow()
+			}
+			frame, err := proto.Decode(context.TODO(), in)
+			if !assert.Nil(t, err) {
+				t.FailNow()
+			}
+			rsp, _ = frame.(*codec.Response)
+		}
+		sf.Append(context.TODO(), rsp, rsp.GetData(), nil)
+
+		var got map[string]interface{}
+		assert.Nil(t, json.Unmarshal(sender.data.Bytes(), &got), sender.data.String())
+		return rsp, got
+	}
+
+	headers, got := call("/balance", fmt.Sprintf(`{"serial_no":1,"bank":%d,"account":1}`, bank))
+	if assert.Equal(t, "200", filterStatus(headers), "%v", got) {
+		assert.Equal(t, []interface{}{map[string]interface{}{"currency": 2.0, "unit": 0.0, "amount": 10.0}}, got["balances"])
+	}
+
+	headers, got = call("/transfer", fmt.Sprintf(`{"serial_no":2,"timestamp":%d,"currency":2,"amount":30,"out_bank_id":%d,"out_account_id":1,"in_bank_id":%d,"in_account_id":2}`, time.Now().Unix(), bank, bank))
+	// insufficient funds, which the config maps to 409
+	assert.Equal(t, "409", filterStatus(headers), "%v", got)
+	assert.Equal(t, float64(pkg.ErrCodeInsufficientFunds), got["err_code"])
+	assert.Equal(t, 2.0, got["serial_no"])
+}
+
+func Test_SDBSJSONFilter_BadRequest(t *testing.T) {
+	for _, body := range []string{`{"serial_no":"one"}`, `{"unknown":1}`, `not json`, `{"bank":1,"bank_id":2}`} {
+		rf, _, receiver, _ := newTranscoder(t, "/balance")
+		status := rf.OnReceive(context.TODO(), header.CommonHeader{}, buffer.NewIoBufferString(body), nil)
+		assert.Equal(t, api.StreamFilterStop, status, body)
+		assert.Equal(t, 400, receiver.hijackCode, body)
+		assert.Contains(t, receiver.hijackBody, `"message"`, body)
+	}
+}
+
+func Test_SDBSJSONFilter_Config(t *testing.T) {
+	for _, conf := range []string{
+		`{"routes": {"/x": {"service_code": 123}}}`,
+		`{"routes": {"/x": null}}`,
+		`{"routes": {"/x": {"service_code": 1000501, "fields": {"a": "amount", "b": "amount"}}}}`,
+		`{"statuses": {"five": 409}}`,
+		`{"statuses": {"": 409}}`,
+		`{"statuses": {"5": 40}}`,
+		`{"checksum": "crc32"}`,
+		`{"charset": "latin1"}`,
+	} {
+		var m map[string]interface{}
+		json.Unmarshal([]byte(conf), &m)
+		_, err := filter.CreateFilterFactory(m)
+		assert.NotNil(t, err, conf)
+	}
+}
@@ -0,0 +1,171 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fdingiit/mpl/pkg/simple"
+	"github.com/fdingiit/mpl/pkg/simple/charset"
+	"github.com/stretchr/testify/assert"
+)
+
+var testSigner = &simple.Signer{ClientId: "gateway-1", Key: []byte("a long random secret")}
+
+func testVerifier() *simple.Verifier {
+	return &simple.Verifier{Keys: simple.Keys{testSigner.ClientId: testSigner.Key}}
+}
+
+func Test_SimpleAuth_RoundTrip(t *testing.T) {
+	ctx := simple.WithSigner(simple.WithChecksum(context.TODO(), simple.MD5Checksum), testSigner)
+
+	data, err := simple.Marshal(ctx, pagedRequest(1, "signed"))
+	if !assert.Nil(t, err) {
+		return
+	}
+	clientId, err := testVerifier().Verify(data)
+	assert.Nil(t, err)
+	assert.Equal(t, testSigner.ClientId, clientId)
+
+	// peers that do not authenticate decode signed messages as usual
+	var req simple.Request
+	if assert.Nil(t, simple.Unmarshal(ctx, data, &req)) {
+		assert.Equal(t, simple.AuthSchemeHMAC, req.Reserved)
+		assert.Equal(t, "signed", req.Notes)
+	}
+}
+
+func Test_SimpleAuth_Refused(t *testing.T) {
+	ctx := simple.WithSigner(context.TODO(), testSigner)
+	signed, err := simple.Marshal(ctx, pagedRequest(1, "signed"))
+	if !assert.Nil(t, err) {
+		return
+	}
+	unsigned, _ := simple.Marshal(context.TODO(), pagedRequest(1, "signed"))
+
+	wrongKey, _ := simple.Marshal(simple.WithSigner(context.TODO(), &simple.Signer{ClientId: testSigner.ClientId, Key: []byte("guess")}), pagedRequest(1, "signed"))
+	unknown, _ := simple.Marshal(simple.WithSigner(context.TODO(), &simple.Signer{ClientId: "intruder", Key: []byte("guess")}), pagedRequest(1, "signed"))
+
+	for _, tc := range []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"unsigned", unsigned, "not signed"},
+		{"tampered body", bytes.Replace(signed, []byte("<amount>100<"), []byte("<amount>900<"), 1), "signature mismatch"},
+		{"tampered header", bytes.Replace(signed, []byte("1000501"), []byte("1000502"), 1), "signature mismatch"},
+		{"wrong key", wrongKey, "signature mismatch"},
+		{"unknown client", unknown, "unknown client"},
+		{"truncated", signed[:simple.HeaderLen-1], "incorrect data length"},
+	} {
+		_, err := testVerifier().Verify(tc.data)
+		assert.True(t, errors.Is(err, simple.ErrUnauthorized), "%s: %v", tc.name, err)
+		if assert.NotNil(t, err, tc.name) {
+			assert.Contains(t, err.Error(), tc.want, tc.name)
+		}
+	}
+}
+
+func Test_SimpleAuth_Replay(t *testing.T) {
+	data, err := simple.Marshal(simple.WithSigner(context.TODO(), testSigner), pagedRequest(1, "once"))
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	v := testVerifier()
+	_, err = v.Verify(data)
+	assert.Nil(t, err)
+	_, err = v.Verify(data)
+	if assert.True(t, errors.Is(err, simple.ErrUnauthorized)) {
+		assert.Contains(t, err.Error(), "nonce reused")
+	}
+
+	// the same request signed again carries a fresh nonce
+	again, _ := simple.Marshal(simple.WithSigner(context.TODO(), testSigner), pagedRequest(1, "once"))
+	_, err = v.Verify(again)
+	assert.Nil(t, err)
+}
+
+func Test_SimpleAuth_Skew(t *testing.T) {
+	data, err := simple.Marshal(simple.WithSigner(context.TODO(), testSigner), pagedRequest(1, "late"))
+	if !assert.Nil(t, err) {
+		return
+	}
+	time.Sleep(1100 * time.Millisecond)
+
+	v := testVerifier()
+	v.MaxSkew = time.Millisecond
+	_, err = v.Verify(data)
+	if assert.True(t, errors.Is(err, simple.ErrUnauthorized)) {
+		assert.Contains(t, err.Error(), "timestamp")
+	}
+}
+
+func Test_SimpleAuth_Charset(t *testing.T) {
+	ctx := simple.WithSigner(simple.WithCharset(context.TODO(), charset.GBK), testSigner)
+
+	data, err := simple.Marshal(ctx, pagedRequest(1, "转账备注"))
+	if !assert.Nil(t, err) {
+		return
+	}
+	_, err = testVerifier().Verify(data)
+	assert.Nil(t, err)
+
+	var req simple.Request
+	if assert.Nil(t, simple.Unmarshal(ctx, data, &req)) {
+		assert.Equal(t, "转账备注", req.Notes)
+	}
+}
+
+func Test_SimpleAuth_Paged(t *testing.T) {
+	ctx := simple.WithSigner(simple.WithChecksum(context.TODO(), simple.MD5Checksum), testSigner)
+
+	pages, err := simple.EncodePages(ctx, pagedRequest(1, strings.Repeat("转账备注 ", 40)), 1, 100)
+	if !assert.Nil(t, err) || !assert.True(t, len(pages) > 1) {
+		return
+	}
+
+	// pages are not signed on their own, the assembled message is
+	_, err = testVerifier().Verify(pages[0])
+	assert.NotNil(t, err)
+
+	asm := &simple.Assembler{}
+	var data []byte
+	for _, page := range pages {
+		if data, err = asm.Add(ctx, page); !assert.Nil(t, err) {
+			return
+		}
+	}
+	_, err = testVerifier().Verify(data)
+	assert.Nil(t, err)
+}
+
+func Test_SimpleAuth_LoadKeys(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		assert.Nil(t, ioutil.WriteFile(path, []byte(content), 0600))
+		return path
+	}
+
+	keys, err := simple.LoadKeys(write("keys.json", `{"gateway-1": "secret", "gateway-2": "other"}`))
+	if assert.Nil(t, err) {
+		assert.Equal(t, simple.Keys{"gateway-1": []byte("secret"), "gateway-2": []byte("other")}, keys)
+	}
+
+	for name, content := range map[string]string{
+		"empty.json":   `{"gateway-1": ""}`,
+		"invalid.json": `{"gate<way>": "secret"}`,
+		"broken.json":  `{"gateway-1": `,
+	} {
+		_, err := simple.LoadKeys(write(name, content))
+		assert.NotNil(t, err, name)
+	}
+	_, err = simple.LoadKeys(filepath.Join(dir, "missing.json"))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,139 @@
+package test
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/fdingiit/mpl/pkg/simple"
+	"github.com/fdingiit/mpl/pkg/simple/charset"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Charset(t *testing.T) {
+	tests := []struct {
+		name    string
+		charset charset.Charset
+		text    string
+		want    string // hex
+		wantErr error
+	}{
+		{name: "gbk", charset: charset.GBK, text: "转账备注：工资5", want: "d7aad5cbb1b8d7a2a3bab9a4d7ca35"},
+		{name: "gbk euro", charset: charset.GBK, text: "€", want: "80"},
+		{name: "gbk ascii", charset: charset.GBK, text: "<notes>a&amp;b</notes>", want: hex.EncodeToString([]byte("<notes>a&amp;b</notes>"))},
+		{name: "gbk supplementary", charset: charset.GBK, text: "𠀀", wantErr: charset.ErrUnencodable},
+		{name: "gb18030", charset: charset.GB18030, text: "转账备注：工资€5", want: "d7aad5cbb1b8d7a2a3bab9a4d7caa2e335"},
+		{name: "gb18030 four-byte bmp", charset: charset.GB18030, text: "ḿ", want: "8135f437"},
+		{name: "gb18030 last bmp", charset: charset.GB18030, text: "\uffff", want: "8431a439"},
+		{name: "gb18030 supplementary", charset: charset.GB18030, text: "𠀀", want: "95328236"},
+		{name: "gb18030 first supplementary", charset: charset.GB18030, text: "\U00010000", want: "90308130"},
+		{name: "gb18030 last supplementary", charset: charset.GB18030, text: "\U0010ffff", want: "e3329a35"},
+		{name: "utf-8", charset: charset.UTF8, text: "转账", want: "e8bdace8b4a6"},
+		{name: "invalid utf-8", charset: charset.GBK, text: "a\xffb", wantErr: charset.ErrInvalidSequence},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.charset.Encode([]byte(tt.text))
+			if tt.wantErr != nil {
+				assert.True(t, errors.Is(err, tt.wantErr), "error %v", err)
+				return
+			}
+			if !assert.Nil(t, err) {
+				return
+			}
+			assert.Equal(t, tt.want, hex.EncodeToString(got))
+
+			back, err := tt.charset.Decode(got)
+			if assert.Nil(t, err) {
+				assert.Equal(t, tt.text, string(back))
+			}
+		})
+	}
+}
+
+func Test_Charset_DecodeInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		charset charset.Charset
+		data    string // hex
+	}{
+		{name: "truncated", charset: charset.GBK, data: "41d7"},
+		{name: "bad trail byte", charset: charset.GBK, data: "d77f"},
+		{name: "ff lead", charset: charset.GBK, data: "ff41"},
+		{name: "four-byte in gbk", charset: charset.GBK, data: "8135f437"},
+		{name: "euro byte in gb18030", charset: charset.GB18030, data: "80"},
+		{name: "truncated four-byte", charset: charset.GB18030, data: "8135f4"},
+		{name: "past last bmp", charset: charset.GB18030, data: "8431a530"},
+		{name: "past last supplementary", charset: charset.GB18030, data: "e3329a36"},
+		{name: "invalid utf-8", charset: charset.UTF8, data: "c328"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, _ := hex.DecodeString(tt.data)
+			_, err := tt.charset.Decode(data)
+			assert.True(t, errors.Is(err, charset.ErrInvalidSequence), "error %v", err)
+		})
+	}
+}
+
+func Test_Charset_Lookup(t *testing.T) {
+	for name, want := range map[string]charset.Charset{"UTF-8": charset.UTF8, "gbk": charset.GBK, "CP936": charset.GBK, "GB18030": charset.GB18030} {
+		c, err := charset.Lookup(name)
+		if assert.Nil(t, err, name) {
+			assert.Equal(t, want, c, name)
+		}
+	}
+	_, err := charset.Lookup("big5")
+	assert.True(t, errors.Is(err, charset.ErrUnknownCharset), "error %v", err)
+}
+
+func Test_SimpleCharset_Message(t *testing.T) {
+	for _, c := range []charset.Charset{charset.GBK, charset.GB18030} {
+		t.Run(c.Name(), func(t *testing.T) {
+			ctx := simple.WithCharset(simple.WithChecksum(context.TODO(), simple.MD5Checksum), c)
+
+			req := pagedRequest(1, "转账备注：工资")
+			data, err := req.Encode(ctx)
+			if !assert.Nil(t, err) {
+				return
+			}
+			assert.Equal(t, len(data), req.TotalLength)
+			// 7 characters of 2 bytes each
+			assert.Contains(t, string(data), "<notes>\xd7\xaa\xd5\xcb\xb1\xb8\xd7\xa2\xa3\xba\xb9\xa4\xd7\xca</notes>")
+
+			var got simple.Request
+			if assert.Nil(t, got.Decode(ctx, data)) {
+				assert.Equal(t, req.Notes, got.Notes)
+				assert.Equal(t, req.TotalLength, got.TotalLength)
+			}
+
+			// a UTF-8 peer cannot read it
+			assert.NotNil(t, (&simple.Request{}).Decode(context.TODO(), data))
+		})
+	}
+}
+
+func Test_SimpleCharset_Pages(t *testing.T) {
+	ctx := simple.WithCharset(context.TODO(), charset.GB18030)
+
+	req := pagedRequest(3, strings.Repeat("工资𠀀", 60))
+	pages, err := simple.EncodePages(ctx, req, req.SerialNo, 128)
+	if !assert.Nil(t, err) || !assert.True(t, len(pages) > 1) {
+		return
+	}
+
+	asm := &simple.Assembler{}
+	var whole []byte
+	for _, page := range pages {
+		if whole, err = asm.Add(ctx, page); !assert.Nil(t, err) {
+			return
+		}
+	}
+
+	var got simple.Request
+	if assert.Nil(t, got.Decode(ctx, whole)) {
+		assert.Equal(t, req.Notes, got.Notes)
+	}
+}
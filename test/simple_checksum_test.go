@@ -0,0 +1,94 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"testing"
+
+	"github.com/fdingiit/mpl/pkg/simple"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_SimpleChecksum(t *testing.T) {
+	hmacKey := simple.HMACChecksum(sha256.New, []byte("gateway key"))
+
+	tests := []struct {
+		name     string
+		encodeBy simple.Checksummer
+		decodeBy simple.Checksummer
+		tamper   bool
+		want     string
+		wantErr  error
+	}{
+		{name: "md5", encodeBy: simple.MD5Checksum, decodeBy: simple.MD5Checksum, want: "665db818fa5ef08e9f10ec77d76b9a0e"},
+		{name: "md5 tampered", encodeBy: simple.MD5Checksum, decodeBy: simple.MD5Checksum, tamper: true, wantErr: simple.ErrChecksumMismatch},
+		{name: "sha256", encodeBy: simple.SHA256Checksum, decodeBy: simple.SHA256Checksum, want: "1adc2b0ea81014f38ca3aa925e2c2150"},
+		{name: "hmac", encodeBy: hmacKey, decodeBy: hmacKey},
+		{name: "hmac wrong key", encodeBy: hmacKey, decodeBy: simple.HMACChecksum(sha256.New, []byte("other key")), wantErr: simple.ErrChecksumMismatch},
+		{name: "algorithm mismatch", encodeBy: simple.MD5Checksum, decodeBy: simple.SHA256Checksum, wantErr: simple.ErrChecksumMismatch},
+		{name: "not verified", encodeBy: simple.MD5Checksum, tamper: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rsp := simple.Response{
+				Header: simple.Header{
+					Type:        simple.TypeResponse,
+					ServiceCode: 1000501,
+				},
+				UnixTimestamp: 1648811583,
+				SerialNo:      12345,
+				ErrCode:       0,
+				Message:       "ok",
+			}
+
+			enc, err := rsp.Encode(simple.WithChecksum(context.TODO(), tt.encodeBy))
+			if !assert.Nil(t, err) {
+				return
+			}
+			assert.Len(t, rsp.Checksum, simple.ChecksumLen)
+			if tt.want != "" {
+				assert.Equal(t, tt.want, rsp.Checksum)
+			}
+
+			if tt.tamper {
+				enc = bytes.Replace(enc, []byte("<message>ok</message>"), []byte("<message>OK</message>"), 1)
+			}
+
+			ctx := context.TODO()
+			if tt.decodeBy != nil {
+				ctx = simple.WithChecksum(ctx, tt.decodeBy)
+			}
+			var got simple.Response
+			err = got.Decode(ctx, enc)
+			if tt.wantErr != nil {
+				assert.True(t, errors.Is(err, tt.wantErr), "error %v", err)
+				// the message is still decoded so that the error can be answered
+				assert.Equal(t, rsp.SerialNo, got.SerialNo)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, rsp.Checksum, got.Checksum)
+		})
+	}
+}
+
+func Test_SimpleChecksum_ByName(t *testing.T) {
+	for _, name := range []string{"md5", "sha256"} {
+		c, err := simple.ChecksumByName(name, nil)
+		assert.Nil(t, err)
+		assert.NotNil(t, c)
+	}
+
+	_, err := simple.ChecksumByName("hmac-sha256", nil)
+	assert.NotNil(t, err)
+
+	c, err := simple.ChecksumByName("hmac-md5", []byte("key"))
+	if assert.Nil(t, err) {
+		assert.Len(t, c.Sum([]byte("body")), simple.ChecksumLen)
+	}
+
+	_, err = simple.ChecksumByName("crc32", nil)
+	assert.NotNil(t, err)
+}
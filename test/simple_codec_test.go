@@ -0,0 +1,137 @@
+package test
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"testing"
+
+	"github.com/fdingiit/mpl/pkg/simple"
+	"github.com/stretchr/testify/assert"
+)
+
+type balanceQuery struct {
+	simple.Header
+	SerialNo  int    `xml:"serial_no"`
+	AccountId int    `xml:"account_id"`
+	Currency  int    `xml:"currency"`
+	Memo      string `xml:"memo,omitempty"`
+}
+
+type balanceResult struct {
+	XMLName xml.Name `xml:"balance"`
+	simple.Header
+	SerialNo int   `xml:"serial_no"`
+	ErrCode  int   `xml:"err_code"`
+	Balances []int `xml:"balances>amount"`
+}
+
+type headerByPointer struct {
+	*simple.Header
+	SerialNo int `xml:"serial_no"`
+}
+
+func Test_SimpleCodec_RoundTrip(t *testing.T) {
+	ctx := simple.WithChecksum(context.TODO(), simple.MD5Checksum)
+
+	q := &balanceQuery{
+		Header:    simple.Header{Type: simple.TypeRequest, ServiceCode: 2000001},
+		SerialNo:  42,
+		AccountId: 1234567899321,
+		Currency:  2,
+		Memo:      "余额 <&>",
+	}
+	data, err := simple.Marshal(ctx, q)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, len(data), q.TotalLength)
+	assert.Equal(t, "<serial_no>42</serial_no><account_id>1234567899321</account_id><currency>2</currency><memo>余额 &lt;&amp;&gt;</memo>", string(data[simple.HeaderLen:]))
+
+	var gotQ balanceQuery
+	if assert.Nil(t, simple.Unmarshal(ctx, append(data, "trailing"...), &gotQ)) {
+		assert.Equal(t, *q, gotQ)
+	}
+
+	r := &balanceResult{
+		Header:   simple.Header{Type: simple.TypeResponse, ServiceCode: 2000001},
+		SerialNo: 42,
+		Balances: []int{100, 200},
+	}
+	data, err = simple.Marshal(ctx, r)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, "<serial_no>42</serial_no><err_code>0</err_code><balances><amount>100</amount><amount>200</amount></balances>", string(data[simple.HeaderLen:]))
+
+	var gotR balanceResult
+	if assert.Nil(t, simple.Unmarshal(ctx, data, &gotR)) {
+		assert.Equal(t, r.Header, gotR.Header)
+		assert.Equal(t, r.Balances, gotR.Balances)
+	}
+}
+
+func Test_SimpleCodec_InvalidMessage(t *testing.T) {
+	_, err := simple.Marshal(context.TODO(), &headerByPointer{Header: &simple.Header{}})
+	assert.True(t, errors.Is(err, simple.ErrInvalidMessage), "error %v", err)
+
+	var q *balanceQuery
+	_, err = simple.Marshal(context.TODO(), q)
+	assert.True(t, errors.Is(err, simple.ErrInvalidMessage), "error %v", err)
+
+	err = simple.Unmarshal(context.TODO(), []byte("0000"), &balanceQuery{})
+	assert.NotNil(t, err)
+}
+
+func init() {
+	simple.Register(2000001, &balanceQuery{}, &balanceResult{})
+}
+
+func Test_SimpleCodec_Registry(t *testing.T) {
+	assert.Contains(t, simple.ServiceCodes(), simple.ServiceTransfer)
+	assert.Contains(t, simple.ServiceCodes(), 2000001)
+
+	assert.Panics(t, func() { simple.Register(2000001, &balanceQuery{}, &balanceResult{}) })
+	assert.Panics(t, func() { simple.Register(2000002, &headerByPointer{}, &balanceResult{}) })
+
+	msg, err := simple.NewMessage(2000001, simple.TypeRequest)
+	if assert.Nil(t, err) {
+		q, ok := msg.(*balanceQuery)
+		if assert.True(t, ok, "%T", msg) {
+			assert.Equal(t, simple.TypeRequest, q.Type)
+			assert.Equal(t, 2000001, q.ServiceCode)
+		}
+	}
+
+	_, err = simple.NewMessage(3000001, simple.TypeRequest)
+	assert.True(t, errors.Is(err, simple.ErrUnknownService), "error %v", err)
+	_, err = simple.NewMessage(2000001, "XX")
+	assert.True(t, errors.Is(err, simple.ErrFieldValue), "error %v", err)
+
+	data, err := simple.Marshal(context.TODO(), &balanceResult{
+		Header:   simple.Header{Type: simple.TypeResponse, ServiceCode: 2000001},
+		SerialNo: 7,
+	})
+	if !assert.Nil(t, err) {
+		return
+	}
+	msg, err = simple.DecodeMessage(context.TODO(), data)
+	if assert.Nil(t, err) {
+		r, ok := msg.(*balanceResult)
+		if assert.True(t, ok, "%T", msg) {
+			assert.Equal(t, 7, r.SerialNo)
+		}
+	}
+
+	data, err = (&simple.Request{Header: simple.Header{Type: simple.TypeRequest, ServiceCode: simple.ServiceTransfer}, SerialNo: 8}).Encode(context.TODO())
+	if !assert.Nil(t, err) {
+		return
+	}
+	msg, err = simple.DecodeMessage(context.TODO(), data)
+	if assert.Nil(t, err) {
+		req, ok := msg.(*simple.Request)
+		if assert.True(t, ok, "%T", msg) {
+			assert.Equal(t, 8, req.SerialNo)
+		}
+	}
+}
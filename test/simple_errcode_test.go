@@ -0,0 +1,90 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/fdingiit/mpl/pkg/simple"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_SimpleErrCode_Catalogue(t *testing.T) {
+	codes := simple.ErrCodes()
+	names := make(map[string]bool)
+	for i, info := range codes {
+		assert.Equal(t, i, info.Code)
+		assert.NotEmpty(t, info.Description, info.Name)
+		assert.False(t, names[info.Name], "duplicate name %s", info.Name)
+		names[info.Name] = true
+
+		_, ok := simple.DefaultStatuses[info.Category]
+		assert.True(t, ok, "category %q of %s has no status", info.Category, info.Name)
+	}
+
+	info, ok := simple.LookupErrCode(simple.ErrCodeTimeout)
+	assert.True(t, ok)
+	assert.Equal(t, simple.CategoryTimeout, info.Category)
+	assert.True(t, info.Retryable)
+
+	info, ok = simple.LookupErrCode(simple.ErrCodeSerialNoReused)
+	assert.True(t, ok)
+	assert.Equal(t, simple.CategoryDuplicate, info.Category)
+	assert.False(t, info.Retryable)
+
+	info, ok = simple.LookupErrCode(len(codes))
+	assert.False(t, ok)
+	assert.Equal(t, simple.CategoryInternal, info.Category)
+	assert.False(t, info.Retryable)
+}
+
+func Test_SimpleErrCode_StatusMapping(t *testing.T) {
+	var defaults *simple.StatusMapping
+	assert.Equal(t, http.StatusOK, defaults.Status(simple.ErrCodeNo))
+	assert.Equal(t, http.StatusBadRequest, defaults.Status(simple.ErrCodeUnknownAccount))
+	assert.Equal(t, http.StatusUnauthorized, defaults.Status(simple.ErrCodeUnauthorized))
+	assert.Equal(t, http.StatusUnprocessableEntity, defaults.Status(simple.ErrCodeAccountFrozen))
+	assert.Equal(t, http.StatusConflict, defaults.Status(simple.ErrCodeAlreadyReversed))
+	assert.Equal(t, http.StatusNotFound, defaults.Status(simple.ErrCodeUnknownService))
+	assert.Equal(t, http.StatusGatewayTimeout, defaults.Status(simple.ErrCodeTimeout))
+	assert.Equal(t, http.StatusInternalServerError, defaults.Status(99))
+
+	m, err := simple.ParseStatusMapping(" funds=409, 8=403,duplicate=200 ")
+	if assert.Nil(t, err) {
+		assert.Equal(t, http.StatusConflict, m.Status(simple.ErrCodeInsufficientFunds))
+		// codes come before their category
+		assert.Equal(t, http.StatusForbidden, m.Status(simple.ErrCodeAccountFrozen))
+		assert.Equal(t, http.StatusOK, m.Status(simple.ErrCodeSerialNoReused))
+		assert.Equal(t, http.StatusBadRequest, m.Status(simple.ErrCodeIncorrectRequest))
+	}
+
+	m = &simple.StatusMapping{Default: http.StatusTeapot}
+	assert.Equal(t, http.StatusTeapot, m.Status(99))
+	assert.Equal(t, http.StatusOK, m.Status(simple.ErrCodeNo))
+
+	for _, s := range []string{"funds", "funds=abc", "money=400", "=400", "5=99", "5=600"} {
+		_, err := simple.ParseStatusMapping(s)
+		assert.NotNil(t, err, s)
+	}
+	m, err = simple.ParseStatusMapping("")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusInternalServerError, m.Status(simple.ErrCodeInternal))
+}
+
+func Test_SimpleErrCode_ErrorBody(t *testing.T) {
+	data, err := json.Marshal(simple.NewErrorBody(simple.ErrCodeInternal, "sdbs server down"))
+	if assert.Nil(t, err) {
+		assert.JSONEq(t, `{"err_code":4,"error":"INTERNAL","category":"internal","retryable":true,"message":"sdbs server down"}`, string(data))
+	}
+
+	data, err = simple.AddErrorFields([]byte(`{"serial_no":7,"err_code":5,"message":"short"}`), simple.ErrCodeInsufficientFunds)
+	if assert.Nil(t, err) {
+		assert.JSONEq(t, `{"serial_no":7,"err_code":5,"message":"short","error":"INSUFFICIENT_FUNDS","category":"funds","retryable":false}`, string(data))
+	}
+
+	_, err = simple.AddErrorFields([]byte(`[1]`), simple.ErrCodeInternal)
+	assert.NotNil(t, err)
+
+	assert.Equal(t, simple.ErrCodeAlreadyReversed, simple.ErrCodeOf(&simple.ReversalResponse{ErrCode: simple.ErrCodeAlreadyReversed}))
+	assert.Equal(t, 0, simple.ErrCodeOf(&simple.Request{}))
+}
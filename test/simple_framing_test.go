@@ -0,0 +1,130 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/fdingiit/mpl/pkg/simple"
+	"github.com/stretchr/testify/assert"
+)
+
+func framedRequest(t *testing.T, serialNo int, notes string) []byte {
+	data, err := pagedRequest(serialNo, notes).Encode(context.TODO())
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	return data
+}
+
+func Test_SimpleFraming_Read(t *testing.T) {
+	first := framedRequest(t, 1, strings.Repeat("x", 5000))
+	second := framedRequest(t, 2, "second")
+
+	// one byte at a time, the way the slowest peer would send it
+	r := simple.NewReader(iotest.OneByteReader(bytes.NewReader(append(append([]byte{}, first...), second...))))
+	for _, want := range [][]byte{first, second} {
+		got, err := r.ReadMessage()
+		if assert.Nil(t, err) {
+			assert.Equal(t, want, got)
+		}
+	}
+	_, err := r.ReadMessage()
+	assert.Equal(t, io.EOF, err)
+}
+
+func Test_SimpleFraming_ReadErrors(t *testing.T) {
+	valid := framedRequest(t, 1, "notes")
+
+	tests := []struct {
+		name    string
+		data    string
+		maxSize int
+		wantErr error
+	}{
+		{name: "truncated prefix", data: string(valid[:5]), wantErr: io.ErrUnexpectedEOF},
+		{name: "truncated body", data: string(valid[:len(valid)-1]), wantErr: io.ErrUnexpectedEOF},
+		{name: "not numeric", data: "0000x156" + string(valid[8:]), wantErr: simple.ErrFieldNotNumeric},
+		{name: "shorter than header", data: "00000051" + string(valid[8:]), wantErr: simple.ErrFieldValue},
+		{name: "too large", data: string(valid), maxSize: len(valid) - 1, wantErr: simple.ErrFrameTooLarge},
+		{name: "above default limit", data: "99999999", wantErr: simple.ErrFrameTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := simple.NewReader(strings.NewReader(tt.data))
+			r.MaxSize = tt.maxSize
+			_, err := r.ReadMessage()
+			assert.True(t, errors.Is(err, tt.wantErr), "error %v", err)
+		})
+	}
+}
+
+func Test_SimpleFraming_Deadline(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	data := framedRequest(t, 1, "notes")
+	go client.Write(data[:20])
+
+	r := simple.NewReader(server)
+	r.Timeout = 50 * time.Millisecond
+	_, err := r.ReadMessage()
+	assert.True(t, errors.Is(err, os.ErrDeadlineExceeded), "error %v", err)
+}
+
+func Test_SimpleFraming_Write(t *testing.T) {
+	first := framedRequest(t, 1, "first")
+	second := framedRequest(t, 2, "second")
+
+	var buf bytes.Buffer
+	w := simple.NewWriter(&buf)
+	if assert.Nil(t, w.WriteMessage(first, second)) {
+		assert.Equal(t, append(append([]byte{}, first...), second...), buf.Bytes())
+	}
+
+	buf.Reset()
+	assert.NotNil(t, w.WriteMessage(first[:len(first)-1]))
+	assert.NotNil(t, w.WriteMessage(first[:10]))
+	assert.Equal(t, 0, buf.Len())
+
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	w = simple.NewWriter(server)
+	w.Timeout = 50 * time.Millisecond
+	err := w.WriteMessage(first)
+	assert.True(t, errors.Is(err, os.ErrDeadlineExceeded), "error %v", err)
+}
+
+func Test_SimpleFraming_Idle(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	r := simple.NewReader(server)
+	r.IdleTimeout = 50 * time.Millisecond
+	r.Timeout = time.Second
+	_, err := r.ReadMessage()
+	assert.Equal(t, simple.ErrIdle, err)
+
+	// once a message has started, Timeout applies
+	data := framedRequest(t, 1, "notes")
+	go func() {
+		client.Write(data[:20])
+		time.Sleep(100 * time.Millisecond)
+		client.Write(data[20:])
+	}()
+	got, err := r.ReadMessage()
+	if assert.Nil(t, err) {
+		assert.Equal(t, data, got)
+	}
+}
@@ -0,0 +1,131 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/fdingiit/mpl/pkg/simple"
+	"github.com/stretchr/testify/assert"
+)
+
+const testChecksum = "665db818fa5ef08e9f10ec77d76b9a0e"
+
+func validHeader() simple.Header {
+	return simple.Header{
+		TotalLength: 156,
+		Type:        simple.TypeResponse,
+		PageMark:    0,
+		Checksum:    testChecksum,
+		ServiceCode: 1000501,
+		Reserved:    0,
+	}
+}
+
+func Test_SimpleHeader_Encode(t *testing.T) {
+	tests := []struct {
+		name      string
+		modify    func(h *simple.Header)
+		want      string
+		wantField string
+		wantErr   error
+	}{
+		{name: "valid", modify: func(h *simple.Header) {}, want: "00000156RS0" + testChecksum + "010005010"},
+		{name: "empty checksum", modify: func(h *simple.Header) { h.Checksum = "" }, want: "00000156RS0" + strings.Repeat(" ", 32) + "010005010"},
+		{name: "max values", modify: func(h *simple.Header) {
+			h.TotalLength, h.PageMark, h.ServiceCode, h.Reserved, h.Type = 99999999, simple.PageLast, 99999999, 9, simple.TypeRequest
+		}, want: "99999999RQ3" + testChecksum + "999999999"},
+		{name: "min total length", modify: func(h *simple.Header) { h.TotalLength = simple.HeaderLen }, want: "00000052RS0" + testChecksum + "010005010"},
+		{name: "total length below header", modify: func(h *simple.Header) { h.TotalLength = simple.HeaderLen - 1 }, wantField: "TotalLength", wantErr: simple.ErrFieldValue},
+		{name: "total length overflow", modify: func(h *simple.Header) { h.TotalLength = 100000000 }, wantField: "TotalLength", wantErr: simple.ErrFieldWidth},
+		{name: "negative total length", modify: func(h *simple.Header) { h.TotalLength = -1 }, wantField: "TotalLength", wantErr: simple.ErrFieldValue},
+		{name: "short type", modify: func(h *simple.Header) { h.Type = "R" }, wantField: "Type", wantErr: simple.ErrFieldWidth},
+		{name: "long type", modify: func(h *simple.Header) { h.Type = "RQS" }, wantField: "Type", wantErr: simple.ErrFieldWidth},
+		{name: "unknown type", modify: func(h *simple.Header) { h.Type = "XX" }, wantField: "Type", wantErr: simple.ErrFieldValue},
+		{name: "page mark overflow", modify: func(h *simple.Header) { h.PageMark = 10 }, wantField: "PageMark", wantErr: simple.ErrFieldWidth},
+		{name: "unknown page mark", modify: func(h *simple.Header) { h.PageMark = simple.PageLast + 1 }, wantField: "PageMark", wantErr: simple.ErrFieldValue},
+		{name: "negative page mark", modify: func(h *simple.Header) { h.PageMark = -1 }, wantField: "PageMark", wantErr: simple.ErrFieldValue},
+		{name: "short checksum", modify: func(h *simple.Header) { h.Checksum = testChecksum[:31] }, wantField: "Checksum", wantErr: simple.ErrFieldWidth},
+		{name: "long checksum", modify: func(h *simple.Header) { h.Checksum = testChecksum + "0" }, wantField: "Checksum", wantErr: simple.ErrFieldWidth},
+		{name: "multi-byte checksum", modify: func(h *simple.Header) { h.Checksum = "é" + testChecksum[2:] }, wantField: "Checksum", wantErr: simple.ErrFieldNotPrinting},
+		{name: "service code overflow", modify: func(h *simple.Header) { h.ServiceCode = 100000000 }, wantField: "ServiceCode", wantErr: simple.ErrFieldWidth},
+		{name: "negative service code", modify: func(h *simple.Header) { h.ServiceCode = -1 }, wantField: "ServiceCode", wantErr: simple.ErrFieldValue},
+		{name: "reserved overflow", modify: func(h *simple.Header) { h.Reserved = 10 }, wantField: "Reserved", wantErr: simple.ErrFieldWidth},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := validHeader()
+			tt.modify(&h)
+
+			enc, err := h.Encode(context.TODO())
+			if tt.wantErr == nil {
+				if assert.Nil(t, err) {
+					assert.Equal(t, tt.want, string(enc))
+					assert.Len(t, enc, simple.HeaderLen)
+				}
+				return
+			}
+
+			assert.Nil(t, enc)
+			var herr *simple.HeaderError
+			if assert.True(t, errors.As(err, &herr), "error %v", err) {
+				assert.Equal(t, tt.wantField, herr.Field)
+				assert.True(t, errors.Is(err, tt.wantErr), "error %v", err)
+			}
+		})
+	}
+}
+
+func Test_SimpleHeader_Decode(t *testing.T) {
+	valid := "00000156RS0" + testChecksum + "010005010"
+
+	tests := []struct {
+		name       string
+		data       string
+		want       simple.Header
+		wantField  string
+		wantOffset int
+		wantErr    error
+	}{
+		{name: "valid", data: valid, want: validHeader()},
+		{name: "trailing body ignored", data: valid + "<timestamp>1</timestamp>", want: validHeader()},
+		{name: "blank checksum", data: "00000156RS0" + strings.Repeat(" ", 32) + "010005010", want: func() simple.Header {
+			h := validHeader()
+			h.Checksum = ""
+			return h
+		}()},
+		{name: "too short", data: valid[:51], wantField: "Header", wantOffset: 51, wantErr: simple.ErrHeaderTooShort},
+		{name: "empty", data: "", wantField: "Header", wantOffset: 0, wantErr: simple.ErrHeaderTooShort},
+		{name: "space in total length", data: " 0000156" + valid[8:], wantField: "TotalLength", wantOffset: 0, wantErr: simple.ErrFieldNotNumeric},
+		{name: "sign in total length", data: "-0000156" + valid[8:], wantField: "TotalLength", wantOffset: 0, wantErr: simple.ErrFieldNotNumeric},
+		{name: "letter in total length", data: "0000015x" + valid[8:], wantField: "TotalLength", wantOffset: 7, wantErr: simple.ErrFieldNotNumeric},
+		{name: "total length below header", data: "00000051" + valid[8:], wantField: "TotalLength", wantOffset: 0, wantErr: simple.ErrFieldValue},
+		{name: "unknown type", data: valid[:8] + "rs" + valid[10:], wantField: "Type", wantOffset: 8, wantErr: simple.ErrFieldValue},
+		{name: "unknown page mark", data: valid[:10] + "4" + valid[11:], wantField: "PageMark", wantOffset: 10, wantErr: simple.ErrFieldValue},
+		{name: "page mark not numeric", data: valid[:10] + "a" + valid[11:], wantField: "PageMark", wantOffset: 10, wantErr: simple.ErrFieldNotNumeric},
+		{name: "control char in checksum", data: valid[:20] + "\n" + valid[21:], wantField: "Checksum", wantOffset: 20, wantErr: simple.ErrFieldNotPrinting},
+		{name: "service code not numeric", data: valid[:50] + "x" + valid[51:], wantField: "ServiceCode", wantOffset: 50, wantErr: simple.ErrFieldNotNumeric},
+		{name: "reserved not numeric", data: valid[:51] + " ", wantField: "Reserved", wantOffset: 51, wantErr: simple.ErrFieldNotNumeric},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var h simple.Header
+			err := h.Decode(context.TODO(), []byte(tt.data))
+			if tt.wantErr == nil {
+				if assert.Nil(t, err) {
+					assert.Equal(t, tt.want, h)
+				}
+				return
+			}
+
+			assert.Equal(t, simple.Header{}, h)
+			var herr *simple.HeaderError
+			if assert.True(t, errors.As(err, &herr), "error %v", err) {
+				assert.Equal(t, tt.wantField, herr.Field)
+				assert.Equal(t, tt.wantOffset, herr.Offset)
+				assert.True(t, errors.Is(err, tt.wantErr), "error %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/fdingiit/mpl/pkg/simple"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_SimpleJSON(t *testing.T) {
+	rsp := &simple.StatementResponse{
+		Header:   simple.Header{Type: simple.TypeResponse, ServiceCode: simple.ServiceStatement},
+		SerialNo: 3,
+		Message:  "ok",
+		More:     true,
+		Entries: []simple.StatementEntry{
+			{Id: 1, SerialNo: 7, Amount: -5, CounterBankId: 2},
+			{Id: 2, SerialNo: 8, Amount: 5, ReversalOf: 1},
+		},
+	}
+
+	data, err := simple.MarshalJSON(rsp)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, `{"timestamp":0,"serial_no":3,"err_code":0,"message":"ok","more":true,"entry":[`+
+		`{"id":1,"booked_at":0,"serial_no":7,"currency":0,"unit":0,"amount":-5,"counter_bank_id":2,"counter_account_id":0,"reversal_of":0},`+
+		`{"id":2,"booked_at":0,"serial_no":8,"currency":0,"unit":0,"amount":5,"counter_bank_id":0,"counter_account_id":0,"reversal_of":1}]}`, string(data))
+
+	got := &simple.StatementResponse{Header: rsp.Header}
+	if assert.Nil(t, simple.UnmarshalJSON(data, got)) {
+		assert.Equal(t, rsp, got)
+	}
+
+	tests := []struct {
+		name    string
+		data    string
+		want    simple.Request
+		wantErr error
+	}{
+		{name: "fields", data: `{"serial_no":12,"amount":100,"notes":"工资"}`, want: simple.Request{SerialNo: 12, Amount: 100, Notes: "工资"}},
+		{name: "empty", data: `{}`},
+		{name: "unknown field", data: `{"serial_no":12,"SerialNo":1}`, wantErr: simple.ErrUnknownField},
+		{name: "wrong type", data: `{"amount":"100"}`},
+		{name: "not an object", data: `[1]`},
+		{name: "null", data: `null`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req simple.Request
+			err := simple.UnmarshalJSON([]byte(tt.data), &req)
+			if tt.want != (simple.Request{}) || tt.name == "empty" {
+				if assert.Nil(t, err) {
+					assert.Equal(t, tt.want, req)
+				}
+				return
+			}
+			if assert.NotNil(t, err) && tt.wantErr != nil {
+				assert.True(t, errors.Is(err, tt.wantErr), "error %v", err)
+			}
+		})
+	}
+}
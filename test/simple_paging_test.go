@@ -0,0 +1,195 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/fdingiit/mpl/pkg/simple"
+	"github.com/stretchr/testify/assert"
+)
+
+func pagedRequest(serialNo int, notes string) *simple.Request {
+	return &simple.Request{
+		Header: simple.Header{
+			Type:        simple.TypeRequest,
+			ServiceCode: 1000501,
+		},
+		UnixTimestamp: 1648811583,
+		SerialNo:      serialNo,
+		Currency:      2,
+		Amount:        100,
+		OutBankId:     2,
+		OutAccountId:  1234567899321,
+		InBankId:      2,
+		InAccountId:   3211541298661,
+		Notes:         notes,
+	}
+}
+
+func Test_SimplePaging_Single(t *testing.T) {
+	ctx := simple.WithChecksum(context.TODO(), simple.MD5Checksum)
+
+	req := pagedRequest(1, "short")
+	pages, err := simple.EncodePages(ctx, req, req.SerialNo, simple.DefaultPageSize)
+	if !assert.Nil(t, err) || !assert.Len(t, pages, 1) {
+		return
+	}
+	assert.Equal(t, simple.PageSingle, req.PageMark)
+
+	asm := &simple.Assembler{}
+	data, err := asm.Add(ctx, pages[0])
+	assert.Nil(t, err)
+	assert.Equal(t, pages[0], data)
+}
+
+func Test_SimplePaging_Split(t *testing.T) {
+	ctx := simple.WithChecksum(context.TODO(), simple.MD5Checksum)
+
+	// multi-byte notes make sure pages are never cut inside a character
+	notes := strings.Repeat("转账备注 <a&b> ", 40)
+	req1, req2 := pagedRequest(1, notes), pagedRequest(2, notes+"!")
+
+	pages1, err := simple.EncodePages(ctx, req1, req1.SerialNo, 100)
+	if !assert.Nil(t, err) {
+		return
+	}
+	pages2, err := simple.EncodePages(ctx, req2, req2.SerialNo, 100)
+	if !assert.Nil(t, err) {
+		return
+	}
+	if !assert.True(t, len(pages1) > 2) {
+		return
+	}
+
+	for i, page := range pages1 {
+		var p simple.Page
+		if !assert.Nil(t, p.Decode(ctx, page)) {
+			return
+		}
+		assert.Equal(t, i+1, p.PageNo)
+		assert.Equal(t, req1.SerialNo, p.SerialNo)
+		switch i {
+		case 0:
+			assert.Equal(t, simple.PageFirst, p.PageMark)
+		case len(pages1) - 1:
+			assert.Equal(t, simple.PageLast, p.PageMark)
+		default:
+			assert.Equal(t, simple.PageMiddle, p.PageMark)
+		}
+	}
+
+	// interleave the pages of both messages
+	asm := &simple.Assembler{}
+	var done [][]byte
+	for i := 0; i < len(pages1) || i < len(pages2); i++ {
+		for _, pages := range [][][]byte{pages1, pages2} {
+			if i >= len(pages) {
+				continue
+			}
+			data, err := asm.Add(ctx, pages[i])
+			if !assert.Nil(t, err) {
+				return
+			}
+			if data != nil {
+				done = append(done, data)
+			}
+		}
+	}
+	if !assert.Len(t, done, 2) {
+		return
+	}
+
+	for i, want := range []*simple.Request{req1, req2} {
+		var got simple.Request
+		if assert.Nil(t, got.Decode(ctx, done[i])) {
+			assert.Equal(t, simple.PageSingle, got.PageMark)
+			assert.Equal(t, want.SerialNo, got.SerialNo)
+			assert.Equal(t, want.Notes, got.Notes)
+			assert.Equal(t, want.InAccountId, got.InAccountId)
+		}
+	}
+}
+
+func Test_SimplePaging_Limits(t *testing.T) {
+	ctx := context.TODO()
+	notes := strings.Repeat("x", 1000)
+
+	req := pagedRequest(1, notes)
+	pages, err := simple.EncodePages(ctx, req, req.SerialNo, 100)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	tests := []struct {
+		name    string
+		asm     *simple.Assembler
+		feed    []int
+		wantErr error
+	}{
+		{name: "too many pages", asm: &simple.Assembler{MaxPages: 3}, feed: []int{0, 1, 2, 3}, wantErr: simple.ErrTooManyPages},
+		{name: "too large", asm: &simple.Assembler{MaxSize: 250}, feed: []int{0, 1, 2}, wantErr: simple.ErrMessageTooLarge},
+		{name: "duplicate page", asm: &simple.Assembler{}, feed: []int{0, 1, 1}, wantErr: simple.ErrPageSequence},
+		{name: "page after last", asm: &simple.Assembler{}, feed: []int{len(pages) - 1, len(pages) - 1}, wantErr: simple.ErrPageSequence},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var err error
+			for _, i := range tt.feed {
+				if _, err = tt.asm.Add(ctx, pages[i]); err != nil {
+					break
+				}
+			}
+			assert.True(t, errors.Is(err, tt.wantErr), "error %v", err)
+		})
+	}
+
+	_, err = simple.EncodePages(ctx, pagedRequest(1, strings.Repeat("x", 100*simple.DefaultMaxPages)), 1, 100)
+	assert.True(t, errors.Is(err, simple.ErrTooManyPages), "error %v", err)
+}
+
+func Test_SimplePaging_Pending(t *testing.T) {
+	ctx := context.TODO()
+	firstPage := func(serialNo int) []byte {
+		pages, err := simple.EncodePages(ctx, pagedRequest(serialNo, strings.Repeat("x", 300)), serialNo, 100)
+		if !assert.Nil(t, err) {
+			t.FailNow()
+		}
+		return pages[0]
+	}
+
+	asm := &simple.Assembler{MaxPending: 3}
+	for serialNo := 1; serialNo <= 3; serialNo++ {
+		data, err := asm.Add(ctx, firstPage(serialNo))
+		assert.Nil(t, err)
+		assert.Nil(t, data)
+	}
+	_, err := asm.Add(ctx, firstPage(4))
+	assert.True(t, errors.Is(err, simple.ErrTooManyPending), "error %v", err)
+
+	// single-page messages never wait
+	single, _ := pagedRequest(5, "short").Encode(ctx)
+	data, err := asm.Add(ctx, single)
+	assert.Nil(t, err)
+	assert.Equal(t, single, data)
+
+	// completing a message makes room for another one
+	pages, _ := simple.EncodePages(ctx, pagedRequest(1, strings.Repeat("x", 300)), 1, 100)
+	for _, page := range pages[1:] {
+		data, err = asm.Add(ctx, page)
+		assert.Nil(t, err)
+	}
+	assert.NotNil(t, data)
+	_, err = asm.Add(ctx, firstPage(4))
+	assert.Nil(t, err)
+
+	// the bytes held by incomplete messages are bounded as well
+	asm = &simple.Assembler{MaxSize: 250, MaxPendingSize: 250}
+	for serialNo := 1; serialNo <= 2; serialNo++ {
+		_, err := asm.Add(ctx, firstPage(serialNo))
+		assert.Nil(t, err)
+	}
+	_, err = asm.Add(ctx, firstPage(3))
+	assert.True(t, errors.Is(err, simple.ErrTooManyPending), "error %v", err)
+}